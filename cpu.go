@@ -3,18 +3,58 @@ package z80
 // CPU is the Z80 processor.
 type CPU struct {
 	reg    Registers
-	bus    Bus
 	cycles uint64
+	// Set by setBus when Step or the dispatch helpers have more to do
+	// than run the instruction and call the Bus.
+	slow bool
+
+	// Processor variant and its unprefixed dispatch table.
+	variant Variant
+	ops     *[256]opFunc
+	// Amount added to R on each M1 cycle (0 on variants without R).
+	refresh uint8
+	// R800 cycle counter wrapping bus; nil on other variants.
+	r800 *r800Bus
+	// eZ80 extended state; nil on other variants.
+	ez *ez80State
+	// The Bus given to New (wrapped on the eZ80), and the Bus the
+	// dispatch helpers call: ext, or the CPU's pageBus, which passes the
+	// accesses it does not serve to next, or wnext for writes.
+	ext, bus, next, wnext Bus
+	// Pages installed by MapMemory, served without calling the Bus, and
+	// the tables of them the dispatch helpers use.
+	mem                   *memMap
+	dfetch, dread, dwrite *[256]*[PageSize]uint8
+	// Translation cache; nil while the cache is off.
+	tc *tcache
+	// T-state execution state; nil until Tick is first called. ticking
+	// is set while an instruction's code runs under Tick.
+	tick    *ticker
+	ticking bool
+	// Bus tracking (SetBusTracking): the last bus cycle performed, for
+	// Pins outside Tick, and the number of memory and I/O accesses made,
+	// counting one in progress.
+	tracking bool
+	lastBus  busAccess
+	accesses uint64
+	// Called before each instruction; nil when tracing is off.
+	traceHook func(cycle uint64, regs Registers)
 
 	// Interrupt state.
-	intLine    bool  // INT line level (active when true)
-	intData    uint8 // Data bus value for interrupt acknowledge
-	nmiPending bool  // NMI edge latch (consumed on next Step)
-	afterEI    bool  // Suppress interrupts for one instruction after EI
+	intLine    bool   // INT line level (active when true)
+	intData    uint8  // Data bus value for interrupt acknowledge
+	nmiPending bool   // NMI edge latch (consumed on next Step)
+	intAt      uint64 // Cycle INT was asserted at (0: always)
+	intEnd     uint64 // Cycle INT is released at; noEvent when not pending
+	nmiAt      uint64 // Cycle of the latched NMI edge (0: always)
+	afterEI    bool   // Suppress interrupts for one instruction after EI
+	afterLDAIR bool   // Last instruction was LD A,I or LD A,R (NMOS P/V quirk)
 
 	// Cycle deficit from StepCycles when an instruction's cost
 	// exceeded the budget.
 	deficit int
+	// Cycle count at which RunCycles returns early; noEvent when unset.
+	nextEvent uint64
 
 	// DD/FD prefix support: points to HL, IX, or IY.
 	ixiyReg *uint16
@@ -23,8 +63,14 @@ type CPU struct {
 }
 
 // New creates a CPU wired to the given bus and performs a reset.
-func New(bus Bus) *CPU {
-	c := &CPU{bus: bus}
+// Options select the processor variant; with none, a Z80 is emulated.
+func New(bus Bus, opts ...Option) *CPU {
+	c := &CPU{ext: bus, mem: &memMap{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.configure()
+	c.setBus()
 	c.ixiyReg = &c.reg.HL
 	c.Reset()
 	return c
@@ -32,19 +78,43 @@ func New(bus Bus) *CPU {
 
 // Reset reinitializes the CPU to its power-on state:
 // PC=0, SP=0xFFFF, AF=0xFFFF, interrupts disabled, IM 0, clears HALT.
+// On the 8080 the fixed F bits are applied, giving AF=0xFFD7; on the
+// LR35902 the low nibble of F is clear, giving AF=0xFFF0.
 // The total cycle counter is reset to 0. Bus state is not affected.
+// An instruction left part-way by Tick is abandoned.
 func (c *CPU) Reset() {
+	if c.tick != nil {
+		c.tick.abort()
+	}
 	c.reg = Registers{
 		AF: 0xFFFF,
 		SP: 0xFFFF,
 	}
+	switch c.variant {
+	case Variant8080:
+		c.reg.AF = 0xFF00 | uint16(flags8080(0xFF))
+	case VariantLR35902:
+		c.reg.AF = 0xFFF0
+	}
 	c.cycles = 0
 	c.deficit = 0
+	c.nextEvent = noEvent
 	c.intLine = false
 	c.intData = 0xFF
+	c.intAt = 0
+	c.intEnd = noEvent
 	c.nmiPending = false
+	c.nmiAt = 0
 	c.afterEI = false
+	c.afterLDAIR = false
 	c.ixiyReg = &c.reg.HL
+	c.lastBus = busAccess{}
+	if c.r800 != nil {
+		c.r800.reset()
+	}
+	if c.ez != nil {
+		c.ez.reset()
+	}
 }
 
 // Step executes a single instruction and returns the T-states consumed.
@@ -55,33 +125,95 @@ func (c *CPU) Reset() {
 //     service the maskable interrupt (cycles depend on IM).
 //  3. If halted, return 4 T-states (internal NOP).
 //  4. Otherwise fetch and execute the next instruction.
+//
+// If Tick has left an instruction part-way, Step finishes it instead.
 func (c *CPU) Step() int {
+	if c.slow {
+		return c.stepSlow()
+	}
 	before := c.cycles
+	if c.nmiPending || c.intLine || c.reg.Halted {
+		c.step()
+	} else {
+		// What step does when there is nothing to service.
+		c.afterEI = false
+		c.afterLDAIR = false
+		c.execute()
+	}
+	return int(c.cycles - before)
+}
 
+// stepSlow is Step while slow is set: it finishes an instruction left
+// part-way by Tick, or runs one step counting R800 cycles.
+func (c *CPU) stepSlow() int {
+	if c.tick != nil && c.tick.active {
+		return c.finishTick()
+	}
+	return c.stepCounted()
+}
+
+// stepCounted runs one step and returns its T-states, including R800
+// bus cycles.
+func (c *CPU) stepCounted() int {
+	before := c.cycles
+	c.step()
+	if c.r800 != nil {
+		c.cycles = before + c.r800.take()
+	}
+	return int(c.cycles - before)
+}
+
+// step performs the work of Step, charging Z80 T-states.
+func (c *CPU) step() {
 	// 1. NMI has highest priority.
-	if c.nmiPending {
+	if c.nmiPending && c.nmiSampled() {
 		c.nmiPending = false
 		c.serviceNMI()
-		return int(c.cycles - before)
+		return
 	}
 
 	// 2. Maskable interrupt (subject to IFF1 and EI delay).
-	if c.intLine && c.reg.IFF1 && !c.afterEI {
+	if c.reg.IFF1 && !c.afterEI && c.intSampled() {
 		c.serviceINT()
-		return int(c.cycles - before)
+		return
 	}
 	c.afterEI = false
+	c.afterLDAIR = false
 
-	// 3. HALT burns NOP cycles.
+	// 3. HALT burns NOP cycles. The LR35902 leaves HALT when an
+	// interrupt is pending even if interrupts are disabled.
 	if c.reg.Halted {
-		c.cycles += 4
-		return int(c.cycles - before)
+		if c.variant != VariantLR35902 || !c.intSampled() {
+			c.busCycle(MCycleM1, c.reg.PC, 0x76, 0)
+			c.cycles += 4
+			return
+		}
+		c.reg.Halted = false
 	}
 
 	// 4. Fetch and execute.
+	if c.slow {
+		c.executeSlow()
+		return
+	}
 	c.execute()
+}
 
-	return int(c.cycles - before)
+// executeSlow is execute while slow is set: it calls the trace hook and
+// runs the instruction on the eZ80 or from the translation cache.
+func (c *CPU) executeSlow() {
+	if c.traceHook != nil {
+		c.traceHook(c.cycles, c.reg)
+	}
+	if c.ez != nil {
+		c.ez80Execute()
+		return
+	}
+	if c.tc != nil && !c.ticking {
+		c.tcExecute()
+		return
+	}
+	c.execute()
 }
 
 // StepCycles executes a single instruction within the given cycle budget.
@@ -111,6 +243,58 @@ func (c *CPU) StepCycles(budget int) int {
 	return budget
 }
 
+// noEvent is the nextEvent value when no event target is set.
+const noEvent = ^uint64(0)
+
+// RunCycles executes instructions until at least n T-states have been
+// consumed and returns the number consumed, which may exceed n by part
+// of the last instruction. A deficit left by StepCycles is paid down
+// first. NMI and INT are checked between instructions exactly as Step
+// does.
+//
+// If an event target is set with SetNextEvent, RunCycles also returns
+// before starting an instruction once Cycles() has reached it, so the
+// host can run the event and call RunCycles again for the remainder.
+// For n <= 0 it does nothing and returns 0.
+func (c *CPU) RunCycles(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	ran := 0
+	if c.deficit > 0 {
+		if c.deficit >= n {
+			c.deficit -= n
+			return n
+		}
+		ran = c.deficit
+		c.deficit = 0
+	}
+	for ran < n && c.cycles < c.nextEvent {
+		if c.tc != nil && !c.MidInstruction() {
+			start := c.cycles
+			c.tcRun(start + uint64(n-ran))
+			ran += int(c.cycles - start)
+			continue
+		}
+		ran += c.Step()
+	}
+	return ran
+}
+
+// SetNextEvent sets the absolute cycle count, as returned by Cycles, at
+// which RunCycles returns early. It may be called from Bus methods while
+// RunCycles is running, for example when a write reprograms a timer. The
+// target is part of the save state: Deserialize restores it, and clears
+// it for a save state that predates it.
+func (c *CPU) SetNextEvent(cycle uint64) {
+	c.nextEvent = cycle
+}
+
+// ClearNextEvent removes the event target set by SetNextEvent.
+func (c *CPU) ClearNextEvent() {
+	c.nextEvent = noEvent
+}
+
 // Deficit returns the remaining cycle debt from a previous StepCycles
 // call where the instruction cost exceeded the budget.
 func (c *CPU) Deficit() int {
@@ -124,11 +308,38 @@ func (c *CPU) AddCycles(n uint64) {
 	c.cycles += n
 }
 
+// SetCycles sets the cycle counter, for restoring a snapshot that
+// records it. An event target set with SetNextEvent is not adjusted.
+func (c *CPU) SetCycles(n uint64) {
+	c.cycles = n
+}
+
+// SetTraceHook sets a function called before each instruction is
+// fetched, with the cycle count and the registers at that point.
+// Interrupt responses and HALT cycles are not reported. It is meant for
+// instruction traces such as those written by the trace package. A nil
+// fn removes the hook.
+func (c *CPU) SetTraceHook(fn func(cycle uint64, regs Registers)) {
+	c.traceHook = fn
+	c.setBus()
+}
+
 // Cycles returns the total T-state count since the last Reset.
 func (c *CPU) Cycles() uint64 {
 	return c.cycles
 }
 
+// Accesses returns the number of memory and I/O accesses the CPU has
+// made while bus tracking is on (see SetBusTracking), counting one in
+// progress when called from a Bus method. Opcode fetches served by
+// MapMemory pages or skipped by the translation cache are counted, so
+// the count does not depend on either. It is a running count for placing
+// events within an instruction: Reset does not clear it and save states
+// do not include it.
+func (c *CPU) Accesses() uint64 {
+	return c.accesses
+}
+
 // Halted returns true if the CPU is in HALT state, waiting for an interrupt.
 func (c *CPU) Halted() bool {
 	return c.reg.Halted
@@ -150,11 +361,18 @@ func (c *CPU) SetState(regs Registers) {
 func (c *CPU) fetchOpcode() uint8 {
 	val := c.fetchBus(c.reg.PC)
 	c.reg.PC++
-	c.reg.R = (c.reg.R & 0x80) | ((c.reg.R + 1) & 0x7F)
+	c.reg.R = (c.reg.R & 0x80) | ((c.reg.R + c.refresh) & 0x7F)
 	return val
 }
 
 // --- Bus dispatch helpers ---
+//
+// All accesses made by instruction code go through these, as one call to
+// bus. That is the Bus given to New unless setBus finds mapped pages or
+// accesses the CPU must see, when it is pageBus: pages in the direct
+// tables are served there, and other accesses go to the Bus or, when the
+// CPU must see them, to hookBus, which performs them with the mem and io
+// functions below or, under Tick, hands them to the ticker.
 
 func (c *CPU) fetchBus(addr uint16) uint8 {
 	return c.bus.Fetch(addr)
@@ -176,6 +394,213 @@ func (c *CPU) outBus(port uint16, val uint8) {
 	c.bus.Out(port, val)
 }
 
+// setBus chooses how Step and the dispatch helpers work, and must be
+// called whenever a setting it looks at changes. Under Tick, on the R800
+// and while bus tracking is on every access must be seen, so none are
+// served from the direct tables and all go to hookBus. While the
+// translation cache is on, writes go to hookBus so they drop stale
+// translations. Otherwise mapped pages are served directly and the rest
+// go straight to the Bus, which the helpers call themselves when no
+// pages are mapped. slow is set when Step has more to do than run the
+// instruction: under Tick or with an instruction left part-way by it, on
+// the R800 or eZ80, while bus tracking or the translation cache is on,
+// or with a trace hook.
+func (c *CPU) setBus() {
+	c.next, c.wnext = c.ext, c.ext
+	c.dfetch, c.dread, c.dwrite = &c.mem.fetch, &c.mem.read, &c.mem.write
+	hooked := c.ticking || c.r800 != nil || c.tracking
+	if hooked {
+		c.next, c.wnext = (*hookBus)(c), (*hookBus)(c)
+		c.dfetch, c.dread, c.dwrite = &noPages, &noPages, &noPages
+	}
+	if c.tc != nil {
+		c.wnext, c.dwrite = (*hookBus)(c), &noPages
+	}
+	c.bus = c.ext
+	if hooked || c.tc != nil || c.mem.pages > 0 {
+		c.bus = (*pageBus)(c)
+	}
+	c.slow = hooked || c.tc != nil || c.traceHook != nil || c.ez != nil ||
+		(c.tick != nil && c.tick.active)
+}
+
+// pageBus is the Bus the dispatch helpers call when pages are mapped or
+// accesses must be seen by the CPU. It is the CPU itself under another
+// method set.
+type pageBus CPU
+
+func (b *pageBus) Fetch(addr uint16) uint8 {
+	c := (*CPU)(b)
+	if p := c.dfetch[addr>>8]; p != nil {
+		return p[uint8(addr)]
+	}
+	return c.next.Fetch(addr)
+}
+
+func (b *pageBus) Read(addr uint16) uint8 {
+	c := (*CPU)(b)
+	if p := c.dread[addr>>8]; p != nil {
+		return p[uint8(addr)]
+	}
+	return c.next.Read(addr)
+}
+
+func (b *pageBus) Write(addr uint16, val uint8) {
+	c := (*CPU)(b)
+	if p := c.dwrite[addr>>8]; p != nil {
+		p[uint8(addr)] = val
+		return
+	}
+	c.wnext.Write(addr, val)
+}
+
+func (b *pageBus) In(port uint16) uint8 {
+	return (*CPU)(b).next.In(port)
+}
+
+func (b *pageBus) Out(port uint16, val uint8) {
+	(*CPU)(b).next.Out(port, val)
+}
+
+// noPages is a page table with nothing mapped.
+var noPages [256]*[PageSize]uint8
+
+// hookBus is the Bus the dispatch helpers call when accesses must be
+// seen by the CPU. It is the CPU itself under another method set.
+type hookBus CPU
+
+func (h *hookBus) Fetch(addr uint16) uint8 {
+	c := (*CPU)(h)
+	if c.ticking {
+		return c.tick.request(MCycleM1, addr, 0, 0)
+	}
+	return c.memFetch(addr)
+}
+
+func (h *hookBus) Read(addr uint16) uint8 {
+	c := (*CPU)(h)
+	if c.ticking {
+		return c.tick.request(MCycleMR, addr, 0, 0)
+	}
+	return c.memRead(addr)
+}
+
+func (h *hookBus) Write(addr uint16, val uint8) {
+	c := (*CPU)(h)
+	if c.ticking {
+		c.tick.request(MCycleMW, addr, val, 0)
+		return
+	}
+	c.memWrite(addr, val)
+}
+
+func (h *hookBus) In(port uint16) uint8 {
+	c := (*CPU)(h)
+	if c.ticking {
+		return c.tick.request(MCycleIOR, port, 0, 0)
+	}
+	return c.ioIn(port)
+}
+
+func (h *hookBus) Out(port uint16, val uint8) {
+	c := (*CPU)(h)
+	if c.ticking {
+		c.tick.request(MCycleIOW, port, val, 0)
+		return
+	}
+	c.ioOut(port, val)
+}
+
+// memFetch performs an opcode fetch from a mapped page, or from the Bus
+// if the page is not mapped for fetches.
+func (c *CPU) memFetch(addr uint16) uint8 {
+	c.seen()
+	if c.r800 != nil {
+		c.r800.mem(addr)
+	}
+	var v uint8
+	if p := c.mem.fetch[addr>>8]; p != nil {
+		v = p[addr&0xFF]
+	} else {
+		v = c.ext.Fetch(addr)
+	}
+	c.lastBus = busAccess{MCycleM1, addr, v}
+	return v
+}
+
+// memRead performs a memory read from a mapped page or the Bus.
+func (c *CPU) memRead(addr uint16) uint8 {
+	c.seen()
+	if c.r800 != nil {
+		c.r800.mem(addr)
+	}
+	var v uint8
+	if p := c.mem.read[addr>>8]; p != nil {
+		v = p[addr&0xFF]
+	} else {
+		v = c.ext.Read(addr)
+	}
+	c.lastBus = busAccess{MCycleMR, addr, v}
+	return v
+}
+
+// memWrite performs a memory write to a mapped page or the Bus, dropping
+// any cached translation of the byte.
+func (c *CPU) memWrite(addr uint16, val uint8) {
+	c.seen()
+	if c.r800 != nil {
+		c.r800.mem(addr)
+	}
+	if c.tc != nil {
+		c.tc.invalidate(addr)
+	}
+	if p := c.mem.write[addr>>8]; p != nil {
+		p[addr&0xFF] = val
+	} else {
+		c.ext.Write(addr, val)
+	}
+	c.lastBus = busAccess{MCycleMW, addr, val}
+}
+
+// ioIn performs an I/O read through the Bus.
+func (c *CPU) ioIn(port uint16) uint8 {
+	c.seen()
+	if c.r800 != nil {
+		c.r800.io()
+	}
+	v := c.ext.In(port)
+	c.lastBus = busAccess{MCycleIOR, port, v}
+	return v
+}
+
+// ioOut performs an I/O write through the Bus.
+func (c *CPU) ioOut(port uint16, val uint8) {
+	c.seen()
+	if c.r800 != nil {
+		c.r800.io()
+	}
+	c.ext.Out(port, val)
+	c.lastBus = busAccess{MCycleIOW, port, val}
+}
+
+// seen counts an access about to be made, while bus tracking is on.
+func (c *CPU) seen() {
+	if c.tracking {
+		c.accesses++
+	}
+}
+
+// SetBusTracking turns bus tracking on or off. While it is on the CPU
+// records every access it makes outside Tick: Pins reports the last bus
+// cycle after Step, and Accesses counts the accesses. It is off by
+// default because it sends every access, including those to MapMemory
+// pages, through the CPU before the Bus. The replay package turns it on
+// in Attach.
+func (c *CPU) SetBusTracking(on bool) {
+	c.tracking = on
+	c.setBus()
+}
+
 // --- Memory access helpers ---
 
 // fetchPC reads the byte at PC and advances PC by 1.
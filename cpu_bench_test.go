@@ -1,12 +1,213 @@
 package z80
 
-import "testing"
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+var zexPath = flag.String("zexall", "", "path to zexall.com (or zexdoc.com) for BenchmarkZEXALL")
 
 func BenchmarkStep_PlainBus(b *testing.B) {
 	bus := &testBus{}
 	cpu := New(bus)
-	b.ResetTimer()
 	for b.Loop() {
 		cpu.Step()
 	}
 }
+
+// workloadProgram copies a 256-byte block with LDIR, then checksums it
+// through IX with a CALL per byte, and loops forever. It mixes memory,
+// indexed, prefixed, branch and stack instructions.
+var workloadProgram = []uint8{
+	0x21, 0x00, 0x40, // 0000 LD HL,4000h
+	0x11, 0x00, 0x80, // 0003 LD DE,8000h
+	0x01, 0x00, 0x01, // 0006 LD BC,0100h
+	0xED, 0xB0, // 0009 LDIR
+	0xDD, 0x21, 0x00, 0x80, // 000B LD IX,8000h
+	0x06, 0x00, // 000F LD B,0
+	0xAF,             // 0011 XOR A
+	0xDD, 0x86, 0x00, // 0012 ADD A,(IX+0)
+	0x07,       // 0015 RLCA
+	0xDD, 0x23, // 0016 INC IX
+	0xCD, 0x20, 0x00, // 0018 CALL 0020h
+	0x10, 0xF5, // 001B DJNZ 0012h
+	0xC3, 0x00, 0x00, // 001D JP 0000h
+	0xF5,       // 0020 PUSH AF
+	0x4F,       // 0021 LD C,A
+	0xCB, 0x39, // 0022 SRL C
+	0xF1, // 0024 POP AF
+	0xC9, // 0025 RET
+}
+
+// BenchmarkStep_Workload measures Step on a mixed instruction stream
+// rather than the NOP sled of BenchmarkStep_PlainBus.
+func BenchmarkStep_Workload(b *testing.B) {
+	bus := &testBus{}
+	copy(bus.mem[:], workloadProgram)
+	for i := range 256 {
+		bus.mem[0x4000+i] = uint8(i * 7)
+	}
+	cpu := New(bus)
+	cpu.reg.SP = 0xFFFE
+	for b.Loop() {
+		cpu.Step()
+	}
+	b.ReportMetric(float64(cpu.Cycles())/float64(b.N), "T-states/op")
+}
+
+// BenchmarkStep_WorkloadCached runs the workload from mapped memory with
+// the translation cache on.
+func BenchmarkStep_WorkloadCached(b *testing.B) {
+	cpu := newWorkloadBench(b, true)
+	for b.Loop() {
+		cpu.Step()
+	}
+}
+
+// newWorkloadBench returns a CPU running the workload from mapped memory,
+// with the translation cache on if cached is set.
+func newWorkloadBench(b *testing.B, cached bool) *CPU {
+	bus := &testBus{}
+	copy(bus.mem[:], workloadProgram)
+	for i := range 256 {
+		bus.mem[0x4000+i] = uint8(i * 7)
+	}
+	cpu := New(bus)
+	if err := cpu.MapMemory(0x0000, bus.mem[:], PageRead|PageWrite); err != nil {
+		b.Fatal(err)
+	}
+	if err := cpu.SetTranslationCache(cached); err != nil {
+		b.Fatal(err)
+	}
+	cpu.reg.SP = 0xFFFE
+	return cpu
+}
+
+// BenchmarkRunCycles_WorkloadMapped and BenchmarkRunCycles_WorkloadCached
+// run the workload from mapped memory a 70000-cycle frame at a time,
+// without and with the translation cache.
+func BenchmarkRunCycles_WorkloadMapped(b *testing.B) { benchRunCycles(b, false) }
+func BenchmarkRunCycles_WorkloadCached(b *testing.B) { benchRunCycles(b, true) }
+
+func benchRunCycles(b *testing.B, cached bool) {
+	cpu := newWorkloadBench(b, cached)
+	for b.Loop() {
+		cpu.RunCycles(70000)
+	}
+}
+
+// BenchmarkZEXALL runs the ZEXALL (or ZEXDOC) instruction exerciser to
+// completion once per iteration under a minimal CP/M environment: the
+// program is loaded at 0x0100, BDOS console output (functions 2 and 9)
+// at 0x0005 is collected, and the run ends when it jumps to 0x0000. The
+// benchmark fails unless the output reports every test passed. It
+// reports the emulated clock rate reached. Run with:
+//
+//	go test -run '^$' -bench ZEXALL -benchtime 1x -zexall /path/to/zexall.com
+func BenchmarkZEXALL(b *testing.B) {
+	if *zexPath == "" {
+		b.Skip("no -zexall path given")
+	}
+	prog, err := os.ReadFile(*zexPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bus := &testBus{}
+	cpu := New(bus)
+	var cycles uint64
+	for b.Loop() {
+		bus.mem = [65536]uint8{}
+		copy(bus.mem[0x0100:], prog)
+		bus.mem[0x0006] = 0x00 // top of TPA for the initial SP
+		bus.mem[0x0007] = 0xF0
+		cpu.Reset()
+		cpu.reg.PC = 0x0100
+		cpu.reg.SP = 0xF000
+		out := runCPM(cpu, bus)
+		if strings.Contains(out, "ERROR") || !strings.Contains(out, "Tests complete") {
+			b.Fatalf("exerciser failed:\n%s", out)
+		}
+		cycles += cpu.Cycles()
+	}
+	b.ReportMetric(float64(cycles)/b.Elapsed().Seconds()/1e6, "MHz")
+}
+
+// runCPM runs a CP/M program until it jumps to 0x0000 and returns its
+// console output. BDOS calls are answered in place of the call to
+// 0x0005.
+func runCPM(cpu *CPU, bus *testBus) string {
+	var out strings.Builder
+	for cpu.reg.PC != 0x0000 {
+		if cpu.reg.PC != 0x0005 {
+			cpu.Step()
+			continue
+		}
+		switch cpu.getC() {
+		case 2:
+			out.WriteByte(cpu.getE())
+		case 9:
+			for addr := cpu.reg.DE; bus.mem[addr] != '$'; addr++ {
+				out.WriteByte(bus.mem[addr])
+			}
+		}
+		cpu.reg.PC = cpu.pop16()
+	}
+	return out.String()
+}
+
+// bankedBus models a typical system bus: four 16K slots, each selecting
+// a bank of ROM or RAM, decoded on every access.
+type bankedBus struct {
+	banks [4][]uint8
+	rom   [4]bool
+}
+
+func newBankedBus() *bankedBus {
+	b := &bankedBus{}
+	for i := range b.banks {
+		b.banks[i] = make([]uint8, 0x4000)
+	}
+	b.rom[0] = true
+	copy(b.banks[0], workloadProgram)
+	for i := range 256 {
+		b.banks[1][i] = uint8(i * 7)
+	}
+	return b
+}
+
+func (b *bankedBus) Fetch(addr uint16) uint8 { return b.Read(addr) }
+func (b *bankedBus) Read(addr uint16) uint8  { return b.banks[addr>>14][addr&0x3FFF] }
+func (b *bankedBus) Write(addr uint16, val uint8) {
+	if !b.rom[addr>>14] {
+		b.banks[addr>>14][addr&0x3FFF] = val
+	}
+}
+func (b *bankedBus) In(port uint16) uint8       { return 0xFF }
+func (b *bankedBus) Out(port uint16, val uint8) {}
+
+func benchBanked(b *testing.B, mapped bool) {
+	bus := newBankedBus()
+	cpu := New(bus)
+	if mapped {
+		for i, bank := range bus.banks {
+			flags := PageRead | PageWrite
+			if bus.rom[i] {
+				flags = PageRead
+			}
+			if err := cpu.MapMemory(uint16(i)<<14, bank, flags); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	cpu.reg.SP = 0xFFFE
+	for b.Loop() {
+		cpu.Step()
+	}
+}
+
+// BenchmarkStep_BankedBus and BenchmarkStep_BankedMapped run the workload
+// on a banked bus, with and without its banks mapped through MapMemory.
+func BenchmarkStep_BankedBus(b *testing.B)    { benchBanked(b, false) }
+func BenchmarkStep_BankedMapped(b *testing.B) { benchBanked(b, true) }
@@ -103,6 +103,32 @@ func TestAddCycles(t *testing.T) {
 	}
 }
 
+func TestAccesses(t *testing.T) {
+	// LD (8000h),A; IN A,(10h); ED-prefixed NEG, run three times.
+	code := []uint8{0x32, 0x00, 0x80, 0xDB, 0x10, 0xED, 0x44, 0x18, 0xF7}
+	for _, cached := range []bool{false, true} {
+		cpu, bus := newTestCPU()
+		copy(bus.mem[:], code)
+		if cached {
+			cpu.MapMemory(0, bus.mem[:], PageRead|PageWrite)
+			cpu.SetTranslationCache(true)
+		}
+		cpu.Step()
+		if got := cpu.Accesses(); got != 0 {
+			t.Errorf("cached=%v: Accesses = %d untracked, want 0", cached, got)
+		}
+		cpu.SetBusTracking(true)
+		for range 11 {
+			cpu.Step()
+		}
+		// 4 + 3 + 2 + 2 accesses per loop: LD, IN, NEG, JR, less the
+		// first LD.
+		if got := cpu.Accesses(); got != 29 {
+			t.Errorf("cached=%v: Accesses = %d, want 29", cached, got)
+		}
+	}
+}
+
 func TestHalted(t *testing.T) {
 	cpu, _ := newTestCPU()
 	if cpu.Halted() {
@@ -172,6 +198,70 @@ func TestStepCycles_DeficitPaydown(t *testing.T) {
 	}
 }
 
+func TestRunCycles_RunsAtLeastN(t *testing.T) {
+	cpu, _ := newTestCPU()
+	// NOPs take 4 T-states: 10 cycles needs three of them.
+	if ran := cpu.RunCycles(10); ran != 12 {
+		t.Errorf("RunCycles(10) = %d, want 12", ran)
+	}
+	if cpu.reg.PC != 3 {
+		t.Errorf("PC = %04x, want 0003", cpu.reg.PC)
+	}
+}
+
+func TestRunCycles_PaysDeficit(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xC3 // JP 0000h (10 T-states)
+	cpu.StepCycles(4)
+	if cpu.Deficit() != 6 {
+		t.Fatalf("Deficit = %d, want 6", cpu.Deficit())
+	}
+	if ran := cpu.RunCycles(4); ran != 4 || cpu.Deficit() != 2 {
+		t.Errorf("ran=%d deficit=%d, want 4 2", ran, cpu.Deficit())
+	}
+	if ran := cpu.RunCycles(5); ran != 12 || cpu.Deficit() != 0 {
+		t.Errorf("ran=%d deficit=%d, want 12 0", ran, cpu.Deficit())
+	}
+}
+
+func TestRunCycles_NonPositive(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xC3 // JP 0000h (10 T-states)
+	cpu.StepCycles(4)
+	for _, n := range []int{0, -5} {
+		if ran := cpu.RunCycles(n); ran != 0 || cpu.Deficit() != 6 || cpu.Cycles() != 10 {
+			t.Errorf("RunCycles(%d) = %d, deficit=%d cycles=%d, want 0 6 10", n, ran, cpu.Deficit(), cpu.Cycles())
+		}
+	}
+}
+
+func TestRunCycles_StopsAtEvent(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.SetNextEvent(10)
+	if ran := cpu.RunCycles(100); ran != 12 {
+		t.Errorf("RunCycles = %d, want 12", ran)
+	}
+	if ran := cpu.RunCycles(100); ran != 0 {
+		t.Errorf("RunCycles at event = %d, want 0", ran)
+	}
+	cpu.ClearNextEvent()
+	if ran := cpu.RunCycles(100); ran != 100 {
+		t.Errorf("RunCycles after clear = %d, want 100", ran)
+	}
+}
+
+func TestRunCycles_ServicesInterrupts(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0x38] = 0x76 // HALT
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.INT(true, 0xFF)
+	cpu.RunCycles(20)
+	if !cpu.Halted() {
+		t.Errorf("PC = %04x, want halted in the IM 1 handler", cpu.reg.PC)
+	}
+}
+
 func TestNMI(t *testing.T) {
 	cpu, bus := newTestCPU()
 	cpu.reg.PC = 0x0100
@@ -323,3 +413,117 @@ func TestNMI_PriorityOverINT(t *testing.T) {
 		t.Errorf("PC = %04x, want 0066 (NMI should take priority)", cpu.reg.PC)
 	}
 }
+
+func TestINTAt_SampledAtLastTState(t *testing.T) {
+	for _, tt := range []struct {
+		at   uint64
+		want uint16
+	}{
+		{22, 0x0038}, // high by the last T-state of LD A,(IX+0)
+		{23, 0x0005}, // too late: the NOP after it runs first
+	} {
+		cpu, bus := newTestCPU()
+		copy(bus.mem[:], []uint8{0x00, 0xDD, 0x7E, 0x00, 0x00}) // NOP; LD A,(IX+0); NOP
+		cpu.reg.SP = 0xFFFE
+		cpu.reg.IFF1 = true
+		cpu.reg.IM = 1
+		cpu.Step()
+		cpu.INTAt(true, 0xFF, tt.at)
+		cpu.Step() // ends at cycle 23
+		cpu.Step()
+		if cpu.reg.PC != tt.want {
+			t.Errorf("INT at %d: PC = %04x, want %04x", tt.at, cpu.reg.PC, tt.want)
+		}
+	}
+}
+
+func TestINTAt_ReleasedAfterSamplingPoint(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.Step() // NOP, cycles = 4
+	cpu.INTAt(true, 0xFF, 1)
+	cpu.INTAt(false, 0, 4) // still high at the sampling point, T-state 3
+	cpu.Step()
+	if cpu.reg.PC != 0x0038 {
+		t.Errorf("PC = %04x, want 0038", cpu.reg.PC)
+	}
+
+	cpu, _ = newTestCPU()
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.INTAt(true, 0xFF, 1)
+	cpu.INTAt(false, 0, 3) // released before the sampling point
+	cpu.Step()
+	cpu.Step()
+	if cpu.reg.PC != 0x0002 || cpu.intLine {
+		t.Errorf("PC = %04x line=%v, want 0002 released", cpu.reg.PC, cpu.intLine)
+	}
+}
+
+func TestINTAt_ReassertedWhileReleasePending(t *testing.T) {
+	for _, tt := range []struct {
+		release uint64
+		want    uint16
+	}{
+		{20, 0x0038}, // release cancelled: still high from cycle 5
+		{6, 0x0003},  // low from 6 to 9: missed by the NOP ending at 8
+	} {
+		cpu, _ := newTestCPU()
+		cpu.reg.SP = 0xFFFE
+		cpu.reg.IFF1 = true
+		cpu.reg.IM = 1
+		cpu.Step() // NOP, cycles = 4
+		cpu.INTAt(true, 0xFF, 5)
+		cpu.INTAt(false, 0xFF, tt.release)
+		cpu.INTAt(true, 0xFF, 9)
+		cpu.Step() // NOP, ends at cycle 8
+		cpu.Step()
+		if cpu.reg.PC != tt.want {
+			t.Errorf("release at %d: PC = %04x, want %04x", tt.release, cpu.reg.PC, tt.want)
+		}
+	}
+}
+
+func TestNMIAt(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.reg.SP = 0xFFFE
+	cpu.Step()   // NOP, ends at cycle 4
+	cpu.NMIAt(4) // after its last T-state began
+	cpu.Step()
+	if cpu.reg.PC != 0x0002 {
+		t.Fatalf("PC = %04x, want NMI deferred one instruction", cpu.reg.PC)
+	}
+	cpu.Step()
+	if cpu.reg.PC != 0x0066 {
+		t.Errorf("PC = %04x, want 0066", cpu.reg.PC)
+	}
+}
+
+func TestINT_UnderTick(t *testing.T) {
+	for _, tt := range []struct {
+		ticks int
+		want  uint16
+	}{
+		{3, 0x0038}, // asserted before T4 of the NOP
+		{4, 0x0002}, // asserted after T4 began
+	} {
+		cpu, _ := newTestCPU()
+		cpu.reg.SP = 0xFFFE
+		cpu.reg.IFF1 = true
+		cpu.reg.IM = 1
+		for range tt.ticks {
+			cpu.Tick()
+		}
+		cpu.INT(true, 0xFF)
+		for cpu.MidInstruction() {
+			cpu.Tick()
+		}
+		for !cpu.Tick() {
+		}
+		if cpu.reg.PC != tt.want {
+			t.Errorf("INT after %d T-states: PC = %04x, want %04x", tt.ticks, cpu.reg.PC, tt.want)
+		}
+	}
+}
@@ -16,7 +16,7 @@ var (
 // execute fetches and runs the instruction at PC.
 func (c *CPU) execute() {
 	op := c.fetchOpcode()
-	baseOps[op](c, op)
+	c.ops[op](c, op)
 }
 
 func init() {
@@ -70,7 +70,7 @@ func prefixIXIY(c *CPU, reg *uint16) {
 		}
 	} else if h := ixOps[op]; h != nil {
 		h(c, op)
-	} else if h := baseOps[op]; h != nil {
+	} else if h := c.ops[op]; h != nil {
 		c.cycles += 4 // prefix cost
 		h(c, op)
 	} else {
@@ -80,9 +80,13 @@ func prefixIXIY(c *CPU, reg *uint16) {
 }
 
 // prefixED handles the ED prefix: fetch second opcode, dispatch through edOps.
-func prefixED(c *CPU, _ uint8) {
+func prefixED(c *CPU, _ uint8) { dispatchED(c, &edOps) }
+
+// dispatchED fetches the opcode following an ED prefix and runs its
+// handler from table. Variants with extra ED instructions pass their own.
+func dispatchED(c *CPU, table *[256]opFunc) {
 	op := c.fetchOpcode()
-	if h := edOps[op]; h != nil {
+	if h := table[op]; h != nil {
 		h(c, op)
 	} else {
 		c.cycles += 8 // ED + NOP
@@ -0,0 +1,93 @@
+package z80
+
+import "fmt"
+
+// flagNames names the F register bits from bit 7 down.
+var flagNames = [8]string{"S", "Z", "F5", "H", "F3", "P/V", "N", "C"}
+
+// DiffRegisters compares two register sets, such as this CPU's and a
+// reference emulator's, and returns one line per difference in the form
+// "A = 0x12, want 0x34", or nil if they are equal. The main registers
+// are compared a byte at a time; a differing F is followed by the flags
+// that differ, each as name:want->got.
+func DiffRegisters(got, want Registers) []string {
+	var d []string
+	check := func(name string, got, want uint8) {
+		if got != want {
+			d = append(d, fmt.Sprintf("%s = 0x%02X, want 0x%02X", name, got, want))
+		}
+	}
+	check16 := func(name string, got, want uint16) {
+		if got != want {
+			d = append(d, fmt.Sprintf("%s = 0x%04X, want 0x%04X", name, got, want))
+		}
+	}
+	checkBool := func(name string, got, want bool) {
+		if got != want {
+			d = append(d, fmt.Sprintf("%s = %v, want %v", name, got, want))
+		}
+	}
+
+	check("A", got.A(), want.A())
+	if f, wf := got.F(), want.F(); f != wf {
+		d = append(d, fmt.Sprintf("F = 0x%02X, want 0x%02X [%s]", uint8(f), uint8(wf), diffFlags(f, wf)))
+	}
+	check("B", got.B(), want.B())
+	check("C", got.C(), want.C())
+	check("D", got.D(), want.D())
+	check("E", got.E(), want.E())
+	check("H", got.H(), want.H())
+	check("L", got.L(), want.L())
+	check16("AF'", got.AF_, want.AF_)
+	check16("BC'", got.BC_, want.BC_)
+	check16("DE'", got.DE_, want.DE_)
+	check16("HL'", got.HL_, want.HL_)
+	check16("IX", got.IX, want.IX)
+	check16("IY", got.IY, want.IY)
+	check16("SP", got.SP, want.SP)
+	check16("PC", got.PC, want.PC)
+	check("I", got.I, want.I)
+	check("R", got.R, want.R)
+	checkBool("IFF1", got.IFF1, want.IFF1)
+	checkBool("IFF2", got.IFF2, want.IFF2)
+	if got.IM != want.IM {
+		d = append(d, fmt.Sprintf("IM = %d, want %d", got.IM, want.IM))
+	}
+	checkBool("Halted", got.Halted, want.Halted)
+	check16("WZ", got.WZ, want.WZ)
+	return d
+}
+
+// diffFlags lists the flags that differ between got and want.
+func diffFlags(got, want Flags) string {
+	var s string
+	for i, name := range flagNames {
+		bit := uint(7 - i)
+		g, w := got>>bit&1, want>>bit&1
+		if g != w {
+			if s != "" {
+				s += " "
+			}
+			s += fmt.Sprintf("%s:%d->%d", name, w, g)
+		}
+	}
+	return s
+}
+
+// DiffMemory compares two memory images that start at address base and
+// returns one line per differing byte in the form
+// "RAM[0x1234] = 0x12, want 0x34", or nil if they are equal. Images of
+// different lengths are compared over the shorter, with a line noting
+// the lengths.
+func DiffMemory(got, want []byte, base uint16) []string {
+	var d []string
+	for i := range min(len(got), len(want)) {
+		if got[i] != want[i] {
+			d = append(d, fmt.Sprintf("RAM[0x%04X] = 0x%02X, want 0x%02X", base+uint16(i), got[i], want[i]))
+		}
+	}
+	if len(got) != len(want) {
+		d = append(d, fmt.Sprintf("RAM length = %d, want %d", len(got), len(want)))
+	}
+	return d
+}
@@ -0,0 +1,46 @@
+package z80
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffRegisters(t *testing.T) {
+	a := Registers{AF: 0x1245, BC: 0x3456, SP: 0xFFFE, IM: 1, IFF1: true}
+	if d := DiffRegisters(a, a); d != nil {
+		t.Errorf("equal registers: got %q", d)
+	}
+
+	b := a
+	b.AF = 0x12C4 // S set, C clear
+	b.BC = 0x3457
+	b.IM = 2
+	b.IFF1 = false
+	b.Halted = true
+	want := []string{
+		"F = 0x45, want 0xC4 [S:1->0 C:0->1]",
+		"C = 0x56, want 0x57",
+		"IFF1 = true, want false",
+		"IM = 1, want 2",
+		"Halted = false, want true",
+	}
+	if got := DiffRegisters(a, b); !slices.Equal(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestDiffMemory(t *testing.T) {
+	got := []byte{1, 2, 3, 4}
+	want := []byte{1, 9, 3}
+	d := DiffMemory(got, want, 0x8000)
+	exp := []string{
+		"RAM[0x8001] = 0x02, want 0x09",
+		"RAM length = 4, want 3",
+	}
+	if !slices.Equal(d, exp) {
+		t.Errorf("got %q\nwant %q", d, exp)
+	}
+	if d := DiffMemory(got, got, 0); d != nil {
+		t.Errorf("equal memory: got %q", d)
+	}
+}
@@ -0,0 +1,563 @@
+package z80
+
+// Bus24 extends Bus with the 24-bit memory address space of the eZ80.
+//
+// The 16-bit Bus methods remain in use for I/O; the eZ80's I/O space is
+// still addressed with 16 bits. Memory is always accessed through the
+// 24-bit methods: in Z80 mode the upper address byte comes from the MB
+// register, in ADL mode from the full 24-bit pointer.
+type Bus24 interface {
+	Bus
+
+	// Fetch24 reads an opcode byte during an M1 cycle.
+	Fetch24(addr uint32) uint8
+
+	// Read24 reads a byte from the given 24-bit memory address.
+	Read24(addr uint32) uint8
+
+	// Write24 writes a byte to the given 24-bit memory address.
+	Write24(addr uint32, val uint8)
+}
+
+// EZ80Registers holds the eZ80 state that extends the Z80 register set.
+//
+// The low 16 bits of each register live in Registers; this struct holds
+// the upper bytes and the eZ80-only registers. In Z80 mode the stack
+// pointer is SPS (Registers.SP) and memory addresses are formed as
+// {MB, addr16}. In ADL mode the stack pointer is SPL and the program
+// counter is {PCU, Registers.PC}.
+type EZ80Registers struct {
+	BCU, DEU, HLU    uint8  // Upper bytes of BC, DE, HL
+	BCU_, DEU_, HLU_ uint8  // Upper bytes of the shadow pairs
+	IXU, IYU         uint8  // Upper bytes of IX, IY
+	SPL              uint32 // 24-bit stack pointer used in ADL mode
+	PCU              uint8  // PC[23:16] in ADL mode
+	MB               uint8  // Z80-mode memory base (address bits 23-16)
+	ADL              bool   // 24-bit addressing mode
+	MADL             bool   // Mixed-memory mode for interrupts
+}
+
+// ez80State is the per-CPU state for VariantEZ80.
+type ez80State struct {
+	EZ80Registers
+	bus Bus24
+
+	// Set while a 24-bit access is made through the CPU's 16-bit
+	// dispatch helpers, with the upper address byte ez80Bus supplies in
+	// place of MB.
+	wide  bool
+	upper uint8
+
+	// Per-instruction widths: l selects 24-bit registers and indirect
+	// memory addresses, il selects 24-bit immediates. Set from ADL at the
+	// start of each instruction and overridden by the .SIS, .LIS, .SIL
+	// and .LIL suffixes.
+	l, il    bool
+	suffixed bool
+
+	// Address of the first byte (including any suffix) of the
+	// instruction being executed, for repeating block instructions.
+	start uint32
+
+	// Upper byte paired with CPU.ixiyReg, and the pre-computed indexed
+	// address for DD CB / FD CB instructions.
+	idxU    *uint8
+	idxAddr uint32
+}
+
+// ez80Bus adapts the eZ80's 24-bit memory space to the 16-bit Bus used
+// by the CPU's dispatch helpers, supplying MB as the upper address byte,
+// or the upper byte of the 24-bit access in progress.
+type ez80Bus struct {
+	Bus24
+	e *ez80State
+}
+
+func (b *ez80Bus) Fetch(addr uint16) uint8 {
+	return b.Fetch24(b.e.addr24(addr))
+}
+
+func (b *ez80Bus) Read(addr uint16) uint8 {
+	return b.Read24(b.e.addr24(addr))
+}
+
+func (b *ez80Bus) Write(addr uint16, val uint8) {
+	b.Write24(b.e.addr24(addr), val)
+}
+
+// addr24 extends a 16-bit address from the dispatch helpers to 24 bits.
+func (e *ez80State) addr24(addr uint16) uint32 {
+	if e.wide {
+		return uint32(e.upper)<<16 | uint32(addr)
+	}
+	return uint32(e.MB)<<16 | uint32(addr)
+}
+
+// flatBus24 lets a plain 16-bit Bus drive an eZ80 by ignoring address
+// bits 23-16.
+type flatBus24 struct {
+	Bus
+}
+
+func (b flatBus24) Fetch24(addr uint32) uint8      { return b.Fetch(uint16(addr)) }
+func (b flatBus24) Read24(addr uint32) uint8       { return b.Read(uint16(addr)) }
+func (b flatBus24) Write24(addr uint32, val uint8) { b.Write(uint16(addr), val) }
+
+// newEZ80State wraps bus for VariantEZ80. A bus that does not implement
+// Bus24 sees only the low 16 address bits.
+func newEZ80State(bus Bus) *ez80State {
+	b24, ok := bus.(Bus24)
+	if !ok {
+		b24 = flatBus24{bus}
+	}
+	return &ez80State{bus: b24}
+}
+
+// reset applies the eZ80 power-on state: Z80 mode, MB=0, upper register
+// bytes cleared, SPL=0xFFFFFF.
+func (e *ez80State) reset() {
+	e.EZ80Registers = EZ80Registers{SPL: 0xFFFFFF}
+	e.l, e.il, e.suffixed = false, false, false
+	e.wide = false
+	e.idxU = &e.HLU
+}
+
+// EZ80Registers returns a snapshot of the eZ80 extended registers.
+// On other variants it returns the zero value.
+func (c *CPU) EZ80Registers() EZ80Registers {
+	if c.ez == nil {
+		return EZ80Registers{}
+	}
+	return c.ez.EZ80Registers
+}
+
+// SetEZ80State sets the eZ80 extended registers directly. It has no
+// effect on other variants.
+func (c *CPU) SetEZ80State(regs EZ80Registers) {
+	if c.ez == nil {
+		return
+	}
+	regs.SPL &= 0xFFFFFF
+	c.ez.EZ80Registers = regs
+}
+
+// --- Execution ---
+
+// ez80Execute runs one instruction on VariantEZ80. Z80 mode uses the Z80
+// tables (with the eZ80 additions); ADL mode uses the width-aware tables.
+func (c *CPU) ez80Execute() {
+	e := c.ez
+	if !e.ADL {
+		e.start = uint32(e.MB)<<16 | uint32(c.reg.PC)
+		c.execute()
+		return
+	}
+	e.start = c.ezPCAddr()
+	e.l, e.il, e.suffixed = true, true, false
+	op := c.ezFetchOpcode()
+	ezOps[op](c, op)
+}
+
+// ezSuffix handles the .SIS (0x40), .LIS (0x49), .SIL (0x52) and .LIL
+// (0x5B) prefixes, which set the data and immediate widths of the
+// following instruction. It runs the instruction from the width-aware
+// table in either mode.
+func ezSuffix(c *CPU, op uint8) {
+	e := c.ez
+	e.l = op == 0x49 || op == 0x5B
+	e.il = op == 0x52 || op == 0x5B
+	e.suffixed = true
+	c.cycles += 4
+	next := c.ezFetchOpcode()
+	ezOps[next](c, next)
+	e.suffixed = false
+}
+
+// ezShort runs a width-aware handler with 16-bit widths. It is used for
+// eZ80 additions executed in Z80 mode without a suffix.
+func ezShort(h opFunc) opFunc {
+	return func(c *CPU, op uint8) {
+		e := c.ez
+		e.l, e.il, e.suffixed = false, false, false
+		h(c, op)
+	}
+}
+
+// --- Program counter and immediates ---
+
+// ezPCAddr returns the 24-bit address of the next instruction byte.
+func (c *CPU) ezPCAddr() uint32 {
+	if c.ez.ADL {
+		return uint32(c.ez.PCU)<<16 | uint32(c.reg.PC)
+	}
+	return uint32(c.ez.MB)<<16 | uint32(c.reg.PC)
+}
+
+// ezSetPC sets the program counter from a 24-bit value. In Z80 mode only
+// the low 16 bits are used.
+func (c *CPU) ezSetPC(v uint32) {
+	c.reg.PC = uint16(v)
+	if c.ez.ADL {
+		c.ez.PCU = uint8(v >> 16)
+	}
+}
+
+// ezPC returns the program counter as a 24-bit value in ADL mode or a
+// 16-bit value in Z80 mode.
+func (c *CPU) ezPC() uint32 {
+	if c.ez.ADL {
+		return uint32(c.ez.PCU)<<16 | uint32(c.reg.PC)
+	}
+	return uint32(c.reg.PC)
+}
+
+func (c *CPU) ezAdvancePC() {
+	c.reg.PC++
+	if c.reg.PC == 0 && c.ez.ADL {
+		c.ez.PCU++
+	}
+}
+
+// ezFetchOpcode reads an opcode byte with an M1 cycle and increments R.
+func (c *CPU) ezFetchOpcode() uint8 {
+	val := c.ezFetchBus(c.ezPCAddr())
+	c.ezAdvancePC()
+	c.reg.R = (c.reg.R & 0x80) | ((c.reg.R + c.refresh) & 0x7F)
+	return val
+}
+
+// ezFetch reads the byte at PC and advances PC.
+func (c *CPU) ezFetch() uint8 {
+	val := c.ezReadBus(c.ezPCAddr())
+	c.ezAdvancePC()
+	return val
+}
+
+// ezFetchImm reads a 16-bit immediate, or a 24-bit one when il is set
+// (charging one extra memory cycle).
+func (c *CPU) ezFetchImm() uint32 {
+	v := uint32(c.ezFetch())
+	v |= uint32(c.ezFetch()) << 8
+	if c.ez.il {
+		v |= uint32(c.ezFetch()) << 16
+		c.cycles += 3
+	}
+	return v
+}
+
+// ezImmAddr forms a memory address from an immediate: 24 bits when il
+// is set, otherwise {MB, addr16}.
+func (c *CPU) ezImmAddr(v uint32) uint32 {
+	if c.ez.il {
+		return v & 0xFFFFFF
+	}
+	return uint32(c.ez.MB)<<16 | v&0xFFFF
+}
+
+// --- Memory ---
+
+// ezAddr forms a memory address from a register value: 24 bits when l
+// is set, otherwise {MB, addr16}.
+func (c *CPU) ezAddr(v uint32) uint32 {
+	if c.ez.l {
+		return v & 0xFFFFFF
+	}
+	return uint32(c.ez.MB)<<16 | v&0xFFFF
+}
+
+func (c *CPU) ezRead(addr uint32) uint8 {
+	return c.ezReadBus(addr & 0xFFFFFF)
+}
+
+func (c *CPU) ezWrite(addr uint32, val uint8) {
+	c.ezWriteBus(addr&0xFFFFFF, val)
+}
+
+// ezFetchBus, ezReadBus and ezWriteBus make a 24-bit access through the
+// CPU's dispatch helpers, so Pins and bus tracking see it as they do a
+// Z80-mode access; Pins shows the low 16 bits of the address. Tick does
+// not place cycles at eZ80 timings, so under Tick the access is made at
+// once and the instruction's cycles are reported as internal.
+
+func (c *CPU) ezFetchBus(addr uint32) uint8 {
+	c.ez.wide, c.ez.upper = true, uint8(addr>>16)
+	var v uint8
+	if c.ticking {
+		v = c.memFetch(uint16(addr))
+	} else {
+		v = c.fetchBus(uint16(addr))
+	}
+	c.ez.wide = false
+	return v
+}
+
+func (c *CPU) ezReadBus(addr uint32) uint8 {
+	c.ez.wide, c.ez.upper = true, uint8(addr>>16)
+	var v uint8
+	if c.ticking {
+		v = c.memRead(uint16(addr))
+	} else {
+		v = c.readBus(uint16(addr))
+	}
+	c.ez.wide = false
+	return v
+}
+
+func (c *CPU) ezWriteBus(addr uint32, val uint8) {
+	c.ez.wide, c.ez.upper = true, uint8(addr>>16)
+	if c.ticking {
+		c.memWrite(uint16(addr), val)
+	} else {
+		c.writeBus(uint16(addr), val)
+	}
+	c.ez.wide = false
+}
+
+// ezReadWord reads a little-endian 16-bit word, or a 24-bit one when l
+// is set (charging one extra memory cycle).
+func (c *CPU) ezReadWord(addr uint32) uint32 {
+	v := uint32(c.ezRead(addr))
+	v |= uint32(c.ezRead(addr+1)) << 8
+	if c.ez.l {
+		v |= uint32(c.ezRead(addr+2)) << 16
+		c.cycles += 3
+	}
+	return v
+}
+
+// ezWriteWord writes a little-endian 16-bit word, or a 24-bit one when l
+// is set (charging one extra memory cycle).
+func (c *CPU) ezWriteWord(addr uint32, v uint32) {
+	c.ezWrite(addr, uint8(v))
+	c.ezWrite(addr+1, uint8(v>>8))
+	if c.ez.l {
+		c.ezWrite(addr+2, uint8(v>>16))
+		c.cycles += 3
+	}
+}
+
+// --- Registers ---
+
+// ezMask truncates v to the current data width.
+func (c *CPU) ezMask(v uint32) uint32 {
+	if c.ez.l {
+		return v & 0xFFFFFF
+	}
+	return v & 0xFFFF
+}
+
+// ezGet returns the pair lo/up at the current data width.
+func (c *CPU) ezGet(lo *uint16, up *uint8) uint32 {
+	if c.ez.l {
+		return uint32(*up)<<16 | uint32(*lo)
+	}
+	return uint32(*lo)
+}
+
+// ezSet stores v into the pair lo/up. With 16-bit width the upper byte
+// is left unchanged.
+func (c *CPU) ezSet(lo *uint16, up *uint8, v uint32) {
+	*lo = uint16(v)
+	if c.ez.l {
+		*up = uint8(v >> 16)
+	}
+}
+
+// ezRR returns a register pair by 2-bit index: 0=BC, 1=DE, 2=HL (or
+// IX/IY), 3=SP. SP is SPL with 24-bit width and SPS otherwise.
+func (c *CPU) ezRR(idx uint8) uint32 {
+	e := c.ez
+	switch idx {
+	case 0:
+		return c.ezGet(&c.reg.BC, &e.BCU)
+	case 1:
+		return c.ezGet(&c.reg.DE, &e.DEU)
+	case 2:
+		return c.ezGet(c.ixiyReg, e.idxU)
+	}
+	if e.l {
+		return e.SPL
+	}
+	return uint32(c.reg.SP)
+}
+
+// ezSetRR stores v into a register pair by 2-bit index (see ezRR).
+func (c *CPU) ezSetRR(idx uint8, v uint32) {
+	e := c.ez
+	switch idx {
+	case 0:
+		c.ezSet(&c.reg.BC, &e.BCU, v)
+	case 1:
+		c.ezSet(&c.reg.DE, &e.DEU, v)
+	case 2:
+		c.ezSet(c.ixiyReg, e.idxU, v)
+	default:
+		if e.l {
+			e.SPL = v & 0xFFFFFF
+		} else {
+			c.reg.SP = uint16(v)
+		}
+	}
+}
+
+// ezHL returns the HL, IX or IY value selected by the current prefix.
+func (c *CPU) ezHL() uint32 { return c.ezRR(2) }
+
+// ezIndexed fetches a displacement and returns the address IX/IY + d.
+func (c *CPU) ezIndexed() uint32 {
+	d := int8(c.ezFetch())
+	return c.ezAddr(uint32(int32(c.ezHL()) + int32(d)))
+}
+
+// --- Stack ---
+
+// ezPushWidth pushes v onto SPL (3 bytes) when long is set, otherwise
+// onto {MB, SPS} (2 bytes).
+func (c *CPU) ezPushWidth(v uint32, long bool) {
+	e := c.ez
+	if long {
+		e.SPL = (e.SPL - 1) & 0xFFFFFF
+		c.ezWrite(e.SPL, uint8(v>>16))
+		e.SPL = (e.SPL - 1) & 0xFFFFFF
+		c.ezWrite(e.SPL, uint8(v>>8))
+		e.SPL = (e.SPL - 1) & 0xFFFFFF
+		c.ezWrite(e.SPL, uint8(v))
+		c.cycles += 3
+		return
+	}
+	c.reg.SP--
+	c.ezWrite(uint32(e.MB)<<16|uint32(c.reg.SP), uint8(v>>8))
+	c.reg.SP--
+	c.ezWrite(uint32(e.MB)<<16|uint32(c.reg.SP), uint8(v))
+}
+
+// ezPopWidth pops 3 bytes from SPL when long is set, otherwise 2 bytes
+// from {MB, SPS}.
+func (c *CPU) ezPopWidth(long bool) uint32 {
+	e := c.ez
+	if long {
+		v := uint32(c.ezRead(e.SPL))
+		e.SPL = (e.SPL + 1) & 0xFFFFFF
+		v |= uint32(c.ezRead(e.SPL)) << 8
+		e.SPL = (e.SPL + 1) & 0xFFFFFF
+		v |= uint32(c.ezRead(e.SPL)) << 16
+		e.SPL = (e.SPL + 1) & 0xFFFFFF
+		c.cycles += 3
+		return v
+	}
+	v := uint32(c.ezRead(uint32(e.MB)<<16 | uint32(c.reg.SP)))
+	c.reg.SP++
+	v |= uint32(c.ezRead(uint32(e.MB)<<16|uint32(c.reg.SP))) << 8
+	c.reg.SP++
+	return v
+}
+
+func (c *CPU) ezPush(v uint32) { c.ezPushWidth(v, c.ez.l) }
+func (c *CPU) ezPop() uint32   { return c.ezPopWidth(c.ez.l) }
+
+// ezPushMode pushes the mixed-mode byte recording the current ADL state
+// onto SPL (0x03 from ADL mode, 0x02 from Z80 mode).
+func (c *CPU) ezPushMode() {
+	e := c.ez
+	mode := uint8(0x02)
+	if e.ADL {
+		mode = 0x03
+	}
+	e.SPL = (e.SPL - 1) & 0xFFFFFF
+	c.ezWrite(e.SPL, mode)
+}
+
+// --- Control transfer ---
+
+// ezJump transfers control to target. With a suffix, ADL mode is set from
+// the immediate width (JP.SIS enters Z80 mode, JP.LIL enters ADL mode).
+func (c *CPU) ezJump(target uint32) {
+	if c.ez.suffixed {
+		c.ez.ADL = c.ez.il
+	}
+	c.ezSetPC(target)
+}
+
+// ezCall pushes the return address and jumps to target. A suffixed call
+// is a mixed-mode call: the return address goes onto the calling mode's
+// stack, followed by the mode byte on SPL, and ADL is set from the
+// immediate width.
+func (c *CPU) ezCall(target uint32) {
+	e := c.ez
+	if e.suffixed {
+		c.ezPushWidth(c.ezPC(), e.ADL)
+		c.ezPushMode()
+		e.ADL = e.il
+	} else {
+		c.ezPushWidth(c.ezPC(), e.l)
+	}
+	c.ezSetPC(target)
+}
+
+// ezReturn pops the return address. A suffixed return first pops the
+// mode byte pushed by a mixed-mode call or interrupt and restores ADL.
+func (c *CPU) ezReturn() {
+	e := c.ez
+	if e.suffixed {
+		mode := c.ezRead(e.SPL)
+		e.SPL = (e.SPL + 1) & 0xFFFFFF
+		e.ADL = mode&1 != 0
+		c.ezSetPC(c.ezPopWidth(e.ADL))
+		return
+	}
+	c.ezSetPC(c.ezPopWidth(e.l))
+}
+
+// ezRelJump adds a signed displacement to PC.
+func (c *CPU) ezRelJump(d int8) {
+	c.ezSetPC(uint32(int32(c.ezPC()) + int32(d)))
+}
+
+// --- Interrupts ---
+
+// ez80Interrupt pushes the return address for an interrupt taken in ADL
+// mode, or in either mode when MADL is set, and enters ADL mode. In
+// mixed-memory mode the mode byte follows the return address, as for a
+// suffixed CALL, so the handler returns with RETI.L or RETN.L.
+func (c *CPU) ez80Interrupt(vector uint32) {
+	e := c.ez
+	if e.MADL {
+		c.ezPushWidth(c.ezPC(), e.ADL)
+		c.ezPushMode()
+	} else {
+		c.ezPushWidth(c.ezPC(), true)
+	}
+	e.ADL = true
+	c.ezSetPC(vector)
+}
+
+// ez80ServiceNMI is serviceNMI for ADL or mixed-memory mode.
+func (c *CPU) ez80ServiceNMI() {
+	c.ez80Interrupt(0x0066)
+	c.cycles += 11
+}
+
+// ez80ServiceINT is serviceINT for ADL or mixed-memory mode. IM 2 reads
+// a 24-bit vector from {MB, I, data}.
+func (c *CPU) ez80ServiceINT() {
+	switch c.reg.IM {
+	case 1:
+		c.ez80Interrupt(0x0038)
+		c.cycles += 13
+	case 2:
+		e := c.ez
+		table := uint32(e.MB)<<16 | uint32(c.reg.I)<<8 | uint32(c.intData)
+		c.ez80Interrupt(0)
+		v := uint32(c.ezRead(table)) | uint32(c.ezRead(table+1))<<8 | uint32(c.ezRead(table+2))<<16
+		c.ezSetPC(v)
+		c.cycles += 19
+	default:
+		if c.intData&0xC7 == 0xC7 {
+			c.ez80Interrupt(uint32(c.intData & 0x38))
+			c.cycles += 11
+			return
+		}
+		c.ez80Interrupt(0x0038)
+		c.cycles += 13
+	}
+}
@@ -0,0 +1,1082 @@
+package z80
+
+import "sync"
+
+// eZ80 dispatch tables.
+//
+// ezOps and its prefix tables are width-aware: memory addresses, register
+// pairs, immediates and the stack follow the per-instruction widths set
+// from ADL mode or a suffix. They run every instruction in ADL mode and
+// every suffixed instruction in Z80 mode. Handlers that only touch 8-bit
+// registers are shared with the Z80 tables.
+//
+// ez80Z80Ops runs unsuffixed instructions in Z80 mode. It is baseOps with
+// the suffixes added, the eZ80 ED instructions added, and DD/FD routed to
+// the width-aware tables (which also hold the eZ80's indexed 16-bit
+// loads).
+//
+// Instructions with a Z80 counterpart take its T-states, plus 3 for each
+// extra byte transferred at 24-bit width. eZ80-only instructions are
+// charged 4 T-states per opcode fetch and 3 per other memory access, and
+// 4 per I/O access.
+var (
+	ezOps     [256]opFunc
+	ezCbOps   [256]opFunc
+	ezEdOps   [256]opFunc
+	ezIxOps   [256]opFunc
+	ezIxcbOps [256]opFunc
+
+	ez80Z80Ops   [256]opFunc
+	ez80Z80EdOps [256]opFunc
+
+	ez80Once sync.Once
+)
+
+// ezEdAdded lists the ED opcodes the eZ80 adds or redefines relative to
+// the Z80.
+var ezEdAdded = []uint8{
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x07, 0x08, 0x09, 0x0C, 0x0F,
+	0x10, 0x11, 0x12, 0x13, 0x14, 0x17, 0x18, 0x19, 0x1C, 0x1F,
+	0x20, 0x21, 0x22, 0x23, 0x24, 0x27, 0x28, 0x29, 0x2C, 0x2F,
+	0x31, 0x32, 0x33, 0x34, 0x37, 0x38, 0x39, 0x3C, 0x3E, 0x3F,
+	0x4C, 0x54, 0x55, 0x5C, 0x64, 0x65, 0x66, 0x6C, 0x6D, 0x6E,
+	0x74, 0x76, 0x7C, 0x7D, 0x7E,
+}
+
+func initEZ80Ops() {
+	initEZ80Base()
+	initEZ80CB()
+	initEZ80ED()
+	initEZ80IX()
+
+	ez80Z80Ops = baseOps
+	ez80Z80EdOps = edOps
+	for _, op := range []uint8{0x40, 0x49, 0x52, 0x5B} {
+		ez80Z80Ops[op] = ezSuffix
+	}
+	ez80Z80Ops[0xDD] = ezShort(ezPrefixDD)
+	ez80Z80Ops[0xFD] = ezShort(ezPrefixFD)
+	ez80Z80Ops[0xED] = func(c *CPU, _ uint8) { dispatchED(c, &ez80Z80EdOps) }
+	// Z80 undocumented mirrors the eZ80 does not implement.
+	for _, op := range []uint8{0x4E, 0x5D, 0x75} {
+		ez80Z80EdOps[op] = nil
+	}
+	for _, op := range ezEdAdded {
+		ez80Z80EdOps[op] = ezShort(ezEdOps[op])
+	}
+}
+
+// ezPrefixDD handles the DD prefix (IX register) in the width-aware tables.
+func ezPrefixDD(c *CPU, _ uint8) { ezPrefixIXIY(c, &c.reg.IX, &c.ez.IXU) }
+
+// ezPrefixFD handles the FD prefix (IY register) in the width-aware tables.
+func ezPrefixFD(c *CPU, _ uint8) { ezPrefixIXIY(c, &c.reg.IY, &c.ez.IYU) }
+
+// ezPrefixIXIY is prefixIXIY for the width-aware tables.
+func ezPrefixIXIY(c *CPU, reg *uint16, up *uint8) {
+	e := c.ez
+	prev, prevU := c.ixiyReg, e.idxU
+	c.ixiyReg, e.idxU = reg, up
+	op := c.ezFetchOpcode()
+	if op == 0xCB {
+		e.idxAddr = c.ezIndexed()
+		op2 := c.ezFetch()
+		c.cycles += 4 // extra prefix timing
+		ezIxcbOps[op2](c, op2)
+	} else if h := ezIxOps[op]; h != nil {
+		h(c, op)
+	} else {
+		c.cycles += 4 // prefix cost
+		ezOps[op](c, op)
+	}
+	c.ixiyReg, e.idxU = prev, prevU
+}
+
+// ezPrefixCB handles the CB prefix in the width-aware tables.
+func ezPrefixCB(c *CPU, _ uint8) {
+	op := c.ezFetchOpcode()
+	ezCbOps[op](c, op)
+}
+
+// ezPrefixED handles the ED prefix in the width-aware tables. ED
+// instructions always operate on HL, even after a DD or FD prefix.
+func ezPrefixED(c *CPU, _ uint8) {
+	e := c.ez
+	prev, prevU := c.ixiyReg, e.idxU
+	c.ixiyReg, e.idxU = &c.reg.HL, &e.HLU
+	op := c.ezFetchOpcode()
+	if h := ezEdOps[op]; h != nil {
+		h(c, op)
+	} else {
+		c.cycles += 8 // ED + NOP
+	}
+	c.ixiyReg, e.idxU = prev, prevU
+}
+
+func initEZ80Base() {
+	// Register-only handlers shared with the Z80.
+	for _, op := range []uint8{0x00, 0x07, 0x08, 0x0F, 0x17, 0x1F, 0x27, 0x2F, 0x37, 0x3F, 0x76, 0xF3, 0xFB} {
+		ezOps[op] = baseOps[op]
+	}
+	for i := uint8(0); i < 8; i++ {
+		if i != 6 {
+			ezOps[i<<3|0x04] = baseOps[i<<3|0x04] // INC r
+			ezOps[i<<3|0x05] = baseOps[i<<3|0x05] // DEC r
+		}
+	}
+	for op := 0x40; op < 0xC0; op++ {
+		if op&7 != 6 && (op >= 0x80 || op&0x38 != 0x30) {
+			ezOps[op] = baseOps[op] // LD r,r' and ALU A,r
+		}
+	}
+
+	// --- Suffixes: .SIS, .LIS, .SIL, .LIL ---
+	for _, op := range []uint8{0x40, 0x49, 0x52, 0x5B} {
+		ezOps[op] = ezSuffix
+	}
+
+	// --- Prefixes ---
+	ezOps[0xCB] = ezPrefixCB
+	ezOps[0xDD] = ezPrefixDD
+	ezOps[0xED] = ezPrefixED
+	ezOps[0xFD] = ezPrefixFD
+
+	// --- LD r, n ---
+	for i := uint8(0); i < 8; i++ {
+		op := i<<3 | 0x06
+		if i == 6 {
+			ezOps[op] = func(c *CPU, _ uint8) {
+				n := c.ezFetch()
+				c.ezWrite(c.ezAddr(c.ezHL()), n)
+				c.cycles += 10
+			}
+		} else {
+			ezOps[op] = func(c *CPU, op uint8) {
+				c.setR8((op>>3)&7, c.ezFetch())
+				c.cycles += 7
+			}
+		}
+	}
+
+	// --- LD r, (HL) / LD (HL), r ---
+	for op := 0x46; op < 0x80; op++ {
+		if op == 0x76 || (op&7 != 6 && op&0x38 != 0x30) {
+			continue
+		}
+		ezOps[op] = func(c *CPU, op uint8) {
+			addr := c.ezAddr(c.ezHL())
+			if op&7 == 6 {
+				c.setR8((op>>3)&7, c.ezRead(addr))
+			} else {
+				c.ezWrite(addr, c.getR8(op&7))
+			}
+			c.cycles += 7
+		}
+	}
+
+	// --- ALU A, (HL) / ALU A, n ---
+	for i := uint8(0); i < 8; i++ {
+		ezOps[i<<3|0x86] = func(c *CPU, op uint8) {
+			aluOp8(c, (op>>3)&7, c.ezRead(c.ezAddr(c.ezHL())))
+			c.cycles += 7
+		}
+		ezOps[i<<3|0xC6] = func(c *CPU, op uint8) {
+			aluOp8(c, (op>>3)&7, c.ezFetch())
+			c.cycles += 7
+		}
+	}
+
+	// --- INC (HL) / DEC (HL) ---
+	ezOps[0x34] = func(c *CPU, _ uint8) {
+		addr := c.ezAddr(c.ezHL())
+		val := c.ezRead(addr)
+		f := incFlags8(val)
+		c.ezWrite(addr, val+1)
+		c.setF(f | (c.getF() & flagC))
+		c.cycles += 11
+	}
+	ezOps[0x35] = func(c *CPU, _ uint8) {
+		addr := c.ezAddr(c.ezHL())
+		val := c.ezRead(addr)
+		f := decFlags8(val)
+		c.ezWrite(addr, val-1)
+		c.setF(f | (c.getF() & flagC))
+		c.cycles += 11
+	}
+
+	// --- LD A, (BC/DE) / LD (BC/DE), A ---
+	ezOps[0x0A] = func(c *CPU, _ uint8) {
+		c.setA(c.ezRead(c.ezAddr(c.ezRR(0))))
+		c.cycles += 7
+	}
+	ezOps[0x1A] = func(c *CPU, _ uint8) {
+		c.setA(c.ezRead(c.ezAddr(c.ezRR(1))))
+		c.cycles += 7
+	}
+	ezOps[0x02] = func(c *CPU, _ uint8) {
+		c.ezWrite(c.ezAddr(c.ezRR(0)), c.getA())
+		c.cycles += 7
+	}
+	ezOps[0x12] = func(c *CPU, _ uint8) {
+		c.ezWrite(c.ezAddr(c.ezRR(1)), c.getA())
+		c.cycles += 7
+	}
+
+	// --- LD A, (nn) / LD (nn), A ---
+	ezOps[0x3A] = func(c *CPU, _ uint8) {
+		addr := c.ezImmAddr(c.ezFetchImm())
+		c.setA(c.ezRead(addr))
+		c.cycles += 13
+	}
+	ezOps[0x32] = func(c *CPU, _ uint8) {
+		addr := c.ezImmAddr(c.ezFetchImm())
+		c.ezWrite(addr, c.getA())
+		c.cycles += 13
+	}
+
+	// --- LD rr, nn ---
+	for i := uint8(0); i < 4; i++ {
+		ezOps[i<<4|0x01] = func(c *CPU, op uint8) {
+			c.ezSetRR((op>>4)&3, c.ezFetchImm())
+			c.cycles += 10
+		}
+	}
+
+	// --- LD (nn), HL / LD HL, (nn) ---
+	ezOps[0x22] = func(c *CPU, _ uint8) {
+		addr := c.ezImmAddr(c.ezFetchImm())
+		c.ezWriteWord(addr, c.ezHL())
+		c.cycles += 16
+	}
+	ezOps[0x2A] = func(c *CPU, _ uint8) {
+		addr := c.ezImmAddr(c.ezFetchImm())
+		c.ezSetRR(2, c.ezReadWord(addr))
+		c.cycles += 16
+	}
+
+	// --- LD SP, HL ---
+	ezOps[0xF9] = func(c *CPU, _ uint8) {
+		c.ezSetRR(3, c.ezHL())
+		c.cycles += 6
+	}
+
+	// --- PUSH rr / POP rr ---
+	// AF occupies the low 16 bits of a 24-bit stack slot.
+	for i := uint8(0); i < 4; i++ {
+		ezOps[i<<4|0xC5] = func(c *CPU, op uint8) {
+			idx := (op >> 4) & 3
+			if idx == 3 {
+				c.ezPush(uint32(c.reg.AF))
+			} else {
+				c.ezPush(c.ezRR(idx))
+			}
+			c.cycles += 11
+		}
+		ezOps[i<<4|0xC1] = func(c *CPU, op uint8) {
+			idx := (op >> 4) & 3
+			v := c.ezPop()
+			if idx == 3 {
+				c.reg.AF = uint16(v)
+			} else {
+				c.ezSetRR(idx, v)
+			}
+			c.cycles += 10
+		}
+	}
+
+	// --- EX DE, HL ---
+	ezOps[0xEB] = func(c *CPU, _ uint8) {
+		e := c.ez
+		c.reg.DE, c.reg.HL = c.reg.HL, c.reg.DE
+		if e.l {
+			e.DEU, e.HLU = e.HLU, e.DEU
+		}
+		c.cycles += 4
+	}
+
+	// --- EXX ---
+	ezOps[0xD9] = func(c *CPU, _ uint8) {
+		e := c.ez
+		c.reg.BC, c.reg.BC_ = c.reg.BC_, c.reg.BC
+		c.reg.DE, c.reg.DE_ = c.reg.DE_, c.reg.DE
+		c.reg.HL, c.reg.HL_ = c.reg.HL_, c.reg.HL
+		if e.l {
+			e.BCU, e.BCU_ = e.BCU_, e.BCU
+			e.DEU, e.DEU_ = e.DEU_, e.DEU
+			e.HLU, e.HLU_ = e.HLU_, e.HLU
+		}
+		c.cycles += 4
+	}
+
+	// --- EX (SP), HL ---
+	ezOps[0xE3] = func(c *CPU, _ uint8) {
+		sp := c.ezAddr(c.ezRR(3))
+		val := c.ezReadWord(sp)
+		c.ezWriteWord(sp, c.ezHL())
+		c.ezSetRR(2, val)
+		c.cycles += 19
+	}
+
+	// --- INC rr / DEC rr ---
+	for i := uint8(0); i < 4; i++ {
+		ezOps[i<<4|0x03] = func(c *CPU, op uint8) {
+			idx := (op >> 4) & 3
+			c.ezSetRR(idx, c.ezRR(idx)+1)
+			c.cycles += 6
+		}
+		ezOps[i<<4|0x0B] = func(c *CPU, op uint8) {
+			idx := (op >> 4) & 3
+			c.ezSetRR(idx, c.ezRR(idx)-1)
+			c.cycles += 6
+		}
+	}
+
+	// --- ADD HL, rr ---
+	// C is the carry out of bit 15 or bit 23, H the carry out of bit 11.
+	for i := uint8(0); i < 4; i++ {
+		ezOps[i<<4|0x09] = func(c *CPU, op uint8) {
+			hl := c.ezHL()
+			val := c.ezRR((op >> 4) & 3)
+			result := hl + val
+			f := c.getF() & (flagS | flagZ | flagPV)
+			if result != c.ezMask(result) {
+				f |= flagC
+			}
+			if (hl^val^result)&0x1000 != 0 {
+				f |= flagH
+			}
+			f |= uint8(result>>8) & (flagF5 | flagF3)
+			c.setF(f)
+			c.ezSetRR(2, result)
+			c.cycles += 11
+		}
+	}
+
+	// --- JP nn / JP cc, nn ---
+	ezOps[0xC3] = func(c *CPU, _ uint8) {
+		c.ezJump(c.ezFetchImm())
+		c.cycles += 10
+	}
+	for i := uint8(0); i < 8; i++ {
+		ezOps[i<<3|0xC2] = func(c *CPU, op uint8) {
+			addr := c.ezFetchImm()
+			if c.testCC((op >> 3) & 7) {
+				c.ezJump(addr)
+			}
+			c.cycles += 10
+		}
+	}
+
+	// --- JP (HL) ---
+	// With a suffix, ADL mode is set from the data width.
+	ezOps[0xE9] = func(c *CPU, _ uint8) {
+		e := c.ez
+		target := c.ezHL()
+		if e.suffixed {
+			e.ADL = e.l
+		}
+		c.ezSetPC(target)
+		c.cycles += 4
+	}
+
+	// --- JR e / JR cc, e ---
+	ezOps[0x18] = func(c *CPU, _ uint8) {
+		c.ezRelJump(int8(c.ezFetch()))
+		c.cycles += 12
+	}
+	for i := uint8(0); i < 4; i++ {
+		ezOps[i<<3|0x20] = func(c *CPU, op uint8) {
+			d := int8(c.ezFetch())
+			if c.testCC((op >> 3) & 3) {
+				c.ezRelJump(d)
+				c.cycles += 12
+			} else {
+				c.cycles += 7
+			}
+		}
+	}
+
+	// --- DJNZ e ---
+	ezOps[0x10] = func(c *CPU, _ uint8) {
+		d := int8(c.ezFetch())
+		b := c.getB() - 1
+		c.setB(b)
+		if b != 0 {
+			c.ezRelJump(d)
+			c.cycles += 13
+		} else {
+			c.cycles += 8
+		}
+	}
+
+	// --- CALL nn / CALL cc, nn ---
+	ezOps[0xCD] = func(c *CPU, _ uint8) {
+		c.ezCall(c.ezFetchImm())
+		c.cycles += 17
+	}
+	for i := uint8(0); i < 8; i++ {
+		ezOps[i<<3|0xC4] = func(c *CPU, op uint8) {
+			addr := c.ezFetchImm()
+			if c.testCC((op >> 3) & 7) {
+				c.ezCall(addr)
+				c.cycles += 17
+			} else {
+				c.cycles += 10
+			}
+		}
+	}
+
+	// --- RET / RET cc ---
+	ezOps[0xC9] = func(c *CPU, _ uint8) {
+		c.ezReturn()
+		c.cycles += 10
+	}
+	for i := uint8(0); i < 8; i++ {
+		ezOps[i<<3|0xC0] = func(c *CPU, op uint8) {
+			if c.testCC((op >> 3) & 7) {
+				c.ezReturn()
+				c.cycles += 11
+			} else {
+				c.cycles += 5
+			}
+		}
+	}
+
+	// --- RST p ---
+	// With a suffix, RST is a mixed-mode call and ADL mode is set from
+	// the data width.
+	for i := uint8(0); i < 8; i++ {
+		ezOps[i<<3|0xC7] = func(c *CPU, op uint8) {
+			e := c.ez
+			if e.suffixed {
+				c.ezPushWidth(c.ezPC(), e.ADL)
+				c.ezPushMode()
+				e.ADL = e.l
+			} else {
+				c.ezPush(c.ezPC())
+			}
+			c.ezSetPC(uint32(op & 0x38))
+			c.cycles += 11
+		}
+	}
+
+	// --- IN A, (n) / OUT (n), A ---
+	ezOps[0xDB] = func(c *CPU, _ uint8) {
+		port := uint16(c.ezFetch()) | uint16(c.getA())<<8
+		c.setA(c.inBus(port))
+		c.cycles += 11
+	}
+	ezOps[0xD3] = func(c *CPU, _ uint8) {
+		port := uint16(c.ezFetch()) | uint16(c.getA())<<8
+		c.outBus(port, c.getA())
+		c.cycles += 11
+	}
+}
+
+func initEZ80CB() {
+	for op := 0; op < 256; op++ {
+		if op&7 != 6 {
+			ezCbOps[op] = cbOps[op]
+			continue
+		}
+		switch op >> 6 {
+		case 0: // Rotate/shift (HL)
+			ezCbOps[op] = func(c *CPU, op uint8) {
+				addr := c.ezAddr(c.ezHL())
+				result, f := cbRotShift((op>>3)&7, c.ezRead(addr), c.getF())
+				c.ezWrite(addr, result)
+				c.setF(f)
+				c.cycles += 15
+			}
+		case 1: // BIT b, (HL)
+			ezCbOps[op] = func(c *CPU, op uint8) {
+				bit := (op >> 3) & 7
+				addr := c.ezAddr(c.ezHL())
+				val := c.ezRead(addr)
+				f := c.getF()&flagC | flagH
+				if val&(1<<bit) == 0 {
+					f |= flagZ | flagPV
+				}
+				if bit == 7 && val&0x80 != 0 {
+					f |= flagS
+				}
+				f |= uint8(addr>>8) & (flagF3 | flagF5)
+				c.setF(f)
+				c.cycles += 12
+			}
+		case 2: // RES b, (HL)
+			ezCbOps[op] = func(c *CPU, op uint8) {
+				addr := c.ezAddr(c.ezHL())
+				c.ezWrite(addr, c.ezRead(addr)&^(1<<((op>>3)&7)))
+				c.cycles += 15
+			}
+		case 3: // SET b, (HL)
+			ezCbOps[op] = func(c *CPU, op uint8) {
+				addr := c.ezAddr(c.ezHL())
+				c.ezWrite(addr, c.ezRead(addr)|1<<((op>>3)&7))
+				c.cycles += 15
+			}
+		}
+	}
+}
+
+func initEZ80ED() {
+	// Register-only handlers shared with the Z80: NEG, IM, LD I/R,
+	// IN r,(C), OUT (C),r.
+	for _, op := range []uint8{0x44, 0x46, 0x56, 0x5E, 0x47, 0x4F, 0x57, 0x5F} {
+		ezEdOps[op] = edOps[op]
+	}
+	for i := uint8(0); i < 8; i++ {
+		ezEdOps[i<<3|0x40] = edOps[i<<3|0x40]
+		ezEdOps[i<<3|0x41] = edOps[i<<3|0x41]
+	}
+
+	// --- LD (nn), rr / LD rr, (nn) ---
+	for i := uint8(0); i < 4; i++ {
+		ezEdOps[i<<4|0x43] = func(c *CPU, op uint8) {
+			addr := c.ezImmAddr(c.ezFetchImm())
+			c.ezWriteWord(addr, c.ezRR((op>>4)&3))
+			c.cycles += 20
+		}
+		ezEdOps[i<<4|0x4B] = func(c *CPU, op uint8) {
+			addr := c.ezImmAddr(c.ezFetchImm())
+			c.ezSetRR((op>>4)&3, c.ezReadWord(addr))
+			c.cycles += 20
+		}
+	}
+
+	// --- ADC HL, rr / SBC HL, rr ---
+	for i := uint8(0); i < 4; i++ {
+		ezEdOps[i<<4|0x4A] = func(c *CPU, op uint8) {
+			c.ezAdcSbcHL(c.ezRR((op>>4)&3), false)
+			c.cycles += 15
+		}
+		ezEdOps[i<<4|0x42] = func(c *CPU, op uint8) {
+			c.ezAdcSbcHL(c.ezRR((op>>4)&3), true)
+			c.cycles += 15
+		}
+	}
+
+	// --- RLD / RRD ---
+	ezEdOps[0x6F] = func(c *CPU, _ uint8) {
+		addr := c.ezAddr(c.ezHL())
+		a := c.getA()
+		val := c.ezRead(addr)
+		c.ezWrite(addr, val<<4|a&0x0F)
+		c.ezDigit(a&0xF0 | val>>4)
+	}
+	ezEdOps[0x67] = func(c *CPU, _ uint8) {
+		addr := c.ezAddr(c.ezHL())
+		a := c.getA()
+		val := c.ezRead(addr)
+		c.ezWrite(addr, a<<4|val>>4)
+		c.ezDigit(a&0xF0 | val&0x0F)
+	}
+
+	// --- RETN / RETI ---
+	retn := func(c *CPU, _ uint8) {
+		c.ezReturn()
+		c.reg.IFF1 = c.reg.IFF2
+		c.cycles += 14
+	}
+	ezEdOps[0x45] = retn
+	ezEdOps[0x4D] = retn
+
+	// --- Block transfer and compare ---
+	ezEdOps[0xA0] = func(c *CPU, _ uint8) { c.ezBlockLD(1); c.cycles += 16 }
+	ezEdOps[0xA8] = func(c *CPU, _ uint8) { c.ezBlockLD(-1); c.cycles += 16 }
+	ezEdOps[0xB0] = func(c *CPU, _ uint8) { c.ezBlockRepeat(c.ezBlockLD(1) != 0) }
+	ezEdOps[0xB8] = func(c *CPU, _ uint8) { c.ezBlockRepeat(c.ezBlockLD(-1) != 0) }
+	ezEdOps[0xA1] = func(c *CPU, _ uint8) { c.ezBlockCP(1); c.cycles += 16 }
+	ezEdOps[0xA9] = func(c *CPU, _ uint8) { c.ezBlockCP(-1); c.cycles += 16 }
+	ezEdOps[0xB1] = func(c *CPU, _ uint8) {
+		bc := c.ezBlockCP(1)
+		c.ezBlockRepeat(bc != 0 && c.getF()&flagZ == 0)
+	}
+	ezEdOps[0xB9] = func(c *CPU, _ uint8) {
+		bc := c.ezBlockCP(-1)
+		c.ezBlockRepeat(bc != 0 && c.getF()&flagZ == 0)
+	}
+
+	// --- Block I/O ---
+	ezEdOps[0xA2] = func(c *CPU, _ uint8) { c.ezBlockIN(1); c.cycles += 16 }
+	ezEdOps[0xAA] = func(c *CPU, _ uint8) { c.ezBlockIN(-1); c.cycles += 16 }
+	ezEdOps[0xB2] = func(c *CPU, _ uint8) { c.ezBlockIN(1); c.ezBlockIORepeat() }
+	ezEdOps[0xBA] = func(c *CPU, _ uint8) { c.ezBlockIN(-1); c.ezBlockIORepeat() }
+	ezEdOps[0xA3] = func(c *CPU, _ uint8) { c.ezBlockOUT(1); c.cycles += 16 }
+	ezEdOps[0xAB] = func(c *CPU, _ uint8) { c.ezBlockOUT(-1); c.cycles += 16 }
+	ezEdOps[0xB3] = func(c *CPU, _ uint8) { c.ezBlockOUT(1); c.ezBlockIORepeat() }
+	ezEdOps[0xBB] = func(c *CPU, _ uint8) { c.ezBlockOUT(-1); c.ezBlockIORepeat() }
+
+	// --- eZ80 additions ---
+
+	// IN0 r, (n) / OUT0 (n), r: I/O with the upper port byte 0.
+	// 0x00=B, 0x08=C, 0x10=D, 0x18=E, 0x20=H, 0x28=L, 0x38=A
+	for i := uint8(0); i < 8; i++ {
+		if i == 6 {
+			continue
+		}
+		ezEdOps[i<<3] = func(c *CPU, op uint8) {
+			val := c.inBus(uint16(c.ezFetch()))
+			c.setR8((op>>3)&7, val)
+			c.setF(szFlags(val) | parityTable[val] | c.getF()&flagC)
+			c.cycles += 15
+		}
+		ezEdOps[i<<3|0x01] = func(c *CPU, op uint8) {
+			c.outBus(uint16(c.ezFetch()), c.getR8((op>>3)&7))
+			c.cycles += 15
+		}
+	}
+
+	// TST A, r / TST A, (HL) / TST A, n: AND without storing the result.
+	for i := uint8(0); i < 8; i++ {
+		ezEdOps[i<<3|0x04] = func(c *CPU, op uint8) {
+			r := (op >> 3) & 7
+			if r == 6 {
+				c.setF(logicFlags(c.getA()&c.ezRead(c.ezAddr(c.ezHL())), true))
+				c.cycles += 11
+				return
+			}
+			c.setF(logicFlags(c.getA()&c.getR8(r), true))
+			c.cycles += 8
+		}
+	}
+	ezEdOps[0x64] = func(c *CPU, _ uint8) {
+		c.setF(logicFlags(c.getA()&c.ezFetch(), true))
+		c.cycles += 11
+	}
+
+	// TSTIO n: AND port (C), with the upper port byte 0, with n.
+	ezEdOps[0x74] = func(c *CPU, _ uint8) {
+		n := c.ezFetch()
+		c.setF(logicFlags(c.inBus(uint16(c.getC()))&n, true))
+		c.cycles += 15
+	}
+
+	// LD rr, (HL) / LD (HL), rr
+	// 0x07=BC, 0x17=DE, 0x27=HL, 0x37=IX, 0x31=IY
+	// 0x0F=BC, 0x1F=DE, 0x2F=HL, 0x3F=IX, 0x3E=IY
+	for _, p := range []struct{ ld, st, pair uint8 }{
+		{0x07, 0x0F, ezBC}, {0x17, 0x1F, ezDE}, {0x27, 0x2F, ezHLPair},
+		{0x37, 0x3F, ezIX}, {0x31, 0x3E, ezIY},
+	} {
+		pair := p.pair
+		ezEdOps[p.ld] = func(c *CPU, _ uint8) {
+			lo, up := c.ezPair(pair)
+			c.ezSet(lo, up, c.ezReadWord(c.ezAddr(c.ezHL())))
+			c.cycles += 14
+		}
+		ezEdOps[p.st] = func(c *CPU, _ uint8) {
+			lo, up := c.ezPair(pair)
+			c.ezWriteWord(c.ezAddr(c.ezHL()), c.ezGet(lo, up))
+			c.cycles += 14
+		}
+	}
+
+	// LEA rr, IX+d / LEA rr, IY+d
+	for _, p := range []struct{ op, dst, src uint8 }{
+		{0x02, ezBC, ezIX}, {0x03, ezBC, ezIY},
+		{0x12, ezDE, ezIX}, {0x13, ezDE, ezIY},
+		{0x22, ezHLPair, ezIX}, {0x23, ezHLPair, ezIY},
+		{0x32, ezIX, ezIX}, {0x33, ezIY, ezIY},
+		{0x54, ezIX, ezIY}, {0x55, ezIY, ezIX},
+	} {
+		dst, src := p.dst, p.src
+		ezEdOps[p.op] = func(c *CPU, _ uint8) {
+			d := int8(c.ezFetch())
+			slo, sup := c.ezPair(src)
+			dlo, dup := c.ezPair(dst)
+			c.ezSet(dlo, dup, uint32(int32(c.ezGet(slo, sup))+int32(d)))
+			c.cycles += 11
+		}
+	}
+
+	// PEA IX+d / PEA IY+d
+	for _, p := range []struct{ op, src uint8 }{{0x65, ezIX}, {0x66, ezIY}} {
+		src := p.src
+		ezEdOps[p.op] = func(c *CPU, _ uint8) {
+			d := int8(c.ezFetch())
+			lo, up := c.ezPair(src)
+			c.ezPush(c.ezMask(uint32(int32(c.ezGet(lo, up)) + int32(d))))
+			c.cycles += 17
+		}
+	}
+
+	// MLT rr: rr[15:0] = rr[15:8] * rr[7:0]
+	// 0x4C=BC, 0x5C=DE, 0x6C=HL, 0x7C=SP
+	for i := uint8(0); i < 4; i++ {
+		ezEdOps[i<<4|0x4C] = func(c *CPU, op uint8) {
+			idx := (op >> 4) & 3
+			v := c.ezRR(idx)
+			c.ezSetRR(idx, v&^0xFFFF|uint32(uint8(v>>8))*uint32(uint8(v)))
+			c.cycles += 8
+		}
+	}
+
+	// LD MB, A (ADL mode only) / LD A, MB
+	ezEdOps[0x6D] = func(c *CPU, _ uint8) {
+		if c.ez.ADL {
+			c.ez.MB = c.getA()
+		}
+		c.cycles += 8
+	}
+	ezEdOps[0x6E] = func(c *CPU, _ uint8) {
+		c.setA(c.ez.MB)
+		c.cycles += 8
+	}
+
+	// STMIX / RSMIX: set or reset mixed-memory mode.
+	ezEdOps[0x7D] = func(c *CPU, _ uint8) {
+		c.ez.MADL = true
+		c.cycles += 8
+	}
+	ezEdOps[0x7E] = func(c *CPU, _ uint8) {
+		c.ez.MADL = false
+		c.cycles += 8
+	}
+
+	// SLP: enter sleep mode, modeled as HALT.
+	ezEdOps[0x76] = func(c *CPU, _ uint8) {
+		c.reg.Halted = true
+		c.cycles += 8
+	}
+}
+
+func initEZ80IX() {
+	// Suffix opcodes after DD/FD are plain register loads.
+	for _, op := range []uint8{0x40, 0x49, 0x52, 0x5B} {
+		ezIxOps[op] = func(c *CPU, op uint8) {
+			c.cycles += 4
+			baseOps[op](c, op)
+		}
+	}
+
+	// --- LD r, (IX+d) / LD (IX+d), r ---
+	// H and L are the true H and L registers.
+	for i := uint8(0); i < 8; i++ {
+		if i == 6 {
+			continue
+		}
+		ezIxOps[i<<3|0x46] = func(c *CPU, op uint8) {
+			c.setR8Idx((op>>3)&7, c.ezRead(c.ezIndexed()))
+			c.cycles += 19
+		}
+		ezIxOps[0x70|i] = func(c *CPU, op uint8) {
+			addr := c.ezIndexed()
+			var val uint8
+			switch s := op & 7; s {
+			case 4:
+				val = uint8(c.reg.HL >> 8)
+			case 5:
+				val = uint8(c.reg.HL)
+			default:
+				val = c.getR8(s)
+			}
+			c.ezWrite(addr, val)
+			c.cycles += 19
+		}
+	}
+
+	// --- LD (IX+d), n ---
+	ezIxOps[0x36] = func(c *CPU, _ uint8) {
+		addr := c.ezIndexed()
+		c.ezWrite(addr, c.ezFetch())
+		c.cycles += 19
+	}
+
+	// --- INC (IX+d) / DEC (IX+d) ---
+	ezIxOps[0x34] = func(c *CPU, _ uint8) {
+		addr := c.ezIndexed()
+		val := c.ezRead(addr)
+		f := incFlags8(val)
+		c.ezWrite(addr, val+1)
+		c.setF(f | (c.getF() & flagC))
+		c.cycles += 23
+	}
+	ezIxOps[0x35] = func(c *CPU, _ uint8) {
+		addr := c.ezIndexed()
+		val := c.ezRead(addr)
+		f := decFlags8(val)
+		c.ezWrite(addr, val-1)
+		c.setF(f | (c.getF() & flagC))
+		c.cycles += 23
+	}
+
+	// --- ALU A, (IX+d) ---
+	for i := uint8(0); i < 8; i++ {
+		ezIxOps[i<<3|0x86] = func(c *CPU, op uint8) {
+			aluOp8(c, (op>>3)&7, c.ezRead(c.ezIndexed()))
+			c.cycles += 19
+		}
+	}
+
+	// --- eZ80: LD rr, (IX+d) / LD (IX+d), rr ---
+	// 0x07=BC, 0x17=DE, 0x27=HL, 0x37=same index, 0x31=other index
+	// 0x0F=BC, 0x1F=DE, 0x2F=HL, 0x3F=same index, 0x3E=other index
+	for _, p := range []struct{ ld, st, pair uint8 }{
+		{0x07, 0x0F, ezBC}, {0x17, 0x1F, ezDE}, {0x27, 0x2F, ezHLPair},
+		{0x37, 0x3F, ezSame}, {0x31, 0x3E, ezOther},
+	} {
+		pair := p.pair
+		ezIxOps[p.ld] = func(c *CPU, _ uint8) {
+			addr := c.ezIndexed()
+			lo, up := c.ezPair(pair)
+			c.ezSet(lo, up, c.ezReadWord(addr))
+			c.cycles += 17
+		}
+		ezIxOps[p.st] = func(c *CPU, _ uint8) {
+			addr := c.ezIndexed()
+			lo, up := c.ezPair(pair)
+			c.ezWriteWord(addr, c.ezGet(lo, up))
+			c.cycles += 17
+		}
+	}
+
+	// --- DD CB d op / FD CB d op ---
+	for op := 0; op < 256; op++ {
+		switch op >> 6 {
+		case 0: // Rotate/shift (IX+d)
+			ezIxcbOps[op] = func(c *CPU, op uint8) {
+				addr := c.ez.idxAddr
+				result, f := cbRotShift((op>>3)&7, c.ezRead(addr), c.getF())
+				c.ezWrite(addr, result)
+				if op&7 != 6 {
+					c.setR8Idx(op&7, result)
+				}
+				c.setF(f)
+				c.cycles += 19
+			}
+		case 1: // BIT b, (IX+d)
+			ezIxcbOps[op] = func(c *CPU, op uint8) {
+				bit := (op >> 3) & 7
+				addr := c.ez.idxAddr
+				val := c.ezRead(addr)
+				f := c.getF()&flagC | flagH
+				if val&(1<<bit) == 0 {
+					f |= flagZ | flagPV
+				}
+				if bit == 7 && val&0x80 != 0 {
+					f |= flagS
+				}
+				f |= uint8(addr>>8) & (flagF3 | flagF5)
+				c.setF(f)
+				c.cycles += 16
+			}
+		case 2, 3: // RES b, (IX+d) / SET b, (IX+d)
+			ezIxcbOps[op] = func(c *CPU, op uint8) {
+				addr := c.ez.idxAddr
+				val := c.ezRead(addr)
+				if op&0x40 != 0 {
+					val |= 1 << ((op >> 3) & 7)
+				} else {
+					val &^= 1 << ((op >> 3) & 7)
+				}
+				c.ezWrite(addr, val)
+				if op&7 != 6 {
+					c.setR8Idx(op&7, val)
+				}
+				c.cycles += 19
+			}
+		}
+	}
+}
+
+// Register pair selectors for ezPair.
+const (
+	ezBC uint8 = iota
+	ezDE
+	ezHLPair
+	ezIX
+	ezIY
+	ezSame  // the index register selected by the DD/FD prefix
+	ezOther // the index register not selected by the DD/FD prefix
+)
+
+// ezPair returns the low 16 bits and upper byte of a register pair.
+func (c *CPU) ezPair(p uint8) (*uint16, *uint8) {
+	e := c.ez
+	switch p {
+	case ezBC:
+		return &c.reg.BC, &e.BCU
+	case ezDE:
+		return &c.reg.DE, &e.DEU
+	case ezIX:
+		return &c.reg.IX, &e.IXU
+	case ezIY:
+		return &c.reg.IY, &e.IYU
+	case ezSame:
+		return c.ixiyReg, e.idxU
+	case ezOther:
+		if c.ixiyReg == &c.reg.IX {
+			return &c.reg.IY, &e.IYU
+		}
+		return &c.reg.IX, &e.IXU
+	}
+	return &c.reg.HL, &e.HLU
+}
+
+// ezAdcSbcHL implements ADC HL,rr and SBC HL,rr at the current width.
+// S and P/V follow the top bit of the width; H is the carry out of bit 11.
+func (c *CPU) ezAdcSbcHL(val uint32, sub bool) {
+	hl := c.ezHL()
+	carry := uint32(c.getF() & flagC)
+	top := uint32(0x8000)
+	if c.ez.l {
+		top = 0x800000
+	}
+	var result uint32
+	var f uint8
+	if sub {
+		result = hl - val - carry
+		f = flagN
+		if (hl^val)&top != 0 && (hl^result)&top != 0 {
+			f |= flagPV
+		}
+	} else {
+		result = hl + val + carry
+		if (hl^val)&top == 0 && (hl^result)&top != 0 {
+			f |= flagPV
+		}
+	}
+	r := c.ezMask(result)
+	f |= uint8(r>>8) & (flagF5 | flagF3)
+	if r&top != 0 {
+		f |= flagS
+	}
+	if r == 0 {
+		f |= flagZ
+	}
+	if r != result {
+		f |= flagC
+	}
+	if (hl^val^r)&0x1000 != 0 {
+		f |= flagH
+	}
+	c.setF(f)
+	c.ezSetRR(2, r)
+}
+
+// ezDigit finishes RLD/RRD: stores the new A and sets flags.
+func (c *CPU) ezDigit(a uint8) {
+	c.setA(a)
+	c.setF(szFlags(a) | parityTable[a] | (c.getF() & flagC))
+	c.cycles += 18
+}
+
+// ezStep adds dir to the pair lo/up at the current width.
+func (c *CPU) ezStep(lo *uint16, up *uint8, dir int) {
+	c.ezSet(lo, up, uint32(int32(c.ezGet(lo, up))+int32(dir)))
+}
+
+// ezBlockLD performs the core of LDI/LDD/LDIR/LDDR and returns the new
+// BC at the current width.
+func (c *CPU) ezBlockLD(dir int) uint32 {
+	e := c.ez
+	val := c.ezRead(c.ezAddr(c.ezHL()))
+	c.ezWrite(c.ezAddr(c.ezRR(1)), val)
+	c.ezStep(&c.reg.HL, &e.HLU, dir)
+	c.ezStep(&c.reg.DE, &e.DEU, dir)
+	c.ezStep(&c.reg.BC, &e.BCU, -1)
+	bc := c.ezRR(0)
+	n := val + c.getA()
+	f := c.getF() & (flagS | flagZ | flagC)
+	if n&0x02 != 0 {
+		f |= flagF5
+	}
+	f |= n & flagF3
+	if bc != 0 {
+		f |= flagPV
+	}
+	c.setF(f)
+	return bc
+}
+
+// ezBlockCP performs the core of CPI/CPD/CPIR/CPDR and returns the new
+// BC at the current width.
+func (c *CPU) ezBlockCP(dir int) uint32 {
+	e := c.ez
+	val := c.ezRead(c.ezAddr(c.ezHL()))
+	a := c.getA()
+	result := a - val
+	c.ezStep(&c.reg.HL, &e.HLU, dir)
+	c.ezStep(&c.reg.BC, &e.BCU, -1)
+	bc := c.ezRR(0)
+	f := szFlags(result)&^(flagF3|flagF5) | flagN | (c.getF() & flagC)
+	if (a^val^result)&0x10 != 0 {
+		f |= flagH
+	}
+	n := result
+	if f&flagH != 0 {
+		n--
+	}
+	if n&0x02 != 0 {
+		f |= flagF5
+	}
+	f |= n & flagF3
+	if bc != 0 {
+		f |= flagPV
+	}
+	c.setF(f)
+	return bc
+}
+
+// ezBlockRepeat rewinds PC to the start of the instruction (including any
+// suffix) when repeat is true.
+func (c *CPU) ezBlockRepeat(repeat bool) {
+	if repeat {
+		c.ezSetPC(c.ez.start)
+		c.reg.WZ = c.reg.PC + 1
+		c.blockRepeatF35()
+		c.cycles += 21
+	} else {
+		c.cycles += 16
+	}
+}
+
+// ezBlockIORepeat repeats a block I/O instruction until B reaches zero.
+func (c *CPU) ezBlockIORepeat() {
+	if c.getB() != 0 {
+		c.ezSetPC(c.ez.start)
+		c.cycles += 21
+	} else {
+		c.cycles += 16
+	}
+}
+
+// ezBlockIN performs the core of INI/IND/INIR/INDR.
+func (c *CPU) ezBlockIN(dir int) {
+	val := c.inBus(c.reg.BC)
+	c.ezWrite(c.ezAddr(c.ezHL()), val)
+	b := c.getB() - 1
+	c.setB(b)
+	c.ezStep(&c.reg.HL, &c.ez.HLU, dir)
+	f := szFlags(b)
+	if val&0x80 != 0 {
+		f |= flagN
+	}
+	k := uint16(val) + uint16(uint8(c.getC()+uint8(dir)))
+	if k > 255 {
+		f |= flagH | flagC
+	}
+	f |= parityTable[uint8(k&7)^b]
+	c.setF(f)
+}
+
+// ezBlockOUT performs the core of OUTI/OUTD/OTIR/OTDR.
+func (c *CPU) ezBlockOUT(dir int) {
+	val := c.ezRead(c.ezAddr(c.ezHL()))
+	b := c.getB() - 1
+	c.setB(b)
+	c.outBus(c.reg.BC, val)
+	c.ezStep(&c.reg.HL, &c.ez.HLU, dir)
+	f := szFlags(b)
+	if val&0x80 != 0 {
+		f |= flagN
+	}
+	k := uint16(val) + uint16(c.getL())
+	if k > 255 {
+		f |= flagH | flagC
+	}
+	f |= parityTable[uint8(k&7)^b]
+	c.setF(f)
+}
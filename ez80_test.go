@@ -0,0 +1,392 @@
+package z80
+
+import "testing"
+
+// bus24 is a sparse 24-bit test bus.
+type bus24 struct {
+	mem map[uint32]uint8
+}
+
+func (b *bus24) Fetch(addr uint16) uint8        { return b.mem[uint32(addr)] }
+func (b *bus24) Read(addr uint16) uint8         { return b.mem[uint32(addr)] }
+func (b *bus24) Write(addr uint16, val uint8)   { b.mem[uint32(addr)] = val }
+func (b *bus24) In(port uint16) uint8           { return uint8(port) }
+func (b *bus24) Out(port uint16, val uint8)     {}
+func (b *bus24) Fetch24(addr uint32) uint8      { return b.mem[addr] }
+func (b *bus24) Read24(addr uint32) uint8       { return b.mem[addr] }
+func (b *bus24) Write24(addr uint32, val uint8) { b.mem[addr] = val }
+func (b *bus24) load(addr uint32, data ...uint8) {
+	for i, v := range data {
+		b.mem[addr+uint32(i)] = v
+	}
+}
+
+func newTestEZ80() (*CPU, *bus24) {
+	bus := &bus24{mem: map[uint32]uint8{}}
+	cpu := New(bus, WithVariant(VariantEZ80))
+	return cpu, bus
+}
+
+// newTestADL returns an eZ80 in ADL mode with PC at 0x010000.
+func newTestADL() (*CPU, *bus24) {
+	cpu, bus := newTestEZ80()
+	cpu.ez.ADL = true
+	cpu.ez.PCU = 0x01
+	return cpu, bus
+}
+
+func TestEZ80_Reset(t *testing.T) {
+	cpu, _ := newTestEZ80()
+	if cpu.Variant() != VariantEZ80 || cpu.Variant().String() != "eZ80" {
+		t.Errorf("Variant = %v", cpu.Variant())
+	}
+	x := cpu.EZ80Registers()
+	if x.ADL || x.MADL || x.MB != 0 {
+		t.Errorf("ADL=%v MADL=%v MB=%02x, want Z80 mode with MB=0", x.ADL, x.MADL, x.MB)
+	}
+	if x.SPL != 0xFFFFFF {
+		t.Errorf("SPL = %06x, want FFFFFF", x.SPL)
+	}
+}
+
+func TestEZ80_Z80ModeUsesMB(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	cpu.ez.MB = 0x12
+	bus.load(0x120000, 0x3A, 0x00, 0x40) // LD A,(4000h)
+	bus.mem[0x124000] = 0x5A
+	bus.mem[0x004000] = 0xFF
+	cycles := cpu.Step()
+	if cpu.getA() != 0x5A {
+		t.Errorf("A = %02x, want 5A", cpu.getA())
+	}
+	if cycles != 13 {
+		t.Errorf("cycles = %d, want 13", cycles)
+	}
+}
+
+func TestEZ80_Z80ModeIndexTiming(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	bus.load(0, 0xDD, 0x21, 0x00, 0x20, // LD IX,2000h
+		0xDD, 0x7E, 0x05, // LD A,(IX+5)
+		0xDD, 0xE5) // PUSH IX
+	bus.mem[0x2005] = 0x77
+	for _, want := range []int{14, 19, 15} {
+		if got := cpu.Step(); got != want {
+			t.Errorf("cycles = %d, want %d", got, want)
+		}
+	}
+	if cpu.getA() != 0x77 {
+		t.Errorf("A = %02x, want 77", cpu.getA())
+	}
+	if cpu.reg.SP != 0xFFFD || bus.mem[0xFFFD] != 0x00 || bus.mem[0xFFFE] != 0x20 {
+		t.Errorf("PUSH IX: SP=%04x", cpu.reg.SP)
+	}
+}
+
+func TestEZ80_PlainBus(t *testing.T) {
+	bus := &testBus{}
+	cpu := New(bus, WithVariant(VariantEZ80))
+	bus.mem[0] = 0x3E // LD A,42h
+	bus.mem[1] = 0x42
+	cpu.Step()
+	if cpu.getA() != 0x42 {
+		t.Errorf("A = %02x, want 42", cpu.getA())
+	}
+}
+
+func TestEZ80_ADL_LoadImmediate(t *testing.T) {
+	cpu, bus := newTestADL()
+	bus.load(0x010000, 0x21, 0x56, 0x34, 0x12, // LD HL,123456h
+		0x7E) // LD A,(HL)
+	bus.mem[0x123456] = 0x99
+	if cycles := cpu.Step(); cycles != 13 {
+		t.Errorf("LD HL,Mmn cycles = %d, want 13", cycles)
+	}
+	x := cpu.EZ80Registers()
+	if cpu.reg.HL != 0x3456 || x.HLU != 0x12 {
+		t.Errorf("HL = %02x%04x, want 123456", x.HLU, cpu.reg.HL)
+	}
+	cpu.Step()
+	if cpu.getA() != 0x99 {
+		t.Errorf("A = %02x, want 99", cpu.getA())
+	}
+	if pc := uint32(cpu.ez.PCU)<<16 | uint32(cpu.reg.PC); pc != 0x010005 {
+		t.Errorf("PC = %06x, want 010005", pc)
+	}
+}
+
+func TestEZ80_ADL_PushPop(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.ez.SPL = 0x0A0000
+	cpu.reg.BC = 0xBEEF
+	cpu.ez.BCU = 0xAD
+	bus.load(0x010000, 0xC5, 0xD1) // PUSH BC; POP DE
+	cpu.Step()
+	if cpu.ez.SPL != 0x09FFFD {
+		t.Errorf("SPL = %06x, want 09FFFD", cpu.ez.SPL)
+	}
+	if bus.mem[0x09FFFD] != 0xEF || bus.mem[0x09FFFE] != 0xBE || bus.mem[0x09FFFF] != 0xAD {
+		t.Error("PUSH BC did not write 3 bytes")
+	}
+	cpu.Step()
+	if cpu.reg.DE != 0xBEEF || cpu.ez.DEU != 0xAD || cpu.ez.SPL != 0x0A0000 {
+		t.Errorf("POP DE: DE=%02x%04x SPL=%06x", cpu.ez.DEU, cpu.reg.DE, cpu.ez.SPL)
+	}
+	if cpu.reg.SP != 0xFFFF {
+		t.Errorf("SPS = %04x, want unchanged", cpu.reg.SP)
+	}
+}
+
+func TestEZ80_ADL_BusTracking(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.SetBusTracking(true)
+	cpu.ez.SPL = 0x0A0000
+	cpu.reg.BC = 0xBEEF
+	cpu.ez.BCU = 0xAD
+	bus.load(0x010000, 0xC5) // PUSH BC
+	cpu.Step()
+	if got := cpu.Accesses(); got != 4 {
+		t.Errorf("Accesses = %d, want 4", got)
+	}
+	if p := cpu.Pins(); p.Addr != 0xFFFD || p.Data != 0xEF || !p.WR {
+		t.Errorf("pins %+v, want the write of EFh to 09FFFDh", p)
+	}
+	if bus.mem[0x09FFFD] != 0xEF || bus.mem[0x00FFFD] != 0 {
+		t.Error("tracked write went to the wrong 24-bit address")
+	}
+}
+
+func TestEZ80_ADL_CallRet(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.ez.SPL = 0x0A0000
+	bus.load(0x010000, 0xCD, 0x00, 0x00, 0x02) // CALL 020000h
+	bus.load(0x020000, 0xC9)                   // RET
+	if cycles := cpu.Step(); cycles != 17+3+3 {
+		t.Errorf("CALL cycles = %d, want 23", cycles)
+	}
+	if cpu.ez.PCU != 0x02 || cpu.reg.PC != 0 {
+		t.Errorf("PC = %02x%04x, want 020000", cpu.ez.PCU, cpu.reg.PC)
+	}
+	cpu.Step()
+	if cpu.ez.PCU != 0x01 || cpu.reg.PC != 0x0004 {
+		t.Errorf("PC = %02x%04x, want 010004", cpu.ez.PCU, cpu.reg.PC)
+	}
+}
+
+func TestEZ80_ADL_AddHLCarry(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.reg.HL, cpu.ez.HLU = 0xFFFF, 0xFF
+	cpu.reg.BC, cpu.ez.BCU = 0x0001, 0x00
+	bus.load(0x010000, 0x09) // ADD HL,BC
+	cpu.Step()
+	if cpu.reg.HL != 0 || cpu.ez.HLU != 0 {
+		t.Errorf("HL = %02x%04x, want 000000", cpu.ez.HLU, cpu.reg.HL)
+	}
+	if cpu.getF()&flagC == 0 {
+		t.Error("C should be set on carry out of bit 23")
+	}
+}
+
+func TestEZ80_ADL_LDIR(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.reg.HL, cpu.ez.HLU = 0x0000, 0x03
+	cpu.reg.DE, cpu.ez.DEU = 0x0000, 0x04
+	cpu.reg.BC, cpu.ez.BCU = 0x0000, 0x01 // 65536 bytes: BC reaches 0 only at 24 bits
+	bus.load(0x030000, 1, 2, 3)
+	bus.load(0x010000, 0xED, 0xB0) // LDIR
+	cpu.Step()
+	if cpu.ez.PCU != 0x01 || cpu.reg.PC != 0 {
+		t.Errorf("LDIR should repeat: PC = %02x%04x", cpu.ez.PCU, cpu.reg.PC)
+	}
+	if cpu.reg.BC != 0xFFFF || cpu.ez.BCU != 0x00 {
+		t.Errorf("BC = %02x%04x, want 00FFFF", cpu.ez.BCU, cpu.reg.BC)
+	}
+	if bus.mem[0x040000] != 1 {
+		t.Error("first byte not copied")
+	}
+}
+
+func TestEZ80_SuffixInZ80Mode(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	bus.load(0, 0x5B, 0x21, 0x56, 0x34, 0x12) // LD.LIL HL,123456h
+	cycles := cpu.Step()
+	if cpu.reg.HL != 0x3456 || cpu.ez.HLU != 0x12 {
+		t.Errorf("HL = %02x%04x, want 123456", cpu.ez.HLU, cpu.reg.HL)
+	}
+	if cpu.reg.PC != 5 || cpu.ez.ADL {
+		t.Errorf("PC = %04x ADL=%v, want 0005 in Z80 mode", cpu.reg.PC, cpu.ez.ADL)
+	}
+	if cycles != 4+10+3 {
+		t.Errorf("cycles = %d, want 17", cycles)
+	}
+}
+
+func TestEZ80_JPLILEntersADL(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	bus.load(0, 0x5B, 0xC3, 0x00, 0x00, 0x05) // JP.LIL 050000h
+	bus.load(0x050000, 0x3E, 0x11)            // LD A,11h
+	cpu.Step()
+	if !cpu.ez.ADL || cpu.ez.PCU != 0x05 || cpu.reg.PC != 0 {
+		t.Fatalf("ADL=%v PC=%02x%04x, want ADL at 050000", cpu.ez.ADL, cpu.ez.PCU, cpu.reg.PC)
+	}
+	cpu.Step()
+	if cpu.getA() != 0x11 {
+		t.Errorf("A = %02x, want 11", cpu.getA())
+	}
+}
+
+func TestEZ80_MixedModeCallReturn(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	cpu.reg.SP = 0x8000
+	cpu.ez.SPL = 0x0A0000
+	bus.load(0x1000, 0x52, 0xCD, 0x00, 0x00, 0x05) // CALL.IL 050000h
+	bus.load(0x050000, 0x5B, 0xC9)                 // RET.L
+	cpu.reg.PC = 0x1000
+	cpu.Step()
+	if !cpu.ez.ADL || cpu.ez.PCU != 0x05 {
+		t.Fatalf("ADL=%v PC=%02x%04x, want ADL at 050000", cpu.ez.ADL, cpu.ez.PCU, cpu.reg.PC)
+	}
+	// Return address on SPS, mode byte (from Z80 mode) on SPL.
+	if cpu.reg.SP != 0x7FFE || bus.mem[0x7FFE] != 0x05 || bus.mem[0x7FFF] != 0x10 {
+		t.Errorf("SPS = %04x, stack = %02x %02x", cpu.reg.SP, bus.mem[0x7FFE], bus.mem[0x7FFF])
+	}
+	if cpu.ez.SPL != 0x09FFFF || bus.mem[0x09FFFF] != 0x02 {
+		t.Errorf("SPL = %06x, mode byte = %02x", cpu.ez.SPL, bus.mem[0x09FFFF])
+	}
+	cpu.Step()
+	if cpu.ez.ADL || cpu.reg.PC != 0x1005 {
+		t.Errorf("ADL=%v PC=%04x, want Z80 mode at 1005", cpu.ez.ADL, cpu.reg.PC)
+	}
+	if cpu.reg.SP != 0x8000 || cpu.ez.SPL != 0x0A0000 {
+		t.Errorf("SPS=%04x SPL=%06x, want both restored", cpu.reg.SP, cpu.ez.SPL)
+	}
+}
+
+func TestEZ80_LEAPEA(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.ez.SPL = 0x0A0000
+	cpu.reg.IY, cpu.ez.IYU = 0x0010, 0x20
+	bus.load(0x010000, 0xED, 0x54, 0xF0, // LEA IX,IY-16
+		0xED, 0x66, 0x04) // PEA IY+4
+	cpu.Step()
+	if cpu.reg.IX != 0x0000 || cpu.ez.IXU != 0x20 {
+		t.Errorf("IX = %02x%04x, want 200000", cpu.ez.IXU, cpu.reg.IX)
+	}
+	cpu.Step()
+	if cpu.ez.SPL != 0x09FFFD || bus.mem[0x09FFFD] != 0x14 || bus.mem[0x09FFFF] != 0x20 {
+		t.Errorf("PEA: SPL=%06x", cpu.ez.SPL)
+	}
+}
+
+func TestEZ80_MLTAndTST(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	cpu.reg.BC = 0x1020
+	cpu.setA(0x0F)
+	bus.load(0, 0xED, 0x4C, // MLT BC
+		0xED, 0x64, 0xF0) // TST A,F0h
+	cpu.Step()
+	if cpu.reg.BC != 0x0200 {
+		t.Errorf("BC = %04x, want 0200", cpu.reg.BC)
+	}
+	cpu.Step()
+	if cpu.getA() != 0x0F {
+		t.Errorf("TST changed A to %02x", cpu.getA())
+	}
+	if cpu.getF() != flagZ|flagH|flagPV {
+		t.Errorf("F = %02x, want %02x", cpu.getF(), flagZ|flagH|flagPV)
+	}
+}
+
+func TestEZ80_LDMB(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.setA(0xD0)
+	bus.load(0x010000, 0xED, 0x6D, // LD MB,A
+		0xAF,       // XOR A
+		0xED, 0x6E) // LD A,MB
+	cpu.Step()
+	cpu.Step()
+	cpu.Step()
+	if cpu.ez.MB != 0xD0 || cpu.getA() != 0xD0 {
+		t.Errorf("MB=%02x A=%02x, want D0", cpu.ez.MB, cpu.getA())
+	}
+}
+
+func TestEZ80_IN0(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	cpu.setA(0x55)
+	bus.load(0, 0xED, 0x38, 0x9A) // IN0 A,(9Ah)
+	cpu.Step()
+	// bus24.In returns the low port byte; the high byte is 0.
+	if cpu.getA() != 0x9A {
+		t.Errorf("A = %02x, want 9A", cpu.getA())
+	}
+}
+
+func TestEZ80_ADLInterrupt(t *testing.T) {
+	cpu, bus := newTestADL()
+	cpu.ez.SPL = 0x0A0000
+	cpu.reg.PC = 0x1234
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.INT(true, 0xFF)
+	cpu.Step()
+	if cpu.ez.PCU != 0 || cpu.reg.PC != 0x0038 {
+		t.Errorf("PC = %02x%04x, want 000038", cpu.ez.PCU, cpu.reg.PC)
+	}
+	if cpu.ez.SPL != 0x09FFFD || bus.mem[0x09FFFD] != 0x34 || bus.mem[0x09FFFE] != 0x12 || bus.mem[0x09FFFF] != 0x01 {
+		t.Errorf("SPL = %06x, 24-bit return address not pushed", cpu.ez.SPL)
+	}
+}
+
+func TestEZ80_MixedModeInterrupt(t *testing.T) {
+	cpu, bus := newTestEZ80()
+	cpu.ez.MADL = true
+	cpu.ez.SPL = 0x0A0000
+	cpu.reg.SP = 0x8000
+	cpu.reg.PC = 0x1234
+	cpu.NMI()
+	cpu.Step()
+	if !cpu.ez.ADL || cpu.reg.PC != 0x0066 || cpu.ez.PCU != 0 {
+		t.Errorf("ADL=%v PC=%02x%04x, want ADL at 000066", cpu.ez.ADL, cpu.ez.PCU, cpu.reg.PC)
+	}
+	if cpu.reg.SP != 0x7FFE || bus.mem[0x09FFFF] != 0x02 {
+		t.Errorf("SPS=%04x mode byte=%02x", cpu.reg.SP, bus.mem[0x09FFFF])
+	}
+}
+
+func TestEZ80_SetState(t *testing.T) {
+	cpu, _ := newTestEZ80()
+	want := EZ80Registers{HLU: 1, IXU: 2, SPL: 0x123456, PCU: 3, MB: 4, ADL: true}
+	cpu.SetEZ80State(want)
+	if got := cpu.EZ80Registers(); got != want {
+		t.Errorf("EZ80Registers = %+v, want %+v", got, want)
+	}
+	z, _ := newTestCPU()
+	z.SetEZ80State(want)
+	if got := z.EZ80Registers(); got != (EZ80Registers{}) {
+		t.Errorf("Z80 EZ80Registers = %+v, want zero", got)
+	}
+}
+
+func TestEZ80_OpsNilCheck(t *testing.T) {
+	newTestEZ80()
+	for i := 0; i < 256; i++ {
+		if ezOps[i] == nil {
+			t.Errorf("ezOps[0x%02X] is nil", i)
+		}
+		if ezCbOps[i] == nil {
+			t.Errorf("ezCbOps[0x%02X] is nil", i)
+		}
+		if ezIxcbOps[i] == nil {
+			t.Errorf("ezIxcbOps[0x%02X] is nil", i)
+		}
+		if ez80Z80Ops[i] == nil {
+			t.Errorf("ez80Z80Ops[0x%02X] is nil", i)
+		}
+	}
+	for _, op := range ezEdAdded {
+		if ezEdOps[op] == nil {
+			t.Errorf("ezEdOps[0x%02X] is nil", op)
+		}
+	}
+}
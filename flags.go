@@ -1,15 +1,45 @@
 package z80
 
-// Flag bit positions in the F register.
+// Flags is the value of the F register.
+type Flags uint8
+
+// Flag bits in the F register.
+const (
+	FlagC  Flags = 1 << 0 // Carry
+	FlagN  Flags = 1 << 1 // Subtract
+	FlagPV Flags = 1 << 2 // Parity/Overflow
+	FlagF3 Flags = 1 << 3 // Undocumented (bit 3 of result)
+	FlagH  Flags = 1 << 4 // Half-carry
+	FlagF5 Flags = 1 << 5 // Undocumented (bit 5 of result)
+	FlagZ  Flags = 1 << 6 // Zero
+	FlagS  Flags = 1 << 7 // Sign
+)
+
+// String returns the flags in the conventional "SZ5H3PNC" notation, bit
+// 7 first, with "-" for each flag that is clear.
+func (f Flags) String() string {
+	const names = "SZ5H3PNC"
+	var b [8]byte
+	for i := range b {
+		if f&(0x80>>i) != 0 {
+			b[i] = names[i]
+		} else {
+			b[i] = '-'
+		}
+	}
+	return string(b[:])
+}
+
+// Flag bit positions in the F register, as plain bytes for the ALU.
 const (
-	flagC  uint8 = 1 << 0 // Carry
-	flagN  uint8 = 1 << 1 // Subtract
-	flagPV uint8 = 1 << 2 // Parity/Overflow
-	flagF3 uint8 = 1 << 3 // Undocumented (bit 3 of result)
-	flagH  uint8 = 1 << 4 // Half-carry
-	flagF5 uint8 = 1 << 5 // Undocumented (bit 5 of result)
-	flagZ  uint8 = 1 << 6 // Zero
-	flagS  uint8 = 1 << 7 // Sign
+	flagC  = uint8(FlagC)
+	flagN  = uint8(FlagN)
+	flagPV = uint8(FlagPV)
+	flagF3 = uint8(FlagF3)
+	flagH  = uint8(FlagH)
+	flagF5 = uint8(FlagF5)
+	flagZ  = uint8(FlagZ)
+	flagS  = uint8(FlagS)
 )
 
 // parityTable[i] is flagPV if i has even parity, 0 otherwise.
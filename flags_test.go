@@ -0,0 +1,20 @@
+package z80
+
+import "testing"
+
+func TestFlagsString(t *testing.T) {
+	for _, tc := range []struct {
+		f    Flags
+		want string
+	}{
+		{0, "--------"},
+		{0xFF, "SZ5H3PNC"},
+		{FlagS | FlagZ | FlagC, "SZ-----C"},
+		{FlagH | FlagPV | FlagN, "---H-PN-"},
+		{FlagF5 | FlagF3, "--5-3---"},
+	} {
+		if got := tc.f.String(); got != tc.want {
+			t.Errorf("Flags(0x%02X) = %q, want %q", uint8(tc.f), got, tc.want)
+		}
+	}
+}
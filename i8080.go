@@ -0,0 +1,314 @@
+package z80
+
+import "sync"
+
+// i8080Ops is the dispatch table for Variant8080. It starts as a copy of
+// baseOps and overrides the entries whose flags, timing, or decoding
+// differ on the 8080.
+var (
+	i8080Ops  [256]opFunc
+	i8080Once sync.Once
+)
+
+// flags8080 applies the 8080's fixed F bits: bit 1 always reads 1,
+// bits 3 and 5 always read 0.
+func flags8080(f uint8) uint8 {
+	return f&^(flagF3|flagF5) | flagN
+}
+
+// szpFlags8080 returns S, Z and P flags for an 8-bit result, with the
+// fixed bits applied.
+func szpFlags8080(val uint8) uint8 {
+	f := val&flagS | parityTable[val] | flagN
+	if val == 0 {
+		f |= flagZ
+	}
+	return f
+}
+
+func init8080Ops() {
+	i8080Ops = baseOps
+
+	// --- Undocumented aliases for Z80-only opcodes ---
+	// 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38 = NOP
+	for i := uint8(1); i < 8; i++ {
+		i8080Ops[i<<3] = baseOps[0x00]
+	}
+	i8080Ops[0xCB] = baseOps[0xC3] // JMP
+	i8080Ops[0xD9] = baseOps[0xC9] // RET
+	i8080Ops[0xDD] = baseOps[0xCD] // CALL
+	i8080Ops[0xED] = baseOps[0xCD] // CALL
+	i8080Ops[0xFD] = baseOps[0xCD] // CALL
+
+	// --- MOV r, r' (register-to-register takes 5 T-states) ---
+	for i := 0; i < 64; i++ {
+		op := uint8(0x40 + i)
+		if op == 0x76 || (op>>3)&7 == 6 || op&7 == 6 {
+			continue
+		}
+		i8080Ops[op] = func(c *CPU, op uint8) {
+			c.setR8((op>>3)&7, c.getR8(op&7))
+			c.cycles += 5
+		}
+	}
+
+	// --- HLT ---
+	i8080Ops[0x76] = func(c *CPU, _ uint8) {
+		c.reg.Halted = true
+		c.cycles += 7
+	}
+
+	// --- ALU A, r / A, M / A, n ---
+	for i := 0; i < 64; i++ {
+		op := uint8(0x80 + i)
+		if op&7 == 6 {
+			i8080Ops[op] = func(c *CPU, op uint8) {
+				alu8080(c, (op>>3)&7, c.readBus(c.reg.HL))
+				c.cycles += 7
+			}
+		} else {
+			i8080Ops[op] = func(c *CPU, op uint8) {
+				alu8080(c, (op>>3)&7, c.getR8(op&7))
+				c.cycles += 4
+			}
+		}
+	}
+	for i := uint8(0); i < 8; i++ {
+		i8080Ops[i<<3|0xC6] = func(c *CPU, op uint8) {
+			alu8080(c, (op>>3)&7, c.fetchPC())
+			c.cycles += 7
+		}
+	}
+
+	// --- INR / DCR ---
+	for i := uint8(0); i < 8; i++ {
+		cost := uint64(5)
+		if i == 6 {
+			cost = 10
+		}
+		i8080Ops[i<<3|0x04] = func(c *CPU, op uint8) {
+			r := (op >> 3) & 7
+			val := c.getR8(r) + 1
+			c.setR8(r, val)
+			f := szpFlags8080(val) | c.getF()&flagC
+			if val&0x0F == 0 {
+				f |= flagH
+			}
+			c.setF(f)
+			c.cycles += cost
+		}
+		i8080Ops[i<<3|0x05] = func(c *CPU, op uint8) {
+			r := (op >> 3) & 7
+			val := c.getR8(r) - 1
+			c.setR8(r, val)
+			f := szpFlags8080(val) | c.getF()&flagC
+			if val&0x0F != 0x0F {
+				f |= flagH
+			}
+			c.setF(f)
+			c.cycles += cost
+		}
+	}
+
+	// --- INX / DCX ---
+	for i := uint8(0); i < 4; i++ {
+		i8080Ops[i<<4|0x03] = func(c *CPU, op uint8) {
+			*c.getRR((op >> 4) & 3)++
+			c.cycles += 5
+		}
+		i8080Ops[i<<4|0x0B] = func(c *CPU, op uint8) {
+			*c.getRR((op >> 4) & 3)--
+			c.cycles += 5
+		}
+	}
+
+	// --- DAD rr (only CY is affected) ---
+	for i := uint8(0); i < 4; i++ {
+		i8080Ops[i<<4|0x09] = func(c *CPU, op uint8) {
+			result := uint32(c.reg.HL) + uint32(*c.getRR((op >> 4) & 3))
+			f := c.getF() &^ flagC
+			if result > 0xFFFF {
+				f |= flagC
+			}
+			c.setF(f)
+			c.reg.HL = uint16(result)
+			c.cycles += 10
+		}
+	}
+
+	// --- RLC / RRC / RAL / RAR (only CY is affected) ---
+	i8080Ops[0x07] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		c.setA(a<<1 | a>>7)
+		c.setF(c.getF()&^flagC | a>>7)
+		c.cycles += 4
+	}
+	i8080Ops[0x0F] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		c.setA(a>>1 | a<<7)
+		c.setF(c.getF()&^flagC | a&1)
+		c.cycles += 4
+	}
+	i8080Ops[0x17] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		f := c.getF()
+		c.setA(a<<1 | f&flagC)
+		c.setF(f&^flagC | a>>7)
+		c.cycles += 4
+	}
+	i8080Ops[0x1F] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		f := c.getF()
+		c.setA(a>>1 | (f&flagC)<<7)
+		c.setF(f&^flagC | a&1)
+		c.cycles += 4
+	}
+
+	// --- DAA ---
+	i8080Ops[0x27] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		f := c.getF()
+		lo, hi := a&0x0F, a>>4
+		correction := uint8(0)
+		carry := f & flagC
+		if f&flagH != 0 || lo > 9 {
+			correction |= 0x06
+		}
+		if carry != 0 || hi > 9 || (hi >= 9 && lo > 9) {
+			correction |= 0x60
+			carry = flagC
+		}
+		result := a + correction
+		nf := szpFlags8080(result) | carry
+		if lo+(correction&0x0F) > 0x0F {
+			nf |= flagH
+		}
+		c.setA(result)
+		c.setF(nf)
+		c.cycles += 4
+	}
+
+	// --- CMA / STC / CMC (no flags other than CY) ---
+	i8080Ops[0x2F] = func(c *CPU, _ uint8) {
+		c.setA(^c.getA())
+		c.cycles += 4
+	}
+	i8080Ops[0x37] = func(c *CPU, _ uint8) {
+		c.setF(c.getF() | flagC)
+		c.cycles += 4
+	}
+	i8080Ops[0x3F] = func(c *CPU, _ uint8) {
+		c.setF(c.getF() ^ flagC)
+		c.cycles += 4
+	}
+
+	// --- Ccc nn (11 T-states when not taken) ---
+	for i := uint8(0); i < 8; i++ {
+		i8080Ops[i<<3|0xC4] = func(c *CPU, op uint8) {
+			addr := c.fetchPC16()
+			if c.testCC((op >> 3) & 7) {
+				c.push16(c.reg.PC)
+				c.reg.PC = addr
+				c.cycles += 17
+			} else {
+				c.cycles += 11
+			}
+		}
+	}
+
+	// --- POP PSW (fixed F bits are re-applied) ---
+	i8080Ops[0xF1] = func(c *CPU, _ uint8) {
+		v := c.pop16()
+		c.reg.AF = v&0xFF00 | uint16(flags8080(uint8(v)))
+		c.cycles += 10
+	}
+
+	// --- IN n / OUT n (port number is placed on both address bus halves) ---
+	i8080Ops[0xDB] = func(c *CPU, _ uint8) {
+		n := uint16(c.fetchPC())
+		c.setA(c.inBus(n<<8 | n))
+		c.cycles += 10
+	}
+	i8080Ops[0xD3] = func(c *CPU, _ uint8) {
+		n := uint16(c.fetchPC())
+		c.outBus(n<<8|n, c.getA())
+		c.cycles += 10
+	}
+
+	// --- XTHL / PCHL / SPHL ---
+	i8080Ops[0xE3] = func(c *CPU, _ uint8) {
+		val := c.read16(c.reg.SP)
+		// H is written before L, as on hardware.
+		c.writeBus(c.reg.SP+1, uint8(c.reg.HL>>8))
+		c.writeBus(c.reg.SP, uint8(c.reg.HL))
+		c.reg.HL = val
+		c.cycles += 18
+	}
+	i8080Ops[0xE9] = func(c *CPU, _ uint8) {
+		c.reg.PC = c.reg.HL
+		c.cycles += 5
+	}
+	i8080Ops[0xF9] = func(c *CPU, _ uint8) {
+		c.reg.SP = c.reg.HL
+		c.cycles += 5
+	}
+}
+
+// alu8080 performs an 8080 ALU operation on A with operand b.
+// op: 0=ADD, 1=ADC, 2=SUB, 3=SBB, 4=ANA, 5=XRA, 6=ORA, 7=CMP
+//
+// Subtraction is performed as A + ~b + 1, so AC is the carry out of bit 3
+// of that sum rather than a half-borrow. ANA sets AC from bit 3 of the
+// OR of its operands; XRA and ORA clear it.
+func alu8080(c *CPU, op, b uint8) {
+	a := c.getA()
+	carry := c.getF() & flagC
+
+	var result uint8
+	var f uint8
+	switch op {
+	case 0, 1: // ADD, ADC
+		if op == 0 {
+			carry = 0
+		}
+		sum := uint16(a) + uint16(b) + uint16(carry)
+		result = uint8(sum)
+		f = szpFlags8080(result)
+		if sum > 0xFF {
+			f |= flagC
+		}
+		if (a^b^result)&0x10 != 0 {
+			f |= flagH
+		}
+	case 2, 3, 7: // SUB, SBB, CMP
+		if op != 3 {
+			carry = 0
+		}
+		diff := uint16(a) - uint16(b) - uint16(carry)
+		result = uint8(diff)
+		f = szpFlags8080(result)
+		if diff > 0xFF {
+			f |= flagC
+		}
+		if (a^b^result)&0x10 == 0 {
+			f |= flagH
+		}
+	case 4: // ANA
+		result = a & b
+		f = szpFlags8080(result)
+		if (a|b)&0x08 != 0 {
+			f |= flagH
+		}
+	case 5: // XRA
+		result = a ^ b
+		f = szpFlags8080(result)
+	case 6: // ORA
+		result = a | b
+		f = szpFlags8080(result)
+	}
+
+	if op != 7 {
+		c.setA(result)
+	}
+	c.setF(f)
+}
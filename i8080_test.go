@@ -0,0 +1,238 @@
+package z80
+
+import "testing"
+
+func newTest8080() (*CPU, *testBus) {
+	bus := &testBus{}
+	cpu := New(bus, WithVariant(Variant8080))
+	return cpu, bus
+}
+
+func TestI8080_Variant(t *testing.T) {
+	cpu, _ := newTest8080()
+	if cpu.Variant() != Variant8080 {
+		t.Errorf("Variant = %v, want 8080", cpu.Variant())
+	}
+	if cpu.getF() != 0xD7 {
+		t.Errorf("F after reset = %02x, want D7", cpu.getF())
+	}
+}
+
+func TestI8080_OpsNilCheck(t *testing.T) {
+	newTest8080()
+	for i := 0; i < 256; i++ {
+		if i8080Ops[i] == nil {
+			t.Errorf("i8080Ops[0x%02X] is nil", i)
+		}
+	}
+}
+
+func TestI8080_PrefixAliases(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []uint8
+		wantPC uint16
+		cycles int
+	}{
+		{"CB=JMP", []uint8{0xCB, 0x34, 0x12}, 0x1234, 10},
+		{"DD=CALL", []uint8{0xDD, 0x34, 0x12}, 0x1234, 17},
+		{"ED=CALL", []uint8{0xED, 0x34, 0x12}, 0x1234, 17},
+		{"FD=CALL", []uint8{0xFD, 0x34, 0x12}, 0x1234, 17},
+		{"10=NOP", []uint8{0x10, 0xFF}, 0x0001, 4},
+		{"18=NOP", []uint8{0x18, 0xFF}, 0x0001, 4},
+		{"08=NOP", []uint8{0x08}, 0x0001, 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTest8080()
+			cpu.reg.SP = 0xFFFE
+			copy(bus.mem[:], tc.code)
+			cycles := cpu.Step()
+			if cpu.reg.PC != tc.wantPC {
+				t.Errorf("PC = %04x, want %04x", cpu.reg.PC, tc.wantPC)
+			}
+			if cycles != tc.cycles {
+				t.Errorf("cycles = %d, want %d", cycles, tc.cycles)
+			}
+		})
+	}
+}
+
+func TestI8080_RET_Alias(t *testing.T) {
+	cpu, bus := newTest8080()
+	cpu.reg.SP = 0xFFFC
+	bus.mem[0xFFFC] = 0x00
+	bus.mem[0xFFFD] = 0x40
+	bus.mem[0] = 0xD9
+	cycles := cpu.Step()
+	if cpu.reg.PC != 0x4000 {
+		t.Errorf("PC = %04x, want 4000", cpu.reg.PC)
+	}
+	if cycles != 10 {
+		t.Errorf("cycles = %d, want 10", cycles)
+	}
+}
+
+func TestI8080_ADD_Parity(t *testing.T) {
+	cpu, bus := newTest8080()
+	// MVI A,7F; ADI 01
+	copy(bus.mem[:], []uint8{0x3E, 0x7F, 0xC6, 0x01})
+	cpu.Step()
+	cpu.Step()
+	if cpu.getA() != 0x80 {
+		t.Errorf("A = %02x, want 80", cpu.getA())
+	}
+	// S and AC set; 0x80 has odd parity so P is clear (no overflow flag).
+	if cpu.getF() != 0x92 {
+		t.Errorf("F = %02x, want 92", cpu.getF())
+	}
+}
+
+func TestI8080_SUB_AuxCarry(t *testing.T) {
+	cpu, bus := newTest8080()
+	// MVI A,3E; SUB A
+	copy(bus.mem[:], []uint8{0x3E, 0x3E, 0x97})
+	cpu.Step()
+	cpu.Step()
+	if cpu.getA() != 0 {
+		t.Errorf("A = %02x, want 00", cpu.getA())
+	}
+	if cpu.getF() != 0x56 {
+		t.Errorf("F = %02x, want 56", cpu.getF())
+	}
+}
+
+func TestI8080_ANA_AuxCarry(t *testing.T) {
+	cpu, bus := newTest8080()
+	// MVI A,0F; ANI 08 -> AC from (A|n) bit 3
+	copy(bus.mem[:], []uint8{0x3E, 0x0F, 0xE6, 0x08})
+	cpu.Step()
+	cpu.Step()
+	if cpu.getF()&flagH == 0 {
+		t.Error("AC should be set from bit 3 of A|n")
+	}
+	// MVI A,F0; ANI 07 -> AC clear
+	copy(bus.mem[4:], []uint8{0x3E, 0xF0, 0xE6, 0x07})
+	cpu.Step()
+	cpu.Step()
+	if cpu.getF()&flagH != 0 {
+		t.Error("AC should be clear")
+	}
+	if cpu.getF()&flagC != 0 {
+		t.Error("ANA should clear CY")
+	}
+}
+
+func TestI8080_INR_DCR(t *testing.T) {
+	cpu, bus := newTest8080()
+	// MVI B,0F; INR B; DCR B
+	copy(bus.mem[:], []uint8{0x06, 0x0F, 0x04, 0x05})
+	cpu.Step()
+	cycles := cpu.Step()
+	if cpu.getB() != 0x10 || cpu.getF()&flagH == 0 {
+		t.Errorf("INR B: B=%02x F=%02x, want B=10 with AC", cpu.getB(), cpu.getF())
+	}
+	if cycles != 5 {
+		t.Errorf("INR cycles = %d, want 5", cycles)
+	}
+	cpu.Step()
+	// 0x10 - 1 = 0x0F: low nibble borrowed, so AC is clear.
+	if cpu.getB() != 0x0F || cpu.getF()&flagH != 0 {
+		t.Errorf("DCR B: B=%02x F=%02x, want B=0F without AC", cpu.getB(), cpu.getF())
+	}
+}
+
+func TestI8080_Timings(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []uint8
+		cycles int
+	}{
+		{"MOV B,C", []uint8{0x41}, 5},
+		{"MOV B,M", []uint8{0x46}, 7},
+		{"HLT", []uint8{0x76}, 7},
+		{"INX B", []uint8{0x03}, 5},
+		{"DAD B", []uint8{0x09}, 10},
+		{"INR M", []uint8{0x34}, 10},
+		{"CNZ not taken", []uint8{0xC4, 0, 0}, 11},
+		{"IN", []uint8{0xDB, 0x10}, 10},
+		{"OUT", []uint8{0xD3, 0x10}, 10},
+		{"XTHL", []uint8{0xE3}, 18},
+		{"PCHL", []uint8{0xE9}, 5},
+		{"SPHL", []uint8{0xF9}, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTest8080()
+			cpu.reg.AF = 0xFF00 | uint16(flagZ|flagN)
+			copy(bus.mem[:], tc.code)
+			if cycles := cpu.Step(); cycles != tc.cycles {
+				t.Errorf("cycles = %d, want %d", cycles, tc.cycles)
+			}
+		})
+	}
+}
+
+func TestI8080_RotateOnlyCarry(t *testing.T) {
+	cpu, bus := newTest8080()
+	bus.mem[0] = 0x07 // RLC
+	cpu.reg.AF = 0x8000 | uint16(flagZ|flagN)
+	cpu.Step()
+	if cpu.getA() != 0x01 {
+		t.Errorf("A = %02x, want 01", cpu.getA())
+	}
+	if cpu.getF() != flagZ|flagN|flagC {
+		t.Errorf("F = %02x, want %02x", cpu.getF(), flagZ|flagN|flagC)
+	}
+}
+
+func TestI8080_DAA(t *testing.T) {
+	cpu, bus := newTest8080()
+	// MVI A,9B; DAA -> A=01, CY=1, AC=1
+	copy(bus.mem[:], []uint8{0x3E, 0x9B, 0x27})
+	cpu.reg.AF = uint16(flagN)
+	cpu.Step()
+	cpu.Step()
+	if cpu.getA() != 0x01 {
+		t.Errorf("A = %02x, want 01", cpu.getA())
+	}
+	if cpu.getF()&(flagC|flagH) != flagC|flagH {
+		t.Errorf("F = %02x, want CY and AC set", cpu.getF())
+	}
+}
+
+func TestI8080_POP_PSW(t *testing.T) {
+	cpu, bus := newTest8080()
+	cpu.reg.SP = 0xFFFC
+	bus.mem[0xFFFC] = 0xFF
+	bus.mem[0xFFFD] = 0x12
+	bus.mem[0] = 0xF1
+	cpu.Step()
+	if cpu.reg.AF != 0x12D7 {
+		t.Errorf("AF = %04x, want 12D7", cpu.reg.AF)
+	}
+}
+
+func TestI8080_IOPortMirrored(t *testing.T) {
+	bus := &ioBus{}
+	cpu := New(bus, WithVariant(Variant8080))
+	copy(bus.mem[:], []uint8{0xD3, 0x42, 0xDB, 0x42})
+	cpu.Step()
+	if bus.lastOutPort != 0x4242 {
+		t.Errorf("OUT port = %04x, want 4242", bus.lastOutPort)
+	}
+	cpu.Step()
+	if bus.lastInPort != 0x4242 {
+		t.Errorf("IN port = %04x, want 4242", bus.lastInPort)
+	}
+}
+
+func TestI8080_NoRefresh(t *testing.T) {
+	cpu, _ := newTest8080()
+	for i := 0; i < 10; i++ {
+		cpu.Step()
+	}
+	if cpu.reg.R != 0 {
+		t.Errorf("R = %02x, want 00", cpu.reg.R)
+	}
+}
@@ -12,18 +12,108 @@ package z80
 //   - IM 0: executed as an instruction (typically RST n, e.g. 0xFF for RST 38h)
 //   - IM 1: ignored (always jumps to 0x0038)
 //   - IM 2: combined with I register to form a vector table address (I<<8 | data)
+//
+// On the LR35902, data is the pending-interrupt mask (IE & IF). The line
+// is only asserted while a bit in the low five is set. The CPU services
+// the lowest set bit, jumps to 0x40 + 8*bit, and clears that bit in IF
+// (0xFF0F) through the bus; the system should then call INT again with
+// the updated mask.
+//
+// The change is seen at the next instruction boundary. While Tick is
+// driving the CPU it is instead made at the current T-state, as with
+// INTAt.
 func (c *CPU) INT(assert bool, data uint8) {
-	c.intLine = assert
-	c.intData = data
+	c.INTAt(assert, data, c.busTime())
+}
+
+// INTAt is INT for a change made at the given absolute cycle count, on
+// the Cycles clock. The Z80 samples INT on the rising edge of the last
+// T-state of each instruction: an instruction ending at cycle n sees
+// the line as it was at cycle n-1. An interrupt asserted later than that
+// is not taken until the end of the following instruction, and a line
+// released later than that is still seen. A cycle of 0 means the change
+// applies at once.
+//
+// This lets a device that runs behind or ahead of the CPU, such as one
+// driven by a scheduler, place its interrupt exactly.
+func (c *CPU) INTAt(assert bool, data uint8, cycle uint64) {
+	if c.variant == VariantLR35902 && data&0x1F == 0 {
+		assert = false
+	}
+	if assert {
+		// A release still pending at cycle never happened: keep the
+		// earlier assertion. Only a line that really went low rises again.
+		if !c.intLine || (c.intEnd != noEvent && c.intEnd <= cycle) {
+			c.intAt = cycle
+		}
+		c.intLine = true
+		c.intEnd = noEvent
+		c.intData = data
+		return
+	}
+	if cycle == 0 || cycle < c.cycles {
+		c.intLine = false
+		c.intData = data
+		return
+	}
+	// Released after the next sampling point: keep the line, and the
+	// data the acknowledge would read, until then.
+	c.intEnd = cycle
 }
 
 // NMI triggers a non-maskable interrupt (edge-triggered).
 // The NMI is latched and processed at the start of the next Step() call.
 // Multiple calls before the next Step() have no additional effect.
+// While Tick is driving the CPU the edge is placed at the current
+// T-state, as with NMIAt.
 func (c *CPU) NMI() {
+	c.NMIAt(c.busTime())
+}
+
+// NMIAt triggers a non-maskable interrupt whose edge happens at the
+// given absolute cycle count. Like INT, it is sampled at the start of
+// the last T-state of an instruction, so an edge after that point is
+// taken one instruction later.
+func (c *CPU) NMIAt(cycle uint64) {
+	if !c.nmiPending || cycle < c.nmiAt {
+		c.nmiAt = cycle
+	}
 	c.nmiPending = true
 }
 
+// intSampled reports whether INT was asserted at the sampling point of
+// the instruction that has just finished: the start of its last T-state.
+// On the LR35902 a line with an empty pending mask, which a loaded state
+// can hold, is never taken, as there is no interrupt to service.
+func (c *CPU) intSampled() bool {
+	if !c.intLine {
+		return false
+	}
+	if c.variant == VariantLR35902 && c.intData&0x1F == 0 {
+		return false
+	}
+	if c.intEnd != noEvent && c.cycles > c.intEnd {
+		c.intLine = false
+		return false
+	}
+	return c.intAt == 0 || c.intAt < c.cycles
+}
+
+// nmiSampled reports whether a latched NMI edge came before the sampling
+// point of the instruction that has just finished.
+func (c *CPU) nmiSampled() bool {
+	return c.nmiAt == 0 || c.nmiAt < c.cycles
+}
+
+// busTime is the time of a line change made now: the current T-state
+// while Tick is driving the CPU, otherwise 0 (at once).
+func (c *CPU) busTime() uint64 {
+	if t := c.tick; t != nil && (t.active || t.base+uint64(t.elapsed) == c.cycles) {
+		return t.base + uint64(t.elapsed)
+	}
+	return 0
+}
+
 // serviceNMI processes a non-maskable interrupt.
 //
 // The NMI response:
@@ -34,11 +124,18 @@ func (c *CPU) NMI() {
 //  5. Jumps to 0x0066.
 //  6. Costs 11 T-states.
 func (c *CPU) serviceNMI() {
+	c.afterLDAIR = false
 	c.reg.Halted = false
 	c.reg.IFF2 = c.reg.IFF1
 	c.reg.IFF1 = false
+	c.busCycle(MCycleM1, c.reg.PC, 0, 5)
+	if c.ez != nil && (c.ez.ADL || c.ez.MADL) {
+		c.ez80ServiceNMI()
+		return
+	}
 	c.push16(c.reg.PC)
 	c.reg.PC = 0x0066
+	c.reg.WZ = c.reg.PC
 	c.cycles += 11
 }
 
@@ -48,16 +145,40 @@ func (c *CPU) serviceNMI() {
 //  1. Exit HALT state if active.
 //  2. Disable interrupts (IFF1=false, IFF2=false).
 //
+// If the interrupt is accepted immediately after LD A,I or LD A,R, the
+// P/V flag those instructions copied from IFF2 is reset, as on NMOS parts.
+//
 // Mode-specific behavior:
 //   - IM 0: Execute the instruction on the data bus. Typically RST n (11 T-states).
 //   - IM 1: Push PC, jump to 0x0038 (13 T-states).
 //   - IM 2: Push PC, read vector from (I<<8 | data), jump to that address (19 T-states).
 func (c *CPU) serviceINT() {
+	if c.variant == VariantLR35902 {
+		c.gbServiceINT()
+		return
+	}
 	c.reg.Halted = false
 	c.reg.IFF1 = false
 	c.reg.IFF2 = false
 	c.afterEI = false
 
+	if c.afterLDAIR {
+		c.setF(c.getF() &^ flagPV)
+		c.afterLDAIR = false
+	}
+
+	// IM 0 acknowledges in 5 T-states, IM 1 and IM 2 in 7.
+	if c.reg.IM == 1 || c.reg.IM == 2 {
+		c.busCycle(MCycleINTA, c.reg.PC, c.intData, 7)
+	} else {
+		c.busCycle(MCycleINTA, c.reg.PC, c.intData, 5)
+	}
+
+	if c.ez != nil && (c.ez.ADL || c.ez.MADL) {
+		c.ez80ServiceINT()
+		return
+	}
+
 	switch c.reg.IM {
 	case 0:
 		c.serviceIM0()
@@ -81,6 +202,7 @@ func (c *CPU) serviceIM0() {
 		addr := uint16(c.intData & 0x38)
 		c.push16(c.reg.PC)
 		c.reg.PC = addr
+		c.reg.WZ = addr
 		c.cycles += 11
 		return
 	}
@@ -93,6 +215,7 @@ func (c *CPU) serviceIM0() {
 func (c *CPU) serviceIM1() {
 	c.push16(c.reg.PC)
 	c.reg.PC = 0x0038
+	c.reg.WZ = c.reg.PC
 	c.cycles += 13
 }
 
@@ -103,5 +226,6 @@ func (c *CPU) serviceIM2() {
 	c.push16(c.reg.PC)
 	tableAddr := uint16(c.reg.I)<<8 | uint16(c.intData)
 	c.reg.PC = c.read16(tableAddr)
+	c.reg.WZ = c.reg.PC
 	c.cycles += 19
 }
@@ -0,0 +1,612 @@
+package z80
+
+import "sync"
+
+// LR35902 dispatch tables. The Game Boy CPU keeps its flags in the high
+// nibble of F in a different order from the Z80, so every flag-setting
+// instruction has its own handler; flag-free register loads are shared
+// with baseOps where the timing matches.
+var (
+	gbOps   [256]opFunc
+	gbCbOps [256]opFunc
+	gbOnce  sync.Once
+)
+
+// LR35902 flag bit positions in the F register. Bits 0-3 always read 0.
+const (
+	gbFlagC uint8 = 1 << 4 // Carry
+	gbFlagH uint8 = 1 << 5 // Half-carry
+	gbFlagN uint8 = 1 << 6 // Subtract
+	gbFlagZ uint8 = 1 << 7 // Zero
+)
+
+// LR35902 interrupt vectors start at 0x40 and are 8 bytes apart, one per
+// bit of IF/IE (V-blank, LCD STAT, timer, serial, joypad).
+const (
+	gbVectorBase = 0x40
+	gbIFAddr     = 0xFF0F
+)
+
+// gbZ returns gbFlagZ if val is zero.
+func gbZ(val uint8) uint8 {
+	if val == 0 {
+		return gbFlagZ
+	}
+	return 0
+}
+
+// gbCC evaluates a 2-bit condition code: 0=NZ, 1=Z, 2=NC, 3=C.
+func (c *CPU) gbCC(cc uint8) bool {
+	f := c.getF()
+	switch cc & 3 {
+	case 0:
+		return f&gbFlagZ == 0
+	case 1:
+		return f&gbFlagZ != 0
+	case 2:
+		return f&gbFlagC == 0
+	}
+	return f&gbFlagC != 0
+}
+
+// gbServiceINT services the lowest-numbered pending interrupt in the
+// mask supplied to INT and clears its bit in IF through the bus.
+func (c *CPU) gbServiceINT() {
+	c.reg.Halted = false
+	c.reg.IFF1 = false
+	c.reg.IFF2 = false
+	pending := c.intData & 0x1F
+	n := uint8(0)
+	for pending&(1<<n) == 0 {
+		n++
+	}
+	c.writeBus(gbIFAddr, c.readBus(gbIFAddr)&^(1<<n))
+	c.push16(c.reg.PC)
+	c.reg.PC = gbVectorBase + uint16(n)*8
+	c.cycles += 20
+}
+
+func initGBOps() {
+	// --- Shared with baseOps (same behavior and T-states) ---
+	// NOP, HALT, DI, EI, LD r,r'
+	gbOps[0x00] = baseOps[0x00]
+	gbOps[0x76] = baseOps[0x76]
+	gbOps[0xF3] = baseOps[0xF3]
+	gbOps[0xFB] = baseOps[0xFB]
+	for op := 0x40; op < 0x80; op++ {
+		if op&7 != 6 && op&0x38 != 0x30 {
+			gbOps[op] = baseOps[op]
+		}
+	}
+
+	// --- Removed opcodes hang the CPU ---
+	// The opcode is re-executed forever, as the hardware locks up.
+	for _, op := range []uint8{0xD3, 0xDB, 0xDD, 0xE3, 0xE4, 0xEB, 0xEC, 0xED, 0xF4, 0xFC, 0xFD} {
+		gbOps[op] = func(c *CPU, _ uint8) {
+			c.reg.PC--
+			c.cycles += 4
+		}
+	}
+
+	// --- LD r, (HL) / LD (HL), r ---
+	for op := 0x46; op < 0x80; op++ {
+		if op == 0x76 || (op&7 != 6 && op&0x38 != 0x30) {
+			continue
+		}
+		gbOps[op] = func(c *CPU, op uint8) {
+			c.setR8((op>>3)&7, c.getR8(op&7))
+			c.cycles += 8
+		}
+	}
+
+	// --- LD r, n ---
+	for i := uint8(0); i < 8; i++ {
+		cost := uint64(8)
+		if i == 6 {
+			cost = 12
+		}
+		gbOps[i<<3|0x06] = func(c *CPU, op uint8) {
+			c.setR8((op>>3)&7, c.fetchPC())
+			c.cycles += cost
+		}
+	}
+
+	// --- LD rr, nn ---
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<4|0x01] = func(c *CPU, op uint8) {
+			*c.getRR((op >> 4) & 3) = c.fetchPC16()
+			c.cycles += 12
+		}
+	}
+
+	// --- LD (BC/DE), A / LD A, (BC/DE) ---
+	gbOps[0x02] = func(c *CPU, _ uint8) { c.writeBus(c.reg.BC, c.getA()); c.cycles += 8 }
+	gbOps[0x12] = func(c *CPU, _ uint8) { c.writeBus(c.reg.DE, c.getA()); c.cycles += 8 }
+	gbOps[0x0A] = func(c *CPU, _ uint8) { c.setA(c.readBus(c.reg.BC)); c.cycles += 8 }
+	gbOps[0x1A] = func(c *CPU, _ uint8) { c.setA(c.readBus(c.reg.DE)); c.cycles += 8 }
+
+	// --- LD (HL+), A / LD (HL-), A / LD A, (HL+) / LD A, (HL-) ---
+	gbOps[0x22] = func(c *CPU, _ uint8) {
+		c.writeBus(c.reg.HL, c.getA())
+		c.reg.HL++
+		c.cycles += 8
+	}
+	gbOps[0x32] = func(c *CPU, _ uint8) {
+		c.writeBus(c.reg.HL, c.getA())
+		c.reg.HL--
+		c.cycles += 8
+	}
+	gbOps[0x2A] = func(c *CPU, _ uint8) {
+		c.setA(c.readBus(c.reg.HL))
+		c.reg.HL++
+		c.cycles += 8
+	}
+	gbOps[0x3A] = func(c *CPU, _ uint8) {
+		c.setA(c.readBus(c.reg.HL))
+		c.reg.HL--
+		c.cycles += 8
+	}
+
+	// --- LD (nn), A / LD A, (nn) ---
+	gbOps[0xEA] = func(c *CPU, _ uint8) {
+		c.writeBus(c.fetchPC16(), c.getA())
+		c.cycles += 16
+	}
+	gbOps[0xFA] = func(c *CPU, _ uint8) {
+		c.setA(c.readBus(c.fetchPC16()))
+		c.cycles += 16
+	}
+
+	// --- LDH (n), A / LDH A, (n) / LD (C), A / LD A, (C) ---
+	// High-page accesses to 0xFF00+n, which hold the I/O registers.
+	gbOps[0xE0] = func(c *CPU, _ uint8) {
+		c.writeBus(0xFF00|uint16(c.fetchPC()), c.getA())
+		c.cycles += 12
+	}
+	gbOps[0xF0] = func(c *CPU, _ uint8) {
+		c.setA(c.readBus(0xFF00 | uint16(c.fetchPC())))
+		c.cycles += 12
+	}
+	gbOps[0xE2] = func(c *CPU, _ uint8) {
+		c.writeBus(0xFF00|uint16(c.getC()), c.getA())
+		c.cycles += 8
+	}
+	gbOps[0xF2] = func(c *CPU, _ uint8) {
+		c.setA(c.readBus(0xFF00 | uint16(c.getC())))
+		c.cycles += 8
+	}
+
+	// --- LD (nn), SP ---
+	gbOps[0x08] = func(c *CPU, _ uint8) {
+		c.write16(c.fetchPC16(), c.reg.SP)
+		c.cycles += 20
+	}
+
+	// --- LD SP, HL ---
+	gbOps[0xF9] = func(c *CPU, _ uint8) {
+		c.reg.SP = c.reg.HL
+		c.cycles += 8
+	}
+
+	// --- ADD SP, e / LD HL, SP+e ---
+	// H and C come from the unsigned addition of the low byte; Z and N
+	// are reset.
+	gbOps[0xE8] = func(c *CPU, _ uint8) {
+		c.reg.SP = c.gbAddSP(int8(c.fetchPC()))
+		c.cycles += 16
+	}
+	gbOps[0xF8] = func(c *CPU, _ uint8) {
+		c.reg.HL = c.gbAddSP(int8(c.fetchPC()))
+		c.cycles += 12
+	}
+
+	// --- PUSH rr / POP rr ---
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<4|0xC5] = func(c *CPU, op uint8) {
+			c.push16(*c.getRRPush((op >> 4) & 3))
+			c.cycles += 16
+		}
+		gbOps[i<<4|0xC1] = func(c *CPU, op uint8) {
+			*c.getRRPush((op >> 4) & 3) = c.pop16()
+			c.cycles += 12
+		}
+	}
+	// POP AF: the low nibble of F is always 0.
+	gbOps[0xF1] = func(c *CPU, _ uint8) {
+		c.reg.AF = c.pop16() & 0xFFF0
+		c.cycles += 12
+	}
+
+	// --- INC rr / DEC rr ---
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<4|0x03] = func(c *CPU, op uint8) {
+			*c.getRR((op >> 4) & 3)++
+			c.cycles += 8
+		}
+		gbOps[i<<4|0x0B] = func(c *CPU, op uint8) {
+			*c.getRR((op >> 4) & 3)--
+			c.cycles += 8
+		}
+	}
+
+	// --- ADD HL, rr ---
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<4|0x09] = func(c *CPU, op uint8) {
+			hl := c.reg.HL
+			val := *c.getRR((op >> 4) & 3)
+			result := uint32(hl) + uint32(val)
+			f := c.getF() & gbFlagZ
+			if result > 0xFFFF {
+				f |= gbFlagC
+			}
+			if (hl&0x0FFF)+(val&0x0FFF) > 0x0FFF {
+				f |= gbFlagH
+			}
+			c.setF(f)
+			c.reg.HL = uint16(result)
+			c.cycles += 8
+		}
+	}
+
+	// --- INC r / DEC r ---
+	for i := uint8(0); i < 8; i++ {
+		cost := uint64(4)
+		if i == 6 {
+			cost = 12
+		}
+		gbOps[i<<3|0x04] = func(c *CPU, op uint8) {
+			r := (op >> 3) & 7
+			val := c.getR8(r) + 1
+			c.setR8(r, val)
+			f := gbZ(val) | c.getF()&gbFlagC
+			if val&0x0F == 0 {
+				f |= gbFlagH
+			}
+			c.setF(f)
+			c.cycles += cost
+		}
+		gbOps[i<<3|0x05] = func(c *CPU, op uint8) {
+			r := (op >> 3) & 7
+			val := c.getR8(r) - 1
+			c.setR8(r, val)
+			f := gbZ(val) | gbFlagN | c.getF()&gbFlagC
+			if val&0x0F == 0x0F {
+				f |= gbFlagH
+			}
+			c.setF(f)
+			c.cycles += cost
+		}
+	}
+
+	// --- ALU A, r / A, (HL) / A, n ---
+	for i := 0; i < 64; i++ {
+		op := uint8(0x80 + i)
+		cost := uint64(4)
+		if op&7 == 6 {
+			cost = 8
+		}
+		gbOps[op] = func(c *CPU, op uint8) {
+			gbAlu(c, (op>>3)&7, c.getR8(op&7))
+			c.cycles += cost
+		}
+	}
+	for i := uint8(0); i < 8; i++ {
+		gbOps[i<<3|0xC6] = func(c *CPU, op uint8) {
+			gbAlu(c, (op>>3)&7, c.fetchPC())
+			c.cycles += 8
+		}
+	}
+
+	// --- RLCA / RRCA / RLA / RRA (Z, N and H are reset) ---
+	for _, op := range []uint8{0x07, 0x0F, 0x17, 0x1F} {
+		rot := op >> 3
+		gbOps[op] = func(c *CPU, _ uint8) {
+			result, f := gbRotShift(rot, c.getA(), c.getF())
+			c.setA(result)
+			c.setF(f & gbFlagC)
+			c.cycles += 4
+		}
+	}
+
+	// --- DAA ---
+	gbOps[0x27] = func(c *CPU, _ uint8) {
+		a := c.getA()
+		f := c.getF()
+		carry := f & gbFlagC
+		if f&gbFlagN == 0 {
+			if carry != 0 || a > 0x99 {
+				a += 0x60
+				carry = gbFlagC
+			}
+			if f&gbFlagH != 0 || a&0x0F > 0x09 {
+				a += 0x06
+			}
+		} else {
+			if carry != 0 {
+				a -= 0x60
+			}
+			if f&gbFlagH != 0 {
+				a -= 0x06
+			}
+		}
+		c.setA(a)
+		c.setF(gbZ(a) | f&gbFlagN | carry)
+		c.cycles += 4
+	}
+
+	// --- CPL / SCF / CCF ---
+	gbOps[0x2F] = func(c *CPU, _ uint8) {
+		c.setA(^c.getA())
+		c.setF(c.getF() | gbFlagN | gbFlagH)
+		c.cycles += 4
+	}
+	gbOps[0x37] = func(c *CPU, _ uint8) {
+		c.setF(c.getF()&gbFlagZ | gbFlagC)
+		c.cycles += 4
+	}
+	gbOps[0x3F] = func(c *CPU, _ uint8) {
+		f := c.getF()
+		c.setF(f&gbFlagZ | (f & gbFlagC) ^ gbFlagC)
+		c.cycles += 4
+	}
+
+	// --- STOP ---
+	// Two bytes long. Modeled as HALT; the system decides when to wake
+	// the CPU (joypad input, or a CGB speed switch).
+	gbOps[0x10] = func(c *CPU, _ uint8) {
+		c.reg.PC++
+		c.reg.Halted = true
+		c.cycles += 4
+	}
+
+	// --- JP nn / JP cc, nn / JP (HL) ---
+	gbOps[0xC3] = func(c *CPU, _ uint8) {
+		c.reg.PC = c.fetchPC16()
+		c.cycles += 16
+	}
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<3|0xC2] = func(c *CPU, op uint8) {
+			addr := c.fetchPC16()
+			if c.gbCC(op >> 3) {
+				c.reg.PC = addr
+				c.cycles += 16
+			} else {
+				c.cycles += 12
+			}
+		}
+	}
+	gbOps[0xE9] = func(c *CPU, _ uint8) {
+		c.reg.PC = c.reg.HL
+		c.cycles += 4
+	}
+
+	// --- JR e / JR cc, e ---
+	gbOps[0x18] = func(c *CPU, _ uint8) {
+		e := int8(c.fetchPC())
+		c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+		c.cycles += 12
+	}
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<3|0x20] = func(c *CPU, op uint8) {
+			e := int8(c.fetchPC())
+			if c.gbCC(op >> 3) {
+				c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+				c.cycles += 12
+			} else {
+				c.cycles += 8
+			}
+		}
+	}
+
+	// --- CALL nn / CALL cc, nn ---
+	gbOps[0xCD] = func(c *CPU, _ uint8) {
+		addr := c.fetchPC16()
+		c.push16(c.reg.PC)
+		c.reg.PC = addr
+		c.cycles += 24
+	}
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<3|0xC4] = func(c *CPU, op uint8) {
+			addr := c.fetchPC16()
+			if c.gbCC(op >> 3) {
+				c.push16(c.reg.PC)
+				c.reg.PC = addr
+				c.cycles += 24
+			} else {
+				c.cycles += 12
+			}
+		}
+	}
+
+	// --- RET / RET cc / RETI ---
+	gbOps[0xC9] = func(c *CPU, _ uint8) {
+		c.reg.PC = c.pop16()
+		c.cycles += 16
+	}
+	for i := uint8(0); i < 4; i++ {
+		gbOps[i<<3|0xC0] = func(c *CPU, op uint8) {
+			if c.gbCC(op >> 3) {
+				c.reg.PC = c.pop16()
+				c.cycles += 20
+			} else {
+				c.cycles += 8
+			}
+		}
+	}
+	// RETI enables interrupts immediately, without the EI delay.
+	gbOps[0xD9] = func(c *CPU, _ uint8) {
+		c.reg.PC = c.pop16()
+		c.reg.IFF1 = true
+		c.reg.IFF2 = true
+		c.cycles += 16
+	}
+
+	// --- RST p ---
+	for i := uint8(0); i < 8; i++ {
+		gbOps[i<<3|0xC7] = func(c *CPU, op uint8) {
+			c.push16(c.reg.PC)
+			c.reg.PC = uint16(op & 0x38)
+			c.cycles += 16
+		}
+	}
+
+	// --- CB prefix ---
+	gbOps[0xCB] = func(c *CPU, _ uint8) {
+		op := c.fetchOpcode()
+		gbCbOps[op](c, op)
+	}
+	for i := 0; i < 256; i++ {
+		op := uint8(i)
+		cost := uint64(8)
+		if op&7 == 6 {
+			// (HL): BIT reads only; the others read and write back.
+			cost = 16
+			if op>>6 == 1 {
+				cost = 12
+			}
+		}
+		switch op >> 6 {
+		case 0: // RLC, RRC, RL, RR, SLA, SRA, SWAP, SRL
+			gbCbOps[op] = func(c *CPU, op uint8) {
+				s := op & 7
+				result, f := gbRotShift((op>>3)&7, c.getR8(s), c.getF())
+				c.setR8(s, result)
+				c.setF(f)
+				c.cycles += cost
+			}
+		case 1: // BIT b, r
+			gbCbOps[op] = func(c *CPU, op uint8) {
+				f := c.getF()&gbFlagC | gbFlagH
+				if c.getR8(op&7)&(1<<((op>>3)&7)) == 0 {
+					f |= gbFlagZ
+				}
+				c.setF(f)
+				c.cycles += cost
+			}
+		case 2: // RES b, r
+			gbCbOps[op] = func(c *CPU, op uint8) {
+				s := op & 7
+				c.setR8(s, c.getR8(s)&^(1<<((op>>3)&7)))
+				c.cycles += cost
+			}
+		case 3: // SET b, r
+			gbCbOps[op] = func(c *CPU, op uint8) {
+				s := op & 7
+				c.setR8(s, c.getR8(s)|1<<((op>>3)&7))
+				c.cycles += cost
+			}
+		}
+	}
+}
+
+// gbAddSP returns SP + e with flags for ADD SP,e and LD HL,SP+e.
+func (c *CPU) gbAddSP(e int8) uint16 {
+	sp := c.reg.SP
+	v := uint16(int16(e))
+	var f uint8
+	if (sp&0x0F)+(v&0x0F) > 0x0F {
+		f |= gbFlagH
+	}
+	if (sp&0xFF)+(v&0xFF) > 0xFF {
+		f |= gbFlagC
+	}
+	c.setF(f)
+	return sp + v
+}
+
+// gbAlu performs an LR35902 ALU operation on A with operand b.
+// op: 0=ADD, 1=ADC, 2=SUB, 3=SBC, 4=AND, 5=XOR, 6=OR, 7=CP
+func gbAlu(c *CPU, op, b uint8) {
+	a := c.getA()
+	carry := uint8(0)
+	if c.getF()&gbFlagC != 0 {
+		carry = 1
+	}
+
+	var result, f uint8
+	switch op {
+	case 0, 1: // ADD, ADC
+		if op == 0 {
+			carry = 0
+		}
+		sum := uint16(a) + uint16(b) + uint16(carry)
+		result = uint8(sum)
+		f = gbZ(result)
+		if sum > 0xFF {
+			f |= gbFlagC
+		}
+		if a&0x0F+b&0x0F+carry > 0x0F {
+			f |= gbFlagH
+		}
+	case 2, 3, 7: // SUB, SBC, CP
+		if op != 3 {
+			carry = 0
+		}
+		diff := uint16(a) - uint16(b) - uint16(carry)
+		result = uint8(diff)
+		f = gbZ(result) | gbFlagN
+		if diff > 0xFF {
+			f |= gbFlagC
+		}
+		if a&0x0F < b&0x0F+carry {
+			f |= gbFlagH
+		}
+	case 4: // AND
+		result = a & b
+		f = gbZ(result) | gbFlagH
+	case 5: // XOR
+		result = a ^ b
+		f = gbZ(result)
+	case 6: // OR
+		result = a | b
+		f = gbZ(result)
+	}
+
+	if op != 7 {
+		c.setA(result)
+	}
+	c.setF(f)
+}
+
+// gbRotShift performs one of 8 CB rotate/shift operations with LR35902
+// flags. SWAP replaces the Z80's undocumented SLL.
+// rot: 0=RLC, 1=RRC, 2=RL, 3=RR, 4=SLA, 5=SRA, 6=SWAP, 7=SRL
+func gbRotShift(rot, val, oldF uint8) (result uint8, f uint8) {
+	var carry uint8
+	switch rot {
+	case 0: // RLC
+		carry = val >> 7
+		result = val<<1 | carry
+	case 1: // RRC
+		carry = val & 1
+		result = val>>1 | carry<<7
+	case 2: // RL
+		carry = val >> 7
+		result = val << 1
+		if oldF&gbFlagC != 0 {
+			result |= 1
+		}
+	case 3: // RR
+		carry = val & 1
+		result = val >> 1
+		if oldF&gbFlagC != 0 {
+			result |= 0x80
+		}
+	case 4: // SLA
+		carry = val >> 7
+		result = val << 1
+	case 5: // SRA
+		carry = val & 1
+		result = val>>1 | val&0x80
+	case 6: // SWAP
+		result = val<<4 | val>>4
+	case 7: // SRL
+		carry = val & 1
+		result = val >> 1
+	}
+	f = gbZ(result)
+	if carry != 0 {
+		f |= gbFlagC
+	}
+	return
+}
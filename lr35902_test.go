@@ -0,0 +1,320 @@
+package z80
+
+import "testing"
+
+func newTestGB() (*CPU, *testBus) {
+	bus := &testBus{}
+	cpu := New(bus, WithVariant(VariantLR35902))
+	cpu.reg.SP = 0xFFFE
+	return cpu, bus
+}
+
+func TestLR35902_Variant(t *testing.T) {
+	cpu, _ := newTestGB()
+	if cpu.Variant() != VariantLR35902 {
+		t.Errorf("Variant = %v, want LR35902", cpu.Variant())
+	}
+	if cpu.reg.AF != 0xFFF0 {
+		t.Errorf("AF after reset = %04x, want FFF0", cpu.reg.AF)
+	}
+}
+
+func TestLR35902_OpsNilCheck(t *testing.T) {
+	newTestGB()
+	for i := 0; i < 256; i++ {
+		if gbOps[i] == nil {
+			t.Errorf("gbOps[0x%02X] is nil", i)
+		}
+		if gbCbOps[i] == nil {
+			t.Errorf("gbCbOps[0x%02X] is nil", i)
+		}
+	}
+}
+
+func TestLR35902_Timing(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []uint8
+		wantPC uint16
+		cycles int
+	}{
+		{"NOP", []uint8{0x00}, 0x0001, 4},
+		{"LD B,n", []uint8{0x06, 0x12}, 0x0002, 8},
+		{"LD (HL),n", []uint8{0x36, 0x12}, 0x0002, 12},
+		{"LD BC,nn", []uint8{0x01, 0x34, 0x12}, 0x0003, 12},
+		{"LD (nn),SP", []uint8{0x08, 0x00, 0x80}, 0x0003, 20},
+		{"LDH (n),A", []uint8{0xE0, 0x80}, 0x0002, 12},
+		{"LD (nn),A", []uint8{0xEA, 0x00, 0x80}, 0x0003, 16},
+		{"ADD SP,e", []uint8{0xE8, 0x01}, 0x0002, 16},
+		{"PUSH BC", []uint8{0xC5}, 0x0001, 16},
+		{"JP nn", []uint8{0xC3, 0x00, 0x20}, 0x2000, 16},
+		{"JR e", []uint8{0x18, 0x10}, 0x0012, 12},
+		{"CALL nn", []uint8{0xCD, 0x00, 0x20}, 0x2000, 24},
+		{"RST 38", []uint8{0xFF}, 0x0038, 16},
+		{"SWAP B", []uint8{0xCB, 0x30}, 0x0002, 8},
+		{"BIT 0,(HL)", []uint8{0xCB, 0x46}, 0x0002, 12},
+		{"SET 0,(HL)", []uint8{0xCB, 0xC6}, 0x0002, 16},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTestGB()
+			cpu.reg.HL = 0x8000
+			copy(bus.mem[:], tc.code)
+			cycles := cpu.Step()
+			if cpu.reg.PC != tc.wantPC {
+				t.Errorf("PC = %04x, want %04x", cpu.reg.PC, tc.wantPC)
+			}
+			if cycles != tc.cycles {
+				t.Errorf("cycles = %d, want %d", cycles, tc.cycles)
+			}
+		})
+	}
+}
+
+func TestLR35902_ConditionalTiming(t *testing.T) {
+	tests := []struct {
+		name   string
+		f      uint8
+		code   []uint8
+		cycles int
+	}{
+		{"JP NZ taken", 0x00, []uint8{0xC2, 0x00, 0x20}, 16},
+		{"JP NZ not taken", gbFlagZ, []uint8{0xC2, 0x00, 0x20}, 12},
+		{"JR C taken", gbFlagC, []uint8{0x38, 0x02}, 12},
+		{"JR C not taken", 0x00, []uint8{0x38, 0x02}, 8},
+		{"CALL Z taken", gbFlagZ, []uint8{0xCC, 0x00, 0x20}, 24},
+		{"CALL Z not taken", 0x00, []uint8{0xCC, 0x00, 0x20}, 12},
+		{"RET NC taken", 0x00, []uint8{0xD0}, 20},
+		{"RET NC not taken", gbFlagC, []uint8{0xD0}, 8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTestGB()
+			cpu.setF(tc.f)
+			copy(bus.mem[:], tc.code)
+			if cycles := cpu.Step(); cycles != tc.cycles {
+				t.Errorf("cycles = %d, want %d", cycles, tc.cycles)
+			}
+		})
+	}
+}
+
+func TestLR35902_FlagLayout(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.setA(0x0F)
+	cpu.setB(0x01)
+	bus.mem[0] = 0x80 // ADD A,B
+	cpu.Step()
+	if cpu.getA() != 0x10 || cpu.getF() != gbFlagH {
+		t.Errorf("ADD: A=%02x F=%02x, want A=10 F=%02x", cpu.getA(), cpu.getF(), gbFlagH)
+	}
+
+	cpu.setA(0x00)
+	bus.mem[1] = 0x90 // SUB B
+	cpu.Step()
+	want := gbFlagN | gbFlagH | gbFlagC
+	if cpu.getA() != 0xFF || cpu.getF() != want {
+		t.Errorf("SUB: A=%02x F=%02x, want A=FF F=%02x", cpu.getA(), cpu.getF(), want)
+	}
+
+	cpu.setA(0x01)
+	bus.mem[2] = 0xB8 // CP B
+	cpu.Step()
+	if cpu.getF() != gbFlagZ|gbFlagN {
+		t.Errorf("CP: F=%02x, want %02x", cpu.getF(), gbFlagZ|gbFlagN)
+	}
+}
+
+func TestLR35902_LDH(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.setA(0x42)
+	cpu.setC(0x81)
+	copy(bus.mem[:], []uint8{0xE0, 0x80, 0xE2, 0xF0, 0x81})
+	cpu.Step()
+	cpu.Step()
+	if bus.mem[0xFF80] != 0x42 || bus.mem[0xFF81] != 0x42 {
+		t.Errorf("LDH stores: FF80=%02x FF81=%02x, want 42", bus.mem[0xFF80], bus.mem[0xFF81])
+	}
+	bus.mem[0xFF81] = 0x99
+	cpu.Step()
+	if cpu.getA() != 0x99 {
+		t.Errorf("LDH A,(n) = %02x, want 99", cpu.getA())
+	}
+}
+
+func TestLR35902_LDHLIncDec(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.reg.HL = 0xC000
+	cpu.setA(0x11)
+	copy(bus.mem[:], []uint8{0x22, 0x32, 0x2A})
+	cpu.Step()
+	if bus.mem[0xC000] != 0x11 || cpu.reg.HL != 0xC001 {
+		t.Errorf("LD (HL+),A: mem=%02x HL=%04x", bus.mem[0xC000], cpu.reg.HL)
+	}
+	cpu.Step()
+	if bus.mem[0xC001] != 0x11 || cpu.reg.HL != 0xC000 {
+		t.Errorf("LD (HL-),A: mem=%02x HL=%04x", bus.mem[0xC001], cpu.reg.HL)
+	}
+	bus.mem[0xC000] = 0x22
+	cpu.Step()
+	if cpu.getA() != 0x22 || cpu.reg.HL != 0xC001 {
+		t.Errorf("LD A,(HL+): A=%02x HL=%04x", cpu.getA(), cpu.reg.HL)
+	}
+}
+
+func TestLR35902_SWAP(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.setF(gbFlagC)
+	cpu.setB(0x3C)
+	copy(bus.mem[:], []uint8{0xCB, 0x30, 0xCB, 0x31})
+	cpu.Step()
+	if cpu.getB() != 0xC3 || cpu.getF() != 0 {
+		t.Errorf("SWAP B: B=%02x F=%02x, want C3 00", cpu.getB(), cpu.getF())
+	}
+	cpu.Step()
+	if cpu.getF() != gbFlagZ {
+		t.Errorf("SWAP C of 0: F=%02x, want %02x", cpu.getF(), gbFlagZ)
+	}
+}
+
+func TestLR35902_DAA(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.setA(0x15)
+	cpu.setB(0x27)
+	copy(bus.mem[:], []uint8{0x80, 0x27}) // ADD A,B; DAA
+	cpu.Step()
+	cpu.Step()
+	if cpu.getA() != 0x42 || cpu.getF() != 0 {
+		t.Errorf("DAA: A=%02x F=%02x, want 42 00", cpu.getA(), cpu.getF())
+	}
+}
+
+func TestLR35902_AddSP(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.reg.SP = 0x00FF
+	copy(bus.mem[:], []uint8{0xF8, 0x01, 0xE8, 0xFF})
+	cpu.Step()
+	if cpu.reg.HL != 0x0100 || cpu.getF() != gbFlagH|gbFlagC {
+		t.Errorf("LD HL,SP+1: HL=%04x F=%02x", cpu.reg.HL, cpu.getF())
+	}
+	cpu.Step()
+	if cpu.reg.SP != 0x00FE || cpu.getF() != gbFlagH|gbFlagC {
+		t.Errorf("ADD SP,-1: SP=%04x F=%02x", cpu.reg.SP, cpu.getF())
+	}
+}
+
+func TestLR35902_PopAFMasksF(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.reg.SP = 0xC000
+	bus.mem[0xC000] = 0xFF
+	bus.mem[0xC001] = 0x12
+	bus.mem[0] = 0xF1
+	cpu.Step()
+	if cpu.reg.AF != 0x12F0 {
+		t.Errorf("AF = %04x, want 12F0", cpu.reg.AF)
+	}
+}
+
+func TestLR35902_Interrupt(t *testing.T) {
+	cpu, bus := newTestGB()
+	cpu.reg.PC = 0x0150
+	cpu.reg.IFF1 = true
+	bus.mem[gbIFAddr] = 0x05 // V-blank and timer requested
+	cpu.INT(true, 0x04|0x01)
+	cycles := cpu.Step()
+	if cpu.reg.PC != 0x0040 {
+		t.Errorf("PC = %04x, want 0040", cpu.reg.PC)
+	}
+	if cycles != 20 {
+		t.Errorf("cycles = %d, want 20", cycles)
+	}
+	if bus.mem[gbIFAddr] != 0x04 {
+		t.Errorf("IF = %02x, want 04", bus.mem[gbIFAddr])
+	}
+	if cpu.reg.IFF1 {
+		t.Error("IME still set after dispatch")
+	}
+	if cpu.read16(cpu.reg.SP) != 0x0150 {
+		t.Errorf("return address = %04x, want 0150", cpu.read16(cpu.reg.SP))
+	}
+
+	// RETI re-enables interrupts with no delay.
+	cpu.INT(true, 0x04)
+	bus.mem[0x0040] = 0xD9
+	cpu.Step()
+	if cpu.reg.PC != 0x0150 || !cpu.reg.IFF1 {
+		t.Fatalf("RETI: PC=%04x IME=%v", cpu.reg.PC, cpu.reg.IFF1)
+	}
+	cpu.Step()
+	if cpu.reg.PC != 0x0050 {
+		t.Errorf("timer dispatch PC = %04x, want 0050", cpu.reg.PC)
+	}
+}
+
+func TestLR35902_EmptyMaskDoesNotAssert(t *testing.T) {
+	cpu, _ := newTestGB()
+	cpu.reg.IFF1 = true
+	cpu.INT(true, 0x00)
+	cpu.Step()
+	if cpu.reg.PC != 0x0001 || !cpu.reg.IFF1 {
+		t.Errorf("PC=%04x IME=%v, want NOP executed", cpu.reg.PC, cpu.reg.IFF1)
+	}
+}
+
+func TestLR35902_LoadedEmptyMaskNotServiced(t *testing.T) {
+	src, _ := newTestGB()
+	src.reg.IFF1 = true
+	src.intLine = true // not reachable through INT
+	src.intData = 0x00
+	buf := make([]byte, SerializeSize)
+	if err := src.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	cpu, _ := newTestGB()
+	if err := cpu.Deserialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Step()
+	if cpu.reg.PC != 0x0001 || !cpu.reg.IFF1 {
+		t.Errorf("PC=%04x IME=%v, want NOP executed", cpu.reg.PC, cpu.reg.IFF1)
+	}
+}
+
+func TestLR35902_HaltWakesWithIMEClear(t *testing.T) {
+	cpu, bus := newTestGB()
+	copy(bus.mem[:], []uint8{0x76, 0x3C}) // HALT; INC A
+	cpu.setA(0)
+	cpu.Step()
+	cpu.Step()
+	if !cpu.reg.Halted || cpu.reg.PC != 0x0001 {
+		t.Fatalf("Halted=%v PC=%04x, want halted at 0001", cpu.reg.Halted, cpu.reg.PC)
+	}
+	cpu.INT(true, 0x01)
+	cpu.Step()
+	if cpu.reg.Halted || cpu.getA() != 1 {
+		t.Errorf("Halted=%v A=%02x, want resumed without dispatch", cpu.reg.Halted, cpu.getA())
+	}
+}
+
+func TestLR35902_RemovedOpcodeHangs(t *testing.T) {
+	cpu, bus := newTestGB()
+	bus.mem[0] = 0xDD
+	for i := 0; i < 3; i++ {
+		if cycles := cpu.Step(); cycles != 4 {
+			t.Errorf("cycles = %d, want 4", cycles)
+		}
+	}
+	if cpu.reg.PC != 0 {
+		t.Errorf("PC = %04x, want 0000", cpu.reg.PC)
+	}
+}
+
+func TestLR35902_NoRefresh(t *testing.T) {
+	cpu, _ := newTestGB()
+	for i := 0; i < 10; i++ {
+		cpu.Step()
+	}
+	if cpu.reg.R != 0 {
+		t.Errorf("R = %02x, want 00", cpu.reg.R)
+	}
+}
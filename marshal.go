@@ -0,0 +1,304 @@
+package z80
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MarshalBinary returns the CPU state in the Serialize format, trimmed
+// to its length. It implements encoding.BinaryMarshaler.
+func (c *CPU) MarshalBinary() ([]byte, error) {
+	return c.AppendBinary(nil)
+}
+
+// AppendBinary appends the CPU state in the Serialize format to b. It
+// implements encoding.BinaryAppender.
+func (c *CPU) AppendBinary(b []byte) ([]byte, error) {
+	n := len(b)
+	b = append(b, make([]byte, SerializeSize)...)
+	if err := c.Serialize(b[n:]); err != nil {
+		return b[:n], err
+	}
+	return b[:n+int(binary.LittleEndian.Uint16(b[n+1:]))], nil
+}
+
+// UnmarshalBinary restores state produced by MarshalBinary, AppendBinary
+// or Serialize, as Deserialize does. It implements
+// encoding.BinaryUnmarshaler.
+func (c *CPU) UnmarshalBinary(data []byte) error {
+	return c.Deserialize(data)
+}
+
+// cpuJSON is the JSON form of the CPU state.
+type cpuJSON struct {
+	Variant    string         `json:"variant"`
+	Registers  Registers      `json:"registers"`
+	Cycles     uint64         `json:"cycles"`
+	Deficit    int            `json:"deficit"`
+	NextEvent  *uint64        `json:"nextEvent,omitempty"`
+	IntLine    bool           `json:"intLine"`
+	IntData    uint8          `json:"intData"`
+	IntAt      uint64         `json:"intAt,omitempty"`
+	IntEnd     *uint64        `json:"intEnd,omitempty"`
+	NMIPending bool           `json:"nmiPending"`
+	NMIAt      uint64         `json:"nmiAt,omitempty"`
+	AfterEI    bool           `json:"afterEI"`
+	AfterLDAIR bool           `json:"afterLDAIR"`
+	EZ80       *EZ80Registers `json:"ez80,omitempty"`
+	R800Page   *int           `json:"r800Page,omitempty"`
+}
+
+// MarshalJSON returns the CPU state as a JSON object: the variant, the
+// registers, the cycle counter and deficit, the event target if one is
+// set, the interrupt lines and latches with the times given to INTAt
+// and NMIAt, the eZ80 registers on that variant, and the R800's open
+// DRAM page (-1 if none) on that variant. It implements json.Marshaler.
+func (c *CPU) MarshalJSON() ([]byte, error) {
+	s, err := c.jsonState()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON restores state produced by MarshalJSON. The variant is
+// required; other fields missing from data get their value after Reset.
+// It implements json.Unmarshaler.
+func (c *CPU) UnmarshalJSON(data []byte) error {
+	s := cpuJSON{IntData: 0xFF}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return c.setJSONState(&s)
+}
+
+// jsonState returns the state MarshalJSON and MarshalText write.
+func (c *CPU) jsonState() (*cpuJSON, error) {
+	if c.MidInstruction() {
+		return nil, ErrMidInstruction
+	}
+	s := &cpuJSON{
+		Variant:    c.variant.String(),
+		Registers:  c.reg,
+		Cycles:     c.cycles,
+		Deficit:    c.deficit,
+		IntLine:    c.intLine,
+		IntData:    c.intData,
+		IntAt:      c.intAt,
+		NMIPending: c.nmiPending,
+		NMIAt:      c.nmiAt,
+		AfterEI:    c.afterEI,
+		AfterLDAIR: c.afterLDAIR,
+	}
+	if c.ez != nil {
+		regs := c.ez.EZ80Registers
+		s.EZ80 = &regs
+	}
+	if c.nextEvent != noEvent {
+		next := c.nextEvent
+		s.NextEvent = &next
+	}
+	if c.intEnd != noEvent {
+		end := c.intEnd
+		s.IntEnd = &end
+	}
+	if c.r800 != nil {
+		page := c.r800.page
+		s.R800Page = &page
+	}
+	return s, nil
+}
+
+// setJSONState loads state read by UnmarshalJSON or UnmarshalText.
+func (c *CPU) setJSONState(s *cpuJSON) error {
+	if s.Variant == "" {
+		return ErrCorruptSaveState
+	}
+	if s.Variant != c.variant.String() {
+		return ErrVariantMismatch
+	}
+	if c.tick != nil {
+		c.tick.abort()
+	}
+	c.deserializeDefaults()
+	c.reg = s.Registers
+	c.cycles = s.Cycles
+	c.deficit = s.Deficit
+	if s.NextEvent != nil {
+		c.nextEvent = *s.NextEvent
+	}
+	c.intLine = s.IntLine
+	c.intData = s.IntData
+	c.intAt = s.IntAt
+	if s.IntEnd != nil {
+		c.intEnd = *s.IntEnd
+	}
+	c.nmiPending = s.NMIPending
+	c.nmiAt = s.NMIAt
+	c.afterEI = s.AfterEI
+	c.afterLDAIR = s.AfterLDAIR
+	if c.ez != nil && s.EZ80 != nil {
+		c.SetEZ80State(*s.EZ80)
+	}
+	if c.r800 != nil && s.R800Page != nil && *s.R800Page >= -1 && *s.R800Page <= 0xFF {
+		c.r800.page = *s.R800Page
+	}
+	c.ixiyReg = &c.reg.HL
+	return nil
+}
+
+// MarshalText returns the same state as MarshalJSON as one line of
+// space-separated name=value fields, such as
+//
+//	variant=Z80 AF=0044 BC=0000 ... PC=0100 I=00 R=05 IFF1=1 ... cycles=1234
+//
+// Registers and intData are in hex, counters in decimal, and flags are 0
+// or 1. Fields MarshalJSON omits are left out. It implements
+// encoding.TextMarshaler.
+func (c *CPU) MarshalText() ([]byte, error) {
+	s, err := c.jsonState()
+	if err != nil {
+		return nil, err
+	}
+	b := []byte("variant=" + s.Variant)
+	for _, f := range s.textFields() {
+		var v string
+		switch p := f.p.(type) {
+		case *bool:
+			v = "0"
+			if *p {
+				v = "1"
+			}
+		case *uint8:
+			v = fmt.Sprintf("%0*X", f.digits, *p)
+		case *uint16:
+			v = fmt.Sprintf("%0*X", f.digits, *p)
+		case *uint32:
+			v = fmt.Sprintf("%0*X", f.digits, *p)
+		case *uint64:
+			v = strconv.FormatUint(*p, 10)
+		case *int:
+			v = strconv.Itoa(*p)
+		case **uint64:
+			if *p == nil {
+				continue
+			}
+			v = strconv.FormatUint(**p, 10)
+		case **int:
+			if *p == nil {
+				continue
+			}
+			v = strconv.Itoa(**p)
+		}
+		b = fmt.Appendf(b, " %s=%s", f.name, v)
+	}
+	return b, nil
+}
+
+// UnmarshalText restores state produced by MarshalText. As with
+// UnmarshalJSON the variant is required, fields missing from text get
+// their value after Reset, and unknown fields are ignored. A field that
+// cannot be parsed returns ErrCorruptSaveState. It implements
+// encoding.TextUnmarshaler.
+func (c *CPU) UnmarshalText(text []byte) error {
+	s := cpuJSON{IntData: 0xFF}
+	if c.ez != nil {
+		s.EZ80 = &EZ80Registers{SPL: 0xFFFFFF}
+	}
+	fields := make(map[string]textField)
+	for _, f := range s.textFields() {
+		fields[f.name] = f
+	}
+	for _, kv := range strings.Fields(string(text)) {
+		name, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ErrCorruptSaveState
+		}
+		if name == "variant" {
+			s.Variant = v
+			continue
+		}
+		if f, ok := fields[name]; ok && f.parse(v) != nil {
+			return ErrCorruptSaveState
+		}
+	}
+	return c.setJSONState(&s)
+}
+
+// textField is a field of the text form: its name, a pointer into a
+// cpuJSON, and the number of hex digits it is written with.
+type textField struct {
+	name   string
+	p      any
+	digits int
+}
+
+// textFields lists the fields of s in the order MarshalText writes them,
+// after the variant.
+func (s *cpuJSON) textFields() []textField {
+	r := &s.Registers
+	f := []textField{
+		{"AF", &r.AF, 4}, {"BC", &r.BC, 4}, {"DE", &r.DE, 4}, {"HL", &r.HL, 4},
+		{"AF'", &r.AF_, 4}, {"BC'", &r.BC_, 4}, {"DE'", &r.DE_, 4}, {"HL'", &r.HL_, 4},
+		{"IX", &r.IX, 4}, {"IY", &r.IY, 4}, {"SP", &r.SP, 4}, {"PC", &r.PC, 4},
+		{"I", &r.I, 2}, {"R", &r.R, 2}, {"IFF1", &r.IFF1, 0}, {"IFF2", &r.IFF2, 0},
+		{"IM", &r.IM, 1}, {"halted", &r.Halted, 0}, {"WZ", &r.WZ, 4},
+		{"cycles", &s.Cycles, 0}, {"deficit", &s.Deficit, 0}, {"nextEvent", &s.NextEvent, 0},
+		{"intLine", &s.IntLine, 0}, {"intData", &s.IntData, 2},
+		{"intAt", &s.IntAt, 0}, {"intEnd", &s.IntEnd, 0},
+		{"nmiPending", &s.NMIPending, 0}, {"nmiAt", &s.NMIAt, 0},
+		{"afterEI", &s.AfterEI, 0}, {"afterLDAIR", &s.AfterLDAIR, 0},
+	}
+	if e := s.EZ80; e != nil {
+		f = append(f, []textField{
+			{"BCU", &e.BCU, 2}, {"DEU", &e.DEU, 2}, {"HLU", &e.HLU, 2},
+			{"BCU'", &e.BCU_, 2}, {"DEU'", &e.DEU_, 2}, {"HLU'", &e.HLU_, 2},
+			{"IXU", &e.IXU, 2}, {"IYU", &e.IYU, 2}, {"SPL", &e.SPL, 6},
+			{"PCU", &e.PCU, 2}, {"MB", &e.MB, 2}, {"ADL", &e.ADL, 0}, {"MADL", &e.MADL, 0},
+		}...)
+	}
+	return append(f, textField{"r800Page", &s.R800Page, 0})
+}
+
+// parse sets the field from its text form.
+func (f textField) parse(v string) error {
+	switch p := f.p.(type) {
+	case *bool:
+		if v != "0" && v != "1" {
+			return strconv.ErrSyntax
+		}
+		*p = v == "1"
+	case *uint8:
+		n, err := strconv.ParseUint(v, 16, 8)
+		*p = uint8(n)
+		return err
+	case *uint16:
+		n, err := strconv.ParseUint(v, 16, 16)
+		*p = uint16(n)
+		return err
+	case *uint32:
+		n, err := strconv.ParseUint(v, 16, 32)
+		*p = uint32(n)
+		return err
+	case *uint64:
+		n, err := strconv.ParseUint(v, 10, 64)
+		*p = n
+		return err
+	case *int:
+		n, err := strconv.Atoi(v)
+		*p = n
+		return err
+	case **uint64:
+		n, err := strconv.ParseUint(v, 10, 64)
+		*p = &n
+		return err
+	case **int:
+		n, err := strconv.Atoi(v)
+		*p = &n
+		return err
+	}
+	return nil
+}
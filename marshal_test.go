@@ -0,0 +1,251 @@
+package z80
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*CPU)(nil)
+	_ encoding.BinaryAppender    = (*CPU)(nil)
+	_ encoding.BinaryUnmarshaler = (*CPU)(nil)
+	_ json.Marshaler             = (*CPU)(nil)
+	_ json.Unmarshaler           = (*CPU)(nil)
+	_ encoding.TextMarshaler     = (*CPU)(nil)
+	_ encoding.TextUnmarshaler   = (*CPU)(nil)
+)
+
+func newMarshalCPU() *CPU {
+	cpu, _ := newTestCPU()
+	cpu.reg = Registers{AF: 0x1234, HL: 0xBEEF, SP: 0x7FF0, PC: 0x0100, I: 0x3F, R: 0x12, IFF1: true, IM: 1}
+	cpu.cycles = 987654
+	cpu.deficit = 3
+	cpu.intLine = true
+	cpu.intData = 0xD7
+	cpu.nmiPending = true
+	cpu.afterEI = true
+	return cpu
+}
+
+func TestMarshalBinary_RoundTrip(t *testing.T) {
+	cpu := newMarshalCPU()
+	data, err := cpu.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= SerializeSize {
+		t.Errorf("len = %d, want trimmed below %d", len(data), SerializeSize)
+	}
+	cpu2, _ := newTestCPU()
+	if err := cpu2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.reg != cpu.reg || cpu2.cycles != cpu.cycles || cpu2.intData != cpu.intData {
+		t.Errorf("state = %+v, want %+v", cpu2.reg, cpu.reg)
+	}
+}
+
+func TestAppendBinary(t *testing.T) {
+	cpu := newMarshalCPU()
+	want, _ := cpu.MarshalBinary()
+	got, err := cpu.AppendBinary([]byte("hdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, []byte("hdr")) || !bytes.Equal(got[3:], want) {
+		t.Errorf("AppendBinary = % x, want hdr + % x", got, want)
+	}
+
+	cpu.Tick()
+	if got, err := cpu.AppendBinary([]byte("hdr")); !errors.Is(err, ErrMidInstruction) || string(got) != "hdr" {
+		t.Errorf("mid-instruction: %q, %v", got, err)
+	}
+}
+
+func TestMarshalJSON_RoundTrip(t *testing.T) {
+	cpu := newMarshalCPU()
+	cpu.afterLDAIR = true
+	data, err := json.Marshal(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"variant":"Z80"`, `"PC":256`, `"intLine":true`, `"deficit":3`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON %s missing %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "ez80") {
+		t.Errorf("JSON %s has eZ80 state on a Z80", data)
+	}
+
+	cpu2, _ := newTestCPU()
+	if err := json.Unmarshal(data, cpu2); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.reg != cpu.reg || cpu2.cycles != cpu.cycles || cpu2.deficit != cpu.deficit ||
+		cpu2.intLine != cpu.intLine || cpu2.intData != cpu.intData || cpu2.nmiPending != cpu.nmiPending ||
+		cpu2.afterEI != cpu.afterEI || cpu2.afterLDAIR != cpu.afterLDAIR {
+		t.Error("state differs after JSON round trip")
+	}
+}
+
+func TestMarshalJSON_EZ80AndVariant(t *testing.T) {
+	cpu := New(&testBus{}, WithVariant(VariantEZ80))
+	regs := EZ80Registers{HLU: 0x12, SPL: 0xD00000, MB: 0xD0, ADL: true}
+	cpu.SetEZ80State(regs)
+	data, err := json.Marshal(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu2 := New(&testBus{}, WithVariant(VariantEZ80))
+	if err := json.Unmarshal(data, cpu2); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.EZ80Registers() != regs {
+		t.Errorf("eZ80 registers = %+v, want %+v", cpu2.EZ80Registers(), regs)
+	}
+	z, _ := newTestCPU()
+	if err := json.Unmarshal(data, z); !errors.Is(err, ErrVariantMismatch) {
+		t.Errorf("err = %v, want ErrVariantMismatch", err)
+	}
+}
+
+func TestMarshalJSON_PendingRelease(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0x0000] = 0x00 // NOP
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.INT(true, 0xFF)
+	cpu.INTAt(false, 0xFF, 100)
+	cpu.NMIAt(200)
+	data, err := json.Marshal(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu2, bus2 := newTestCPU()
+	bus2.mem = bus.mem
+	if err := json.Unmarshal(data, cpu2); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.intAt != cpu.intAt || cpu2.intEnd != 100 || cpu2.nmiAt != 200 {
+		t.Errorf("intAt=%d intEnd=%d nmiAt=%d, want %d 100 200", cpu2.intAt, cpu2.intEnd, cpu2.nmiAt, cpu.intAt)
+	}
+	cpu.SetCycles(150)
+	cpu2.SetCycles(150)
+	cpu.Step()
+	cpu2.Step()
+	if cpu2.reg.PC != cpu.reg.PC || cpu2.reg.PC == 0x0038 {
+		t.Errorf("PC = %04x, want %04x with INT released", cpu2.reg.PC, cpu.reg.PC)
+	}
+}
+
+func TestUnmarshalJSON_VariantRequired(t *testing.T) {
+	cpu, _ := newTestCPU()
+	for _, data := range []string{`{"registers":{"PC":256}}`, `{"variant":"","cycles":5}`} {
+		if err := json.Unmarshal([]byte(data), cpu); !errors.Is(err, ErrCorruptSaveState) {
+			t.Errorf("%s: err = %v, want ErrCorruptSaveState", data, err)
+		}
+	}
+	if cpu.reg.PC != 0 || cpu.cycles != 0 {
+		t.Error("state loaded without a variant")
+	}
+}
+
+func TestMarshalText_RoundTrip(t *testing.T) {
+	cpu := newMarshalCPU()
+	cpu.afterLDAIR = true
+	cpu.SetNextEvent(1000000)
+	cpu.INTAt(false, 0xD7, 999000)
+	cpu.NMIAt(999100)
+	text, err := cpu.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"variant=Z80 AF=1234 ", " PC=0100 ", " IFF1=1 ", " cycles=987654 ", " intData=D7 ", " intEnd=999000 "} {
+		if !strings.Contains(string(text), want) {
+			t.Errorf("text %q missing %q", text, want)
+		}
+	}
+	if bytes.ContainsAny(text, "\n") || bytes.Contains(text, []byte("ADL")) {
+		t.Errorf("text %q is not one line of Z80 state", text)
+	}
+
+	cpu2, _ := newTestCPU()
+	if err := cpu2.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.reg != cpu.reg || cpu2.cycles != cpu.cycles || cpu2.deficit != cpu.deficit ||
+		cpu2.nextEvent != cpu.nextEvent || cpu2.intLine != cpu.intLine || cpu2.intData != cpu.intData ||
+		cpu2.intAt != cpu.intAt || cpu2.intEnd != cpu.intEnd || cpu2.nmiPending != cpu.nmiPending ||
+		cpu2.nmiAt != cpu.nmiAt || cpu2.afterEI != cpu.afterEI || cpu2.afterLDAIR != cpu.afterLDAIR {
+		t.Errorf("state differs after text round trip:\n%s", text)
+	}
+}
+
+func TestMarshalText_Variants(t *testing.T) {
+	ez := New(&testBus{}, WithVariant(VariantEZ80))
+	regs := EZ80Registers{HLU: 0x12, SPL: 0xD00000, MB: 0xD0, ADL: true}
+	ez.SetEZ80State(regs)
+	text, err := ez.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ez2 := New(&testBus{}, WithVariant(VariantEZ80))
+	if err := ez2.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if ez2.EZ80Registers() != regs {
+		t.Errorf("eZ80 registers = %+v, want %+v", ez2.EZ80Registers(), regs)
+	}
+
+	r800 := New(&testBus{}, WithVariant(VariantR800))
+	r800.r800.page = 0x42
+	text, err = r800.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(&testBus{}, WithVariant(VariantR800))
+	if err := r2.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if r2.r800.page != 0x42 {
+		t.Errorf("R800 page = %d, want 0x42", r2.r800.page)
+	}
+
+	z, _ := newTestCPU()
+	if err := z.UnmarshalText(text); !errors.Is(err, ErrVariantMismatch) {
+		t.Errorf("err = %v, want ErrVariantMismatch", err)
+	}
+}
+
+func TestUnmarshalText_Errors(t *testing.T) {
+	for _, text := range []string{
+		"PC=0100",               // no variant
+		"variant=Z80 PC",        // no value
+		"variant=Z80 PC=10000",  // out of range
+		"variant=Z80 IFF1=true", // not 0 or 1
+		"variant=Z80 cycles=-1", // negative counter
+	} {
+		cpu, _ := newTestCPU()
+		if err := cpu.UnmarshalText([]byte(text)); !errors.Is(err, ErrCorruptSaveState) {
+			t.Errorf("%q: err = %v, want ErrCorruptSaveState", text, err)
+		}
+		if cpu.reg.PC != 0 {
+			t.Errorf("%q: state loaded", text)
+		}
+	}
+
+	// Unknown fields are skipped and missing ones take their Reset value.
+	cpu, _ := newTestCPU()
+	cpu.intData = 0
+	if err := cpu.UnmarshalText([]byte("variant=Z80 PC=0100 future=7")); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.reg.PC != 0x0100 || cpu.intData != 0xFF {
+		t.Errorf("PC=%04x intData=%02x, want 0100 FF", cpu.reg.PC, cpu.intData)
+	}
+}
@@ -0,0 +1,108 @@
+package z80
+
+import "errors"
+
+// PageSize is the granularity of the memory map. Larger banks (1K, 8K,
+// 16K) are mapped as runs of consecutive pages.
+const PageSize = 256
+
+// PageFlags selects which accesses a mapped page serves directly.
+type PageFlags uint8
+
+const (
+	// PageRead serves Fetch and Read from the page.
+	PageRead PageFlags = 1 << iota
+	// PageWrite stores Write into the page.
+	PageWrite
+	// PageM1 sends opcode fetches to Bus.Fetch so the system still sees
+	// M1 cycles (for wait states, contention or fetch-triggered banking);
+	// operand reads are still served from the page.
+	PageM1
+)
+
+// memMap holds the pages installed by MapMemory. While any are mapped
+// the CPU's access helpers index it on every memory access and call the
+// Bus only when the page is nil. Pages are indexed by the high byte of
+// the address.
+type memMap struct {
+	fetch [256]*[PageSize]uint8
+	read  [256]*[PageSize]uint8
+	write [256]*[PageSize]uint8
+	pages int // pages with any access mapped
+}
+
+// set installs p (nil to unmap) at page n with the given flags.
+func (m *memMap) set(n uint8, p *[PageSize]uint8, flags PageFlags) {
+	if m.fetch[n] != nil || m.read[n] != nil || m.write[n] != nil {
+		m.pages--
+	}
+	m.fetch[n], m.read[n], m.write[n] = nil, nil, nil
+	if p == nil || flags&(PageRead|PageWrite) == 0 {
+		return
+	}
+	m.pages++
+	if flags&PageRead != 0 {
+		m.read[n] = p
+		if flags&PageM1 == 0 {
+			m.fetch[n] = p
+		}
+	}
+	if flags&PageWrite != 0 {
+		m.write[n] = p
+	}
+}
+
+// MapMemory maps mem into the address space at addr so that the CPU
+// accesses it directly instead of calling the Bus. addr must be a
+// multiple of PageSize and len(mem) a non-zero multiple of PageSize of at
+// most 64K; the mapping wraps at 0xFFFF. Accesses not allowed by flags,
+// accesses to unmapped pages, and all I/O go to the Bus as before.
+// Remapping a page replaces its previous mapping, so a bank switch is a
+// MapMemory call from the system's bank register handler.
+//
+// On the R800 mapped accesses are still charged their R800 cycles. The
+// memory map is not available on the eZ80, whose address space is 24
+// bits wide.
+func (c *CPU) MapMemory(addr uint16, mem []uint8, flags PageFlags) error {
+	if err := c.checkMap(addr, len(mem)); err != nil {
+		return err
+	}
+	c.InvalidateCode(addr, len(mem))
+	n := uint8(addr >> 8)
+	for off := 0; off < len(mem); off += PageSize {
+		c.mem.set(n, (*[PageSize]uint8)(mem[off:]), flags)
+		n++
+	}
+	c.setBus()
+	return nil
+}
+
+// UnmapMemory returns size bytes starting at addr to the Bus. addr and
+// size follow the same rules as MapMemory.
+func (c *CPU) UnmapMemory(addr uint16, size int) error {
+	if err := c.checkMap(addr, size); err != nil {
+		return err
+	}
+	c.InvalidateCode(addr, size)
+	n := uint8(addr >> 8)
+	for i := 0; i < size/PageSize; i++ {
+		c.mem.set(n, nil, 0)
+		n++
+	}
+	c.setBus()
+	return nil
+}
+
+// checkMap validates a MapMemory or UnmapMemory range.
+func (c *CPU) checkMap(addr uint16, size int) error {
+	if c.ez != nil {
+		return errors.New("z80: memory map not supported by this variant")
+	}
+	if addr%PageSize != 0 || size <= 0 || size%PageSize != 0 {
+		return errors.New("z80: memory map not page aligned")
+	}
+	if size > 0x10000 {
+		return errors.New("z80: memory map larger than address space")
+	}
+	return nil
+}
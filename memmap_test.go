@@ -0,0 +1,190 @@
+package z80
+
+import "testing"
+
+// countBus counts the accesses that reach the Bus.
+type countBus struct {
+	testBus
+	fetches, reads, writes int
+}
+
+func (b *countBus) Fetch(addr uint16) uint8 {
+	b.fetches++
+	return b.testBus.Fetch(addr)
+}
+
+func (b *countBus) Read(addr uint16) uint8 {
+	b.reads++
+	return b.testBus.Read(addr)
+}
+
+func (b *countBus) Write(addr uint16, val uint8) {
+	b.writes++
+	b.testBus.Write(addr, val)
+}
+
+func TestMapMemory_DirectAccess(t *testing.T) {
+	for _, tracking := range []bool{false, true} {
+		testMapMemoryDirect(t, tracking)
+	}
+}
+
+// testMapMemoryDirect runs code and data from mapped pages, with bus
+// tracking on or off.
+func testMapMemoryDirect(t *testing.T, tracking bool) {
+	bus := &countBus{}
+	cpu := New(bus)
+	cpu.SetBusTracking(tracking)
+	rom := make([]uint8, 1024)
+	ram := make([]uint8, 1024)
+	copy(rom, []uint8{0x3A, 0x00, 0x80, 0x32, 0x01, 0x80}) // LD A,(8000h); LD (8001h),A
+	ram[0] = 0x5A
+	if err := cpu.MapMemory(0x0000, rom, PageRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.MapMemory(0x8000, ram, PageRead|PageWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	cpu.Step()
+	cpu.Step()
+	if cpu.getA() != 0x5A || ram[1] != 0x5A {
+		t.Errorf("tracking=%v: A=%02x ram[1]=%02x, want 5A 5A", tracking, cpu.getA(), ram[1])
+	}
+	if bus.fetches+bus.reads+bus.writes != 0 {
+		t.Errorf("tracking=%v: bus saw %d fetches, %d reads, %d writes, want none",
+			tracking, bus.fetches, bus.reads, bus.writes)
+	}
+	if p := cpu.Pins(); tracking && (p.Addr != 0x8001 || p.Data != 0x5A || !p.WR) {
+		t.Errorf("tracking: pins %+v, want the write to 8001h", p)
+	}
+}
+
+func TestMapMemory_ReadOnlyWritesGoToBus(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	rom := make([]uint8, PageSize)
+	if err := cpu.MapMemory(0x4000, rom, PageRead); err != nil {
+		t.Fatal(err)
+	}
+	cpu.writeBus(0x4010, 0x77)
+	if rom[0x10] != 0 || bus.mem[0x4010] != 0x77 || bus.writes != 1 {
+		t.Errorf("rom=%02x bus=%02x writes=%d, want write on bus only",
+			rom[0x10], bus.mem[0x4010], bus.writes)
+	}
+}
+
+func TestMapMemory_M1GoesToFetch(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	rom := make([]uint8, PageSize)
+	copy(rom, []uint8{0x3E, 0x12}) // LD A,12h
+	copy(bus.mem[:], []uint8{0x3E, 0x12})
+	if err := cpu.MapMemory(0x0000, rom, PageRead|PageM1); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Step()
+	if bus.fetches != 1 || bus.reads != 0 {
+		t.Errorf("fetches=%d reads=%d, want 1 0", bus.fetches, bus.reads)
+	}
+	if cpu.getA() != 0x12 {
+		t.Errorf("A = %02x, want 12", cpu.getA())
+	}
+}
+
+func TestMapMemory_UnmapAndRemap(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	bank0 := make([]uint8, 0x4000)
+	bank1 := make([]uint8, 0x4000)
+	bank0[0] = 0xAA
+	bank1[0] = 0xBB
+	bus.mem[0x8000] = 0xCC
+
+	cpu.MapMemory(0x8000, bank0, PageRead)
+	if v := cpu.readBus(0x8000); v != 0xAA {
+		t.Errorf("bank0 read = %02x, want AA", v)
+	}
+	cpu.MapMemory(0x8000, bank1, PageRead)
+	if v := cpu.readBus(0x8000); v != 0xBB {
+		t.Errorf("bank1 read = %02x, want BB", v)
+	}
+	if err := cpu.UnmapMemory(0x8000, 0x4000); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.readBus(0x8000); v != 0xCC {
+		t.Errorf("unmapped read = %02x, want CC", v)
+	}
+}
+
+func TestMapMemory_WrapsAddressSpace(t *testing.T) {
+	cpu, _ := newTestCPU()
+	mem := make([]uint8, 2*PageSize)
+	if err := cpu.MapMemory(0xFF00, mem, PageRead|PageWrite); err != nil {
+		t.Fatal(err)
+	}
+	cpu.writeBus(0x0001, 0x42)
+	if mem[PageSize+1] != 0x42 {
+		t.Errorf("wrapped write missed the second page")
+	}
+}
+
+func TestMapMemory_Errors(t *testing.T) {
+	cpu, _ := newTestCPU()
+	if err := cpu.MapMemory(0x0010, make([]uint8, PageSize), PageRead); err == nil {
+		t.Error("unaligned address accepted")
+	}
+	if err := cpu.MapMemory(0x0000, make([]uint8, 100), PageRead); err == nil {
+		t.Error("partial page accepted")
+	}
+	if err := cpu.UnmapMemory(0x0000, 100); err == nil {
+		t.Error("partial unmap accepted")
+	}
+	ez := New(&testBus{}, WithVariant(VariantEZ80))
+	if err := ez.MapMemory(0x0000, make([]uint8, PageSize), PageRead); err == nil {
+		t.Error("eZ80 accepted a memory map")
+	}
+}
+
+func TestMapMemory_R800KeepsTiming(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus, WithVariant(VariantR800))
+	mem := make([]uint8, 0x10000)
+	copy(mem, []uint8{0x3A, 0x00, 0x80}) // LD A,(8000h)
+	mem[0x8000] = 0x99
+	if err := cpu.MapMemory(0x0000, mem, PageRead|PageWrite); err != nil {
+		t.Fatal(err)
+	}
+	// Opcode and two operands, one read, and page breaks on the first
+	// fetch and the read.
+	if cycles := cpu.Step(); cycles != 6 {
+		t.Errorf("cycles = %d, want 6", cycles)
+	}
+	if cpu.getA() != 0x99 || bus.reads != 0 {
+		t.Errorf("A=%02x reads=%d, want 99 0", cpu.getA(), bus.reads)
+	}
+}
+
+func TestMapMemory_Tick(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	mem := make([]uint8, 0x10000)
+	copy(mem, []uint8{0x32, 0x00, 0x40}) // LD (4000h),A
+	if err := cpu.MapMemory(0x0000, mem, PageRead|PageWrite); err != nil {
+		t.Fatal(err)
+	}
+	cpu.setA(0x5A)
+	for i := 1; i <= 12; i++ {
+		cpu.Tick()
+		if mem[0x4000] != 0 {
+			t.Fatalf("T%d: mapped write done before T3 of the write cycle", i)
+		}
+	}
+	if !cpu.Tick() || mem[0x4000] != 0x5A {
+		t.Errorf("write not done after 13 T-states: %02x", mem[0x4000])
+	}
+	if bus.fetches+bus.reads+bus.writes != 0 {
+		t.Errorf("bus saw %d fetches, %d reads, %d writes, want none",
+			bus.fetches, bus.reads, bus.writes)
+	}
+}
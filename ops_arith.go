@@ -123,6 +123,7 @@ func init() {
 			f |= uint8(r16>>8) & (flagF5 | flagF3)
 			c.setF(f)
 			*c.ixiyReg = r16
+			c.reg.WZ = hl + 1
 			c.cycles += 11
 		}
 	}
@@ -17,8 +17,8 @@ func init() {
 				if bit == 7 && val&0x80 != 0 {
 					f |= flagS
 				}
-				// F3/F5 from high byte of address for (HL) variant
-				f |= uint8(c.reg.HL>>8) & (flagF3 | flagF5)
+				// F3/F5 from the high byte of WZ for the (HL) variant
+				f |= uint8(c.reg.WZ>>8) & (flagF3 | flagF5)
 				c.setF(f)
 				c.cycles += 12
 			}
@@ -148,7 +148,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x6582, BC_: 0x27EF,
 				DE_: 0xA172, HL_: 0x92D5,
 				IM: 0, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{61117, 203}, {61118, 64}},
+				RAM:    [][2]uint16{{61117, 203}, {61118, 64}},
 				Cycles: 8,
 			},
 		},
@@ -160,6 +160,7 @@ func TestSST_Bit(t *testing.T) {
 				D: 0xAC, E: 0x0B,
 				H: 0xE7, L: 0x03,
 				I: 0x83, R: 0x51,
+				WZ: 0xE700, // F3/F5 bits as in the vector's WZ
 				PC: 0x9C60, SP: 0x2295,
 				IX: 0x7B83, IY: 0xA880,
 				AF_: 0xC486, BC_: 0x8CD4,
@@ -178,7 +179,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xC486, BC_: 0x8CD4,
 				DE_: 0xBFA5, HL_: 0x3F9B,
 				IM: 1, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{40032, 203}, {40033, 70}, {59139, 16}},
+				RAM:    [][2]uint16{{40032, 203}, {40033, 70}, {59139, 16}},
 				Cycles: 12,
 			},
 		},
@@ -208,7 +209,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xABD0, BC_: 0x2DD1,
 				DE_: 0x7466, HL_: 0x6FD4,
 				IM: 0, IFF1: false, IFF2: false,
-				RAM: [][2]uint16{{5533, 203}, {5534, 127}},
+				RAM:    [][2]uint16{{5533, 203}, {5534, 127}},
 				Cycles: 8,
 			},
 		},
@@ -220,6 +221,7 @@ func TestSST_Bit(t *testing.T) {
 				D: 0x74, E: 0xB0,
 				H: 0x8A, L: 0x3F,
 				I: 0x20, R: 0x48,
+				WZ: 0x8A00, // F3/F5 bits as in the vector's WZ
 				PC: 0x3B9B, SP: 0x6F3B,
 				IX: 0xA25D, IY: 0x9E08,
 				AF_: 0xED6D, BC_: 0xF48A,
@@ -238,7 +240,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xED6D, BC_: 0xF48A,
 				DE_: 0x6778, HL_: 0x4E2C,
 				IM: 0, IFF1: false, IFF2: false,
-				RAM: [][2]uint16{{15259, 203}, {15260, 126}, {35391, 86}},
+				RAM:    [][2]uint16{{15259, 203}, {15260, 126}, {35391, 86}},
 				Cycles: 12,
 			},
 		},
@@ -268,7 +270,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x5191, BC_: 0x7BF3,
 				DE_: 0x73D0, HL_: 0xE146,
 				IM: 0, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{42490, 203}, {42491, 80}},
+				RAM:    [][2]uint16{{42490, 203}, {42491, 80}},
 				Cycles: 8,
 			},
 		},
@@ -298,7 +300,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xB286, BC_: 0x1B4B,
 				DE_: 0x5937, HL_: 0x0976,
 				IM: 0, IFF1: true, IFF2: false,
-				RAM: [][2]uint16{{57728, 203}, {57729, 104}},
+				RAM:    [][2]uint16{{57728, 203}, {57729, 104}},
 				Cycles: 8,
 			},
 		},
@@ -328,7 +330,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x2447, BC_: 0xD00A,
 				DE_: 0x05CF, HL_: 0x3F80,
 				IM: 1, IFF1: true, IFF2: false,
-				RAM: [][2]uint16{{44131, 203}, {44132, 128}},
+				RAM:    [][2]uint16{{44131, 203}, {44132, 128}},
 				Cycles: 8,
 			},
 		},
@@ -358,7 +360,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x875C, BC_: 0xB2CE,
 				DE_: 0xB24E, HL_: 0xA5DE,
 				IM: 0, IFF1: false, IFF2: false,
-				RAM: [][2]uint16{{33116, 203}, {33117, 134}, {62318, 40}},
+				RAM:    [][2]uint16{{33116, 203}, {33117, 134}, {62318, 40}},
 				Cycles: 15,
 			},
 		},
@@ -388,7 +390,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xD8AA, BC_: 0x2FD0,
 				DE_: 0xA0E4, HL_: 0xCA14,
 				IM: 0, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{51777, 203}, {51778, 184}},
+				RAM:    [][2]uint16{{51777, 203}, {51778, 184}},
 				Cycles: 8,
 			},
 		},
@@ -418,7 +420,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xBF34, BC_: 0x4B4D,
 				DE_: 0xCC51, HL_: 0x51D0,
 				IM: 2, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{13725, 111}, {36379, 203}, {36380, 190}},
+				RAM:    [][2]uint16{{13725, 111}, {36379, 203}, {36380, 190}},
 				Cycles: 15,
 			},
 		},
@@ -448,7 +450,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x7DF6, BC_: 0xAFB2,
 				DE_: 0x05F8, HL_: 0xEE51,
 				IM: 2, IFF1: true, IFF2: false,
-				RAM: [][2]uint16{{38157, 203}, {38158, 192}},
+				RAM:    [][2]uint16{{38157, 203}, {38158, 192}},
 				Cycles: 8,
 			},
 		},
@@ -478,7 +480,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xFF23, BC_: 0x1EE8,
 				DE_: 0x430B, HL_: 0xC871,
 				IM: 1, IFF1: true, IFF2: false,
-				RAM: [][2]uint16{{17742, 203}, {17743, 198}, {41656, 179}},
+				RAM:    [][2]uint16{{17742, 203}, {17743, 198}, {41656, 179}},
 				Cycles: 15,
 			},
 		},
@@ -508,7 +510,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xEB82, BC_: 0x55DF,
 				DE_: 0x1510, HL_: 0x6DCB,
 				IM: 0, IFF1: false, IFF2: true,
-				RAM: [][2]uint16{{2624, 203}, {2625, 248}},
+				RAM:    [][2]uint16{{2624, 203}, {2625, 248}},
 				Cycles: 8,
 			},
 		},
@@ -538,7 +540,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0xE2CE, BC_: 0x024E,
 				DE_: 0x8F12, HL_: 0x8AD2,
 				IM: 2, IFF1: true, IFF2: true,
-				RAM: [][2]uint16{{23143, 199}, {25814, 203}, {25815, 254}},
+				RAM:    [][2]uint16{{23143, 199}, {25814, 203}, {25815, 254}},
 				Cycles: 15,
 			},
 		},
@@ -568,7 +570,7 @@ func TestSST_Bit(t *testing.T) {
 				AF_: 0x21CF, BC_: 0x05CD,
 				DE_: 0x500C, HL_: 0x5D33,
 				IM: 2, IFF1: true, IFF2: false,
-				RAM: [][2]uint16{{8646, 203}, {8647, 199}},
+				RAM:    [][2]uint16{{8646, 203}, {8647, 199}},
 				Cycles: 8,
 			},
 		},
@@ -75,10 +75,12 @@ func (c *CPU) blockLD(dir int) {
 }
 
 // blockRepeat handles the repeat-or-finish logic for block instructions.
-// If repeat is true, PC is rewound and extra cycles are charged.
+// If repeat is true, PC is rewound, WZ is set to PC+1 and extra cycles
+// are charged.
 func (c *CPU) blockRepeat(repeat bool) {
 	if repeat {
 		c.reg.PC -= 2
+		c.reg.WZ = c.reg.PC + 1
 		c.blockRepeatF35()
 		c.cycles += 21
 	} else {
@@ -86,10 +88,10 @@ func (c *CPU) blockRepeat(repeat bool) {
 	}
 }
 
-// blockRepeatF35 replaces F3/F5 with high byte of PC+1 (WZ) for repeat block ops.
-// Must be called after PC -= 2.
+// blockRepeatF35 replaces F3/F5 with the high byte of WZ for repeat block ops.
+// Must be called after WZ is set to PC+1.
 func (c *CPU) blockRepeatF35() {
-	wzHi := uint8((c.reg.PC + 1) >> 8)
+	wzHi := uint8(c.reg.WZ >> 8)
 	f := c.getF() &^ (flagF3 | flagF5)
 	f |= wzHi & (flagF3 | flagF5)
 	c.setF(f)
@@ -105,6 +107,7 @@ func (c *CPU) blockCP(dir int) {
 	} else {
 		c.reg.HL--
 	}
+	c.reg.WZ = uint16(int(c.reg.WZ) + dir)
 	c.reg.BC--
 	f := szFlags(result) | flagN | (c.getF() & flagC)
 	if (a^val^result)&0x10 != 0 {
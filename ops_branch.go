@@ -4,6 +4,7 @@ func init() {
 	// --- JP nn ---
 	baseOps[0xC3] = func(c *CPU, _ uint8) {
 		c.reg.PC = c.fetchPC16()
+		c.reg.WZ = c.reg.PC
 		c.cycles += 10
 	}
 
@@ -16,6 +17,7 @@ func init() {
 			if c.testCC((op >> 3) & 7) {
 				c.reg.PC = addr
 			}
+			c.reg.WZ = addr
 			c.cycles += 10
 		}
 	}
@@ -24,6 +26,7 @@ func init() {
 	baseOps[0x18] = func(c *CPU, _ uint8) {
 		e := int8(c.fetchPC())
 		c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+		c.reg.WZ = c.reg.PC
 		c.cycles += 12
 	}
 
@@ -32,6 +35,7 @@ func init() {
 		e := int8(c.fetchPC())
 		if c.getF()&flagZ == 0 {
 			c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+			c.reg.WZ = c.reg.PC
 			c.cycles += 12
 		} else {
 			c.cycles += 7
@@ -43,6 +47,7 @@ func init() {
 		e := int8(c.fetchPC())
 		if c.getF()&flagZ != 0 {
 			c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+			c.reg.WZ = c.reg.PC
 			c.cycles += 12
 		} else {
 			c.cycles += 7
@@ -54,6 +59,7 @@ func init() {
 		e := int8(c.fetchPC())
 		if c.getF()&flagC == 0 {
 			c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+			c.reg.WZ = c.reg.PC
 			c.cycles += 12
 		} else {
 			c.cycles += 7
@@ -65,6 +71,7 @@ func init() {
 		e := int8(c.fetchPC())
 		if c.getF()&flagC != 0 {
 			c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+			c.reg.WZ = c.reg.PC
 			c.cycles += 12
 		} else {
 			c.cycles += 7
@@ -84,6 +91,7 @@ func init() {
 		c.setB(b)
 		if b != 0 {
 			c.reg.PC = uint16(int32(c.reg.PC) + int32(e))
+			c.reg.WZ = c.reg.PC
 			c.cycles += 13
 		} else {
 			c.cycles += 8
@@ -95,6 +103,7 @@ func init() {
 		addr := c.fetchPC16()
 		c.push16(c.reg.PC)
 		c.reg.PC = addr
+		c.reg.WZ = addr
 		c.cycles += 17
 	}
 
@@ -104,6 +113,7 @@ func init() {
 		op := i<<3 | 0xC4
 		baseOps[op] = func(c *CPU, op uint8) {
 			addr := c.fetchPC16()
+			c.reg.WZ = addr
 			if c.testCC((op >> 3) & 7) {
 				c.push16(c.reg.PC)
 				c.reg.PC = addr
@@ -117,6 +127,7 @@ func init() {
 	// --- RET ---
 	baseOps[0xC9] = func(c *CPU, _ uint8) {
 		c.reg.PC = c.pop16()
+		c.reg.WZ = c.reg.PC
 		c.cycles += 10
 	}
 
@@ -127,6 +138,7 @@ func init() {
 		baseOps[op] = func(c *CPU, op uint8) {
 			if c.testCC((op >> 3) & 7) {
 				c.reg.PC = c.pop16()
+				c.reg.WZ = c.reg.PC
 				c.cycles += 11
 			} else {
 				c.cycles += 5
@@ -141,6 +153,7 @@ func init() {
 		baseOps[op] = func(c *CPU, op uint8) {
 			c.push16(c.reg.PC)
 			c.reg.PC = uint16(op & 0x38)
+			c.reg.WZ = c.reg.PC
 			c.cycles += 11
 		}
 	}
@@ -15,6 +15,7 @@ func init() {
 	// --- RETI / RETN (identical behavior in emulation) ---
 	retnHandler := opFunc(func(c *CPU, _ uint8) {
 		c.reg.PC = c.pop16()
+		c.reg.WZ = c.reg.PC
 		c.reg.IFF1 = c.reg.IFF2
 		c.cycles += 14
 	})
@@ -54,6 +55,7 @@ func init() {
 			addr := c.fetchPC16()
 			rr := c.getRR((op >> 4) & 3)
 			c.write16(addr, *rr)
+			c.reg.WZ = addr + 1
 			c.cycles += 20
 		}
 	}
@@ -66,6 +68,7 @@ func init() {
 			addr := c.fetchPC16()
 			rr := c.getRR((op >> 4) & 3)
 			*rr = c.read16(addr)
+			c.reg.WZ = addr + 1
 			c.cycles += 20
 		}
 	}
@@ -111,6 +114,7 @@ func init() {
 			}
 			c.setF(f)
 			c.reg.HL = r16
+			c.reg.WZ = hl + 1
 			c.cycles += 15
 		}
 	}
@@ -145,6 +149,7 @@ func init() {
 			}
 			c.setF(f)
 			c.reg.HL = r16
+			c.reg.WZ = hl + 1
 			c.cycles += 15
 		}
 	}
@@ -158,6 +163,7 @@ func init() {
 		newVal := (val << 4) | (a & 0x0F)
 		newA := (a & 0xF0) | (val >> 4)
 		c.writeBus(c.reg.HL, newVal)
+		c.reg.WZ = c.reg.HL + 1
 		c.setA(newA)
 		f := szFlags(newA) | parityTable[newA] | (c.getF() & flagC)
 		c.setF(f)
@@ -175,6 +181,7 @@ func init() {
 		newVal := (a << 4) | (val >> 4)
 		newA := (a & 0xF0) | (val & 0x0F)
 		c.writeBus(c.reg.HL, newVal)
+		c.reg.WZ = c.reg.HL + 1
 		c.setA(newA)
 		f := szFlags(newA) | parityTable[newA] | (c.getF() & flagC)
 		c.setF(f)
@@ -189,6 +196,7 @@ func init() {
 			// IN (C) - undocumented: reads port, sets flags, discards result
 			edOps[op] = func(c *CPU, _ uint8) {
 				val := c.inBus(c.reg.BC)
+				c.reg.WZ = c.reg.BC + 1
 				f := szFlags(val) | parityTable[val] | (c.getF() & flagC)
 				c.setF(f)
 				c.cycles += 12
@@ -197,6 +205,7 @@ func init() {
 			edOps[op] = func(c *CPU, op uint8) {
 				r := (op >> 3) & 7
 				val := c.inBus(c.reg.BC)
+				c.reg.WZ = c.reg.BC + 1
 				c.setR8(r, val)
 				f := szFlags(val) | parityTable[val] | (c.getF() & flagC)
 				c.setF(f)
@@ -213,12 +222,14 @@ func init() {
 			// OUT (C), 0 - undocumented
 			edOps[op] = func(c *CPU, _ uint8) {
 				c.outBus(c.reg.BC, 0)
+				c.reg.WZ = c.reg.BC + 1
 				c.cycles += 12
 			}
 		} else {
 			edOps[op] = func(c *CPU, op uint8) {
 				r := (op >> 3) & 7
 				c.outBus(c.reg.BC, c.getR8(r))
+				c.reg.WZ = c.reg.BC + 1
 				c.cycles += 12
 			}
 		}
@@ -226,6 +237,8 @@ func init() {
 }
 
 // ldAIR implements LD A,I and LD A,R: load val into A, set flags.
+// It also marks the instruction so that serviceINT can reproduce the
+// NMOS quirk where an interrupt accepted right after it clears P/V.
 func (c *CPU) ldAIR(val uint8) {
 	c.setA(val)
 	f := szFlags(val)
@@ -234,6 +247,7 @@ func (c *CPU) ldAIR(val uint8) {
 	}
 	f |= c.getF() & flagC
 	c.setF(f)
+	c.afterLDAIR = c.variant == VariantZ80 // NMOS quirk, absent on the R800
 	c.cycles += 9
 }
 
@@ -107,6 +107,95 @@ func TestLD_R_A(t *testing.T) {
 	}
 }
 
+func TestLD_A_I_InterruptClearsPV(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0x57
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.I = 0x80
+	cpu.reg.IFF1 = true
+	cpu.reg.IFF2 = true
+	cpu.reg.IM = 1
+	cpu.Step()
+	if cpu.getF()&flagPV == 0 {
+		t.Fatal("PV should reflect IFF2 before the interrupt")
+	}
+
+	cpu.INT(true, 0xFF)
+	cpu.Step()
+	if cpu.reg.PC != 0x0038 {
+		t.Fatalf("PC=%04x want 0038 (interrupt not accepted)", cpu.reg.PC)
+	}
+	if cpu.getF()&flagPV != 0 {
+		t.Error("PV should read 0 when INT is accepted right after LD A,I")
+	}
+	if cpu.getF()&flagS == 0 {
+		t.Error("other flags from LD A,I should be preserved")
+	}
+}
+
+func TestLD_A_R_InterruptClearsPV(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0x5F
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.IFF1 = true
+	cpu.reg.IFF2 = true
+	cpu.reg.IM = 1
+	cpu.INT(true, 0xFF)
+	cpu.afterEI = true // hold INT off so LD A,R runs first
+	cpu.Step()
+	cpu.Step()
+	if cpu.reg.PC != 0x0038 {
+		t.Fatalf("PC=%04x want 0038 (interrupt not accepted)", cpu.reg.PC)
+	}
+	if cpu.getF()&flagPV != 0 {
+		t.Error("PV should read 0 when INT is accepted right after LD A,R")
+	}
+}
+
+func TestLD_A_I_PVKeptWithoutInterrupt(t *testing.T) {
+	cpu, bus := newTestCPU()
+	// LD A,I then NOP; the NOP separates the interrupt from LD A,I.
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0x57
+	bus.mem[2] = 0x00
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.IFF1 = true
+	cpu.reg.IFF2 = true
+	cpu.reg.IM = 1
+	cpu.Step()
+	cpu.Step()
+
+	cpu.INT(true, 0xFF)
+	cpu.Step()
+	if cpu.reg.PC != 0x0038 {
+		t.Fatalf("PC=%04x want 0038 (interrupt not accepted)", cpu.reg.PC)
+	}
+	if cpu.getF()&flagPV == 0 {
+		t.Error("PV should be preserved when INT follows a later instruction")
+	}
+}
+
+func TestLD_A_I_NMIKeepsPV(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0x57
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.IFF1 = true
+	cpu.reg.IFF2 = true
+	cpu.Step()
+
+	cpu.NMI()
+	cpu.Step()
+	if cpu.reg.PC != 0x0066 {
+		t.Fatalf("PC=%04x want 0066", cpu.reg.PC)
+	}
+	if cpu.getF()&flagPV == 0 {
+		t.Error("NMI should not clear PV after LD A,I")
+	}
+}
+
 func TestED_LD_nn_rr(t *testing.T) {
 	cpu, bus := newTestCPU()
 	// ED 43 = LD (nn), BC
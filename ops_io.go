@@ -5,6 +5,7 @@ func init() {
 	baseOps[0xDB] = func(c *CPU, _ uint8) {
 		port := uint16(c.fetchPC()) | uint16(c.getA())<<8
 		c.setA(c.inBus(port))
+		c.reg.WZ = port + 1
 		c.cycles += 11
 	}
 
@@ -12,6 +13,7 @@ func init() {
 	baseOps[0xD3] = func(c *CPU, _ uint8) {
 		port := uint16(c.fetchPC()) | uint16(c.getA())<<8
 		c.outBus(port, c.getA())
+		c.reg.WZ = port&0xFF00 | (port+1)&0xFF
 		c.cycles += 11
 	}
 
@@ -77,6 +79,7 @@ func (c *CPU) blockIORepeat(repeat bool) {
 // blockIN performs the core of INI/IND/INIR/INDR.
 func (c *CPU) blockIN(dir int) {
 	val := c.inBus(c.reg.BC)
+	c.reg.WZ = uint16(int(c.reg.BC) + dir)
 	c.writeBus(c.reg.HL, val)
 	b := c.getB() - 1
 	c.setB(b)
@@ -104,6 +107,7 @@ func (c *CPU) blockOUT(dir int) {
 	b := c.getB() - 1
 	c.setB(b)
 	c.outBus(c.reg.BC, val)
+	c.reg.WZ = uint16(int(c.reg.BC) + dir)
 	if dir > 0 {
 		c.reg.HL++
 	} else {
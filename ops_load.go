@@ -51,33 +51,39 @@ func init() {
 	// --- LD A, (BC) ---
 	baseOps[0x0A] = func(c *CPU, _ uint8) {
 		c.setA(c.readBus(c.reg.BC))
+		c.reg.WZ = c.reg.BC + 1
 		c.cycles += 7
 	}
 	// --- LD A, (DE) ---
 	baseOps[0x1A] = func(c *CPU, _ uint8) {
 		c.setA(c.readBus(c.reg.DE))
+		c.reg.WZ = c.reg.DE + 1
 		c.cycles += 7
 	}
 	// --- LD (BC), A ---
 	baseOps[0x02] = func(c *CPU, _ uint8) {
 		c.writeBus(c.reg.BC, c.getA())
+		c.reg.WZ = uint16(c.getA())<<8 | (c.reg.BC+1)&0xFF
 		c.cycles += 7
 	}
 	// --- LD (DE), A ---
 	baseOps[0x12] = func(c *CPU, _ uint8) {
 		c.writeBus(c.reg.DE, c.getA())
+		c.reg.WZ = uint16(c.getA())<<8 | (c.reg.DE+1)&0xFF
 		c.cycles += 7
 	}
 	// --- LD A, (nn) ---
 	baseOps[0x3A] = func(c *CPU, _ uint8) {
 		addr := c.fetchPC16()
 		c.setA(c.readBus(addr))
+		c.reg.WZ = addr + 1
 		c.cycles += 13
 	}
 	// --- LD (nn), A ---
 	baseOps[0x32] = func(c *CPU, _ uint8) {
 		addr := c.fetchPC16()
 		c.writeBus(addr, c.getA())
+		c.reg.WZ = uint16(c.getA())<<8 | (addr+1)&0xFF
 		c.cycles += 13
 	}
 
@@ -96,12 +102,14 @@ func init() {
 	baseOps[0x22] = func(c *CPU, _ uint8) {
 		addr := c.fetchPC16()
 		c.write16(addr, *c.ixiyReg)
+		c.reg.WZ = addr + 1
 		c.cycles += 16
 	}
 	// --- LD HL, (nn) ---
 	baseOps[0x2A] = func(c *CPU, _ uint8) {
 		addr := c.fetchPC16()
 		*c.ixiyReg = c.read16(addr)
+		c.reg.WZ = addr + 1
 		c.cycles += 16
 	}
 
@@ -158,9 +166,11 @@ func init() {
 		lo := uint16(c.readBus(c.reg.SP))
 		hi := uint16(c.readBus(c.reg.SP + 1))
 		val := hi<<8 | lo
-		c.writeBus(c.reg.SP, uint8(*c.ixiyReg))
+		// The high byte is written first, as on hardware.
 		c.writeBus(c.reg.SP+1, uint8(*c.ixiyReg>>8))
+		c.writeBus(c.reg.SP, uint8(*c.ixiyReg))
 		*c.ixiyReg = val
+		c.reg.WZ = val
 		c.cycles += 19
 	}
 
@@ -1267,6 +1267,37 @@ func TestSST_Load(t *testing.T) {
 				Cycles: 4,
 			},
 		},
+		{ // 76 0000
+			name: "76 0000",
+			init: z80State{
+				A: 0x1F, F: 0x6B,
+				B: 0x25, C: 0x0D,
+				D: 0xFC, E: 0x27,
+				H: 0xD3, L: 0x33,
+				I: 0x24, R: 0x5F,
+				PC: 0x96B9, SP: 0xFBA3,
+				IX: 0xB2A7, IY: 0x66AB,
+				AF_: 0xCDD2, BC_: 0x9519,
+				DE_: 0xE235, HL_: 0x9A54,
+				IM: 0, IFF1: true, IFF2: true,
+				RAM: [][2]uint16{{38585, 118}},
+			},
+			want: z80State{
+				A: 0x1F, F: 0x6B,
+				B: 0x25, C: 0x0D,
+				D: 0xFC, E: 0x27,
+				H: 0xD3, L: 0x33,
+				I: 0x24, R: 0x60,
+				PC: 0x96BA, SP: 0xFBA3,
+				IX: 0xB2A7, IY: 0x66AB,
+				AF_: 0xCDD2, BC_: 0x9519,
+				DE_: 0xE235, HL_: 0x9A54,
+				IM: 0, IFF1: true, IFF2: true,
+				Halted: true,
+				RAM:    [][2]uint16{{38585, 118}},
+				Cycles: 4,
+			},
+		},
 	}
 	for _, tt := range tests {
 		runSSTTest(t, tt)
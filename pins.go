@@ -0,0 +1,139 @@
+package z80
+
+// Pins is a snapshot of the CPU's control, address and data pins. A
+// true control field means the signal is asserted; on the chip these
+// pins are active low.
+type Pins struct {
+	M1     bool
+	MREQ   bool
+	IORQ   bool
+	RD     bool
+	WR     bool
+	RFSH   bool
+	HALT   bool
+	BUSACK bool
+	Addr   uint16
+	Data   uint8
+}
+
+// Pins returns the pin states as of the last bus cycle.
+//
+// After Tick they are the pins during the last T-state run, derived from
+// the machine cycle in progress at T-state resolution: a signal that
+// changes on a clock's falling edge is shown for the whole T-state.
+// During the refresh half of an M1 cycle the address bus holds I and R;
+// during internal cycles it keeps its last value.
+//
+// After Step and the other instruction-level calls, while bus tracking
+// is on (see SetBusTracking), they are the pins while the data of the
+// last bus cycle was transferred: its control signals, its address and
+// the byte read or written. A HALT or interrupt acknowledge cycle counts
+// as a bus cycle. With tracking off nothing is recorded.
+//
+// HALT is always current. BUSACK is always false as bus requests are not
+// modeled; AddCycles accounts for them instead.
+func (c *CPU) Pins() Pins {
+	var p Pins
+	if t := c.tick; t != nil && (t.active || t.base+uint64(t.elapsed) == c.cycles) {
+		p = t.pins
+	} else {
+		p = c.lastBus.pins()
+	}
+	p.HALT = c.reg.Halted
+	return p
+}
+
+// busAccess is a bus cycle as recorded by the CPU's access helpers.
+type busAccess struct {
+	typ  MCycleType
+	addr uint16
+	data uint8
+}
+
+// pins returns the pins while the data of the cycle is transferred.
+func (a busAccess) pins() Pins {
+	p := Pins{Addr: a.addr, Data: a.data}
+	switch a.typ {
+	case MCycleM1:
+		p.M1, p.MREQ, p.RD = true, true, true
+	case MCycleMR:
+		p.MREQ, p.RD = true, true
+	case MCycleMW:
+		p.MREQ, p.WR = true, true
+	case MCycleIOR:
+		p.IORQ, p.RD = true, true
+	case MCycleIOW:
+		p.IORQ, p.WR = true, true
+	case MCycleINTA:
+		p.M1, p.IORQ = true, true
+	}
+	return p
+}
+
+// SetPinHook sets a function called after every T-state run by Tick
+// with the absolute cycle the T-state started at and the pins during it,
+// as returned by Pins. It is meant for logic-analyzer style traces such
+// as VCD files. A nil fn removes the hook.
+func (c *CPU) SetPinHook(fn func(cycle uint64, p Pins)) {
+	if c.tick == nil {
+		c.newTicker()
+	}
+	c.tick.pinHook = fn
+}
+
+// updatePins derives the pins for the T-state just run and calls the
+// hook.
+func (t *ticker) updatePins() {
+	m := &t.cur
+	p := &t.pins
+	p.M1, p.MREQ, p.IORQ, p.RD, p.WR, p.RFSH = false, false, false, false, false, false
+	// RD/WR of write and I/O cycles go active from T2; one-cycle R800
+	// accesses show them at once.
+	late := m.T >= 2 || m.Len < 2
+	switch m.Type {
+	case MCycleM1:
+		if m.T < 3 || m.Len < 3 {
+			p.M1, p.MREQ, p.RD = true, true, true
+			p.Addr = m.Addr
+		} else {
+			// Refresh: MREQ and RFSH with I and R on the address bus.
+			p.Data = m.Data
+			if t.c.refresh != 0 {
+				p.MREQ, p.RFSH = true, true
+				p.Addr = t.rfsh
+			}
+		}
+	case MCycleMR:
+		p.MREQ, p.RD = true, true
+		p.Addr = m.Addr
+		if m.Access() {
+			p.Data = m.Data
+		}
+	case MCycleMW:
+		p.MREQ = true
+		p.WR = late
+		p.Addr, p.Data = m.Addr, m.Data
+	case MCycleIOR:
+		p.IORQ, p.RD = late, late
+		p.Addr = m.Addr
+		if m.Access() {
+			p.Data = m.Data
+		}
+	case MCycleIOW:
+		p.IORQ, p.WR = late, late
+		p.Addr, p.Data = m.Addr, m.Data
+	case MCycleINTA:
+		// M1 with IORQ instead of MREQ, after the automatic wait states.
+		p.M1 = true
+		p.IORQ = m.T >= 3
+		p.Addr = m.Addr
+		if m.Access() {
+			p.Data = m.Data
+		}
+	}
+	if t.pinHook != nil {
+		q := *p
+		q.HALT = t.c.reg.Halted
+		t.pinHook(t.base+uint64(t.elapsed)-1, q)
+	}
+}
@@ -0,0 +1,111 @@
+package z80
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pinString renders the asserted control pins, as "M1 MREQ RD".
+func pinString(p Pins) string {
+	var s []string
+	for _, f := range []struct {
+		on   bool
+		name string
+	}{
+		{p.M1, "M1"}, {p.MREQ, "MREQ"}, {p.IORQ, "IORQ"}, {p.RD, "RD"},
+		{p.WR, "WR"}, {p.RFSH, "RFSH"}, {p.HALT, "HALT"},
+	} {
+		if f.on {
+			s = append(s, f.name)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestPins_MemoryCycles(t *testing.T) {
+	cpu, bus := newTestCPU()
+	copy(bus.mem[:], []uint8{0x32, 0x00, 0x40}) // LD (4000h),A
+	cpu.setA(0x5A)
+	cpu.reg.I = 0x12
+	cpu.reg.R = 0x05
+	want := []string{
+		"0000 M1 MREQ RD", "0000 M1 MREQ RD", "1205 MREQ RFSH", "1205 MREQ RFSH",
+		"0001 MREQ RD", "0001 MREQ RD", "0001 MREQ RD",
+		"0002 MREQ RD", "0002 MREQ RD", "0002 MREQ RD",
+		"4000 MREQ", "4000 MREQ WR", "4000 MREQ WR",
+	}
+	var got []string
+	var cycles []uint64
+	cpu.SetPinHook(func(cycle uint64, p Pins) {
+		got = append(got, fmt.Sprintf("%04X %s", p.Addr, pinString(p)))
+		cycles = append(cycles, cycle)
+	})
+	for !cpu.Tick() {
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("pins:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(cycles) != 13 || cycles[0] != 0 || cycles[12] != 12 {
+		t.Errorf("hook cycles %v, want 0..12", cycles)
+	}
+	if p := cpu.Pins(); p.Data != 0x5A || p.Addr != 0x4000 {
+		t.Errorf("last pins %+v, want 4000 5A", p)
+	}
+}
+
+func TestPins_IOAndHalt(t *testing.T) {
+	cpu, bus := newTestCPU()
+	copy(bus.mem[:], []uint8{0xDB, 0x20, 0x76}) // IN A,(20h); HALT
+	cpu.setA(0x10)
+	var io []string
+	cpu.SetPinHook(func(_ uint64, p Pins) {
+		if p.IORQ || p.Addr == 0x1020 {
+			io = append(io, pinString(p))
+		}
+	})
+	for !cpu.Tick() {
+	}
+	if got := strings.Join(io, ", "); got != ", IORQ RD, IORQ RD, IORQ RD" {
+		t.Errorf("I/O cycle pins %q", got)
+	}
+	if cpu.Pins().Data != 0xFF {
+		t.Errorf("data bus = %02X, want FF", cpu.Pins().Data)
+	}
+	for !cpu.Tick() {
+	}
+	if !cpu.Pins().HALT {
+		t.Error("HALT not asserted after HALT")
+	}
+}
+
+func TestPins_Step(t *testing.T) {
+	cpu, bus := newTestCPU()
+	// LD (4000h),A; IN A,(20h); OUT (21h),A; NOP; HALT
+	copy(bus.mem[:], []uint8{0x32, 0x00, 0x40, 0xDB, 0x20, 0xD3, 0x21, 0x00, 0x76})
+	cpu.setA(0x5A)
+	cpu.SetBusTracking(true)
+	for _, want := range []string{
+		"4000 5A MREQ WR",
+		"5A20 FF IORQ RD",
+		"FF21 FF IORQ WR",
+		"0007 00 M1 MREQ RD",
+		"0008 76 M1 MREQ RD HALT",
+		"0009 76 M1 MREQ RD HALT", // HALT cycle
+	} {
+		cpu.Step()
+		p := cpu.Pins()
+		if got := fmt.Sprintf("%04X %02X %s", p.Addr, p.Data, pinString(p)); got != want {
+			t.Errorf("pins %q, want %q", got, want)
+		}
+	}
+
+	// After Tick finishes an instruction, Pins shows its last T-state.
+	cpu.Reset()
+	bus.mem[0] = 0x00
+	for !cpu.Tick() {
+	}
+	if p := cpu.Pins(); !p.RFSH {
+		t.Errorf("pins after NOP under Tick %+v, want refresh", p)
+	}
+}
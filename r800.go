@@ -0,0 +1,164 @@
+package z80
+
+import "sync"
+
+// R800 dispatch tables. r800Ops is baseOps with the ED prefix routed to
+// r800EdOps, which adds the MULUB and MULUW multiply instructions.
+var (
+	r800Ops   [256]opFunc
+	r800EdOps [256]opFunc
+	r800Once  sync.Once
+)
+
+// Internal cycles taken by the R800 multiplier, beyond the two opcode
+// fetches of the instruction.
+const (
+	r800MulubCycles = 12
+	r800MuluwCycles = 34
+)
+
+// Internal cycles of other R800 instructions, beyond their bus accesses.
+const (
+	r800JumpCycles = 1 // taken JR and DJNZ, to add the displacement
+	r800PushCycles = 1 // PUSH, to decrement SP before the first write
+	r800ExSPCycles = 2 // EX (SP),HL, between the reads and the writes
+	r800IncCycles  = 1 // INC/DEC (HL), between the read and the write
+)
+
+// r800Bus counts the clock cycles consumed by each bus access on
+// VariantR800, including accesses served from MapMemory pages.
+//
+// Every memory or I/O access costs one cycle. The R800 keeps a DRAM page
+// open between accesses; a memory access whose address high byte differs
+// from the previous one is a page break and costs one extra cycle. I/O
+// accesses close the open page. Wait states inserted by the system (for
+// example on turbo R I/O ports) are not modeled and can be charged with
+// AddCycles.
+type r800Bus struct {
+	cycles uint64 // cycles accumulated since the last take
+	page   int    // high byte of the open DRAM page, or -1 if none
+}
+
+// mem charges a memory access, including any page-break penalty.
+func (b *r800Bus) mem(addr uint16) {
+	b.cycles++
+	if p := int(addr >> 8); p != b.page {
+		b.cycles++
+		b.page = p
+	}
+}
+
+// io charges an I/O access and closes the open DRAM page.
+func (b *r800Bus) io() {
+	b.cycles++
+	b.page = -1
+}
+
+// take returns the cycles accumulated since the previous call and clears
+// the count. A step with no bus activity (HALT) costs one cycle.
+func (b *r800Bus) take() uint64 {
+	n := b.cycles
+	b.cycles = 0
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// reset clears the counter and closes the open page.
+func (b *r800Bus) reset() {
+	b.cycles = 0
+	b.page = -1
+}
+
+func initR800Ops() {
+	r800Ops = baseOps
+	r800EdOps = edOps
+
+	r800Ops[0xED] = func(c *CPU, _ uint8) { dispatchED(c, &r800EdOps) }
+
+	// --- Internal cycles ---
+	// The Z80 handlers do the work; these add the cycles the R800 spends
+	// without a bus access. The DD/FD forms of PUSH and EX (SP) reach the
+	// same entries through the prefix.
+	r800Internal := func(op uint8, n uint64) {
+		h := baseOps[op]
+		r800Ops[op] = func(c *CPU, op uint8) {
+			h(c, op)
+			c.r800.cycles += n
+		}
+	}
+	r800Internal(0x18, r800JumpCycles) // JR e
+	for i := uint8(0); i < 4; i++ {
+		r800Internal(i<<4|0xC5, r800PushCycles) // PUSH rr
+	}
+	r800Internal(0xE3, r800ExSPCycles) // EX (SP),HL
+	r800Internal(0x34, r800IncCycles)  // INC (HL)
+	r800Internal(0x35, r800IncCycles)  // DEC (HL)
+
+	// JR cc, e: 0x20=NZ, 0x28=Z, 0x30=NC, 0x38=C
+	for i := uint8(0); i < 4; i++ {
+		op := i<<3 | 0x20
+		h := baseOps[op]
+		flag := flagZ
+		if i >= 2 {
+			flag = flagC
+		}
+		r800Ops[op] = func(c *CPU, op uint8) {
+			taken := (c.getF()&flag != 0) == (i&1 != 0)
+			h(c, op)
+			if taken {
+				c.r800.cycles += r800JumpCycles
+			}
+		}
+	}
+
+	// DJNZ e
+	djnz := baseOps[0x10]
+	r800Ops[0x10] = func(c *CPU, op uint8) {
+		djnz(c, op)
+		if c.getB() != 0 {
+			c.r800.cycles += r800JumpCycles
+		}
+	}
+
+	// --- MULUB A, r: HL = A * r ---
+	// 0xC1=B, 0xC9=C, 0xD1=D, 0xD9=E, 0xE1=H, 0xE9=L, 0xF9=A
+	for i := uint8(0); i < 8; i++ {
+		if i == 6 {
+			continue
+		}
+		r800EdOps[i<<3|0xC1] = func(c *CPU, op uint8) {
+			result := uint16(c.getA()) * uint16(c.getR8((op>>3)&7))
+			c.reg.HL = result
+			f := c.getF() & (flagH | flagN | flagF3 | flagF5)
+			if result == 0 {
+				f |= flagZ
+			}
+			if result > 0xFF {
+				f |= flagC
+			}
+			c.setF(f)
+			c.r800.cycles += r800MulubCycles
+		}
+	}
+
+	// --- MULUW HL, rr: DE:HL = HL * rr ---
+	// 0xC3=BC, 0xD3=DE, 0xE3=HL, 0xF3=SP
+	for i := uint8(0); i < 4; i++ {
+		r800EdOps[i<<4|0xC3] = func(c *CPU, op uint8) {
+			result := uint32(c.reg.HL) * uint32(*c.getRR((op >> 4) & 3))
+			c.reg.DE = uint16(result >> 16)
+			c.reg.HL = uint16(result)
+			f := c.getF() & (flagH | flagN | flagF3 | flagF5)
+			if result == 0 {
+				f |= flagZ
+			}
+			if result > 0xFFFF {
+				f |= flagC
+			}
+			c.setF(f)
+			c.r800.cycles += r800MuluwCycles
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package z80
+
+import "testing"
+
+func newTestR800() (*CPU, *testBus) {
+	bus := &testBus{}
+	cpu := New(bus, WithVariant(VariantR800))
+	return cpu, bus
+}
+
+func TestR800_MULUB(t *testing.T) {
+	cpu, bus := newTestR800()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0xC1 // MULUB A,B
+	cpu.reg.AF = 0x1000 | uint16(flagS|flagPV|flagH)
+	cpu.reg.BC = 0x2000
+	cycles := cpu.Step()
+	if cpu.reg.HL != 0x0200 {
+		t.Errorf("HL = %04x, want 0200", cpu.reg.HL)
+	}
+	// S and P/V reset, H preserved, C set because the result exceeds 8 bits.
+	if cpu.getF() != flagH|flagC {
+		t.Errorf("F = %02x, want %02x", cpu.getF(), flagH|flagC)
+	}
+	// Page break on the first fetch, then one cycle per byte, then the
+	// multiplier's internal cycles.
+	if cycles != 3+r800MulubCycles {
+		t.Errorf("cycles = %d, want %d", cycles, 3+r800MulubCycles)
+	}
+}
+
+func TestR800_MULUB_Zero(t *testing.T) {
+	cpu, bus := newTestR800()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0xF9 // MULUB A,A
+	cpu.reg.AF = 0x0000
+	cpu.reg.HL = 0xFFFF
+	cpu.Step()
+	if cpu.reg.HL != 0 {
+		t.Errorf("HL = %04x, want 0000", cpu.reg.HL)
+	}
+	if cpu.getF()&flagZ == 0 {
+		t.Error("Z should be set for a zero product")
+	}
+}
+
+func TestR800_MULUW(t *testing.T) {
+	cpu, bus := newTestR800()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0xC3 // MULUW HL,BC
+	cpu.reg.HL = 0x1234
+	cpu.reg.BC = 0x5678
+	cycles := cpu.Step()
+	if cpu.reg.DE != 0x0626 || cpu.reg.HL != 0x0060 {
+		t.Errorf("DE:HL = %04x:%04x, want 0626:0060", cpu.reg.DE, cpu.reg.HL)
+	}
+	if cpu.getF()&flagC == 0 {
+		t.Error("C should be set when the product exceeds 16 bits")
+	}
+	if cycles != 3+r800MuluwCycles {
+		t.Errorf("cycles = %d, want %d", cycles, 3+r800MuluwCycles)
+	}
+}
+
+func TestR800_MultiplyIsNOPOnZ80(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0xC1
+	cpu.reg.AF = 0x1000
+	cpu.reg.BC = 0x2000
+	cpu.reg.HL = 0xBEEF
+	cycles := cpu.Step()
+	if cpu.reg.HL != 0xBEEF {
+		t.Errorf("HL = %04x, want BEEF", cpu.reg.HL)
+	}
+	if cycles != 8 {
+		t.Errorf("cycles = %d, want 8", cycles)
+	}
+}
+
+func TestR800_Timing(t *testing.T) {
+	cpu, bus := newTestR800()
+	// NOP; NOP; LD A,(8000h); OUT (10h),A; NOP
+	copy(bus.mem[:], []uint8{0x00, 0x00, 0x3A, 0x00, 0x80, 0xD3, 0x10, 0x00})
+
+	want := []struct {
+		name   string
+		cycles int
+	}{
+		{"NOP (page break)", 2},
+		{"NOP", 1},
+		{"LD A,(nn) (page break on data)", 5},
+		{"OUT (n),A (page break on fetch)", 4},
+		{"NOP (page closed by I/O)", 2},
+	}
+	for _, w := range want {
+		if got := cpu.Step(); got != w.cycles {
+			t.Errorf("%s: cycles = %d, want %d", w.name, got, w.cycles)
+		}
+	}
+}
+
+func TestR800_InternalCycles(t *testing.T) {
+	// Each instruction runs from 0000h with the stack and (HL) in the
+	// same page, so the only page break is the first fetch.
+	for _, tc := range []struct {
+		name   string
+		code   []uint8
+		f, b   uint8
+		cycles int
+	}{
+		{"JR e", []uint8{0x18, 0x10}, 0, 0, 4},
+		{"JR NZ,e taken", []uint8{0x20, 0x10}, 0, 0, 4},
+		{"JR NZ,e not taken", []uint8{0x20, 0x10}, flagZ, 0, 3},
+		{"JR Z,e taken", []uint8{0x28, 0x10}, flagZ, 0, 4},
+		{"JR NC,e not taken", []uint8{0x30, 0x10}, flagC, 0, 3},
+		{"JR C,e taken", []uint8{0x38, 0x10}, flagC, 0, 4},
+		{"DJNZ e taken", []uint8{0x10, 0x10}, 0, 2, 4},
+		{"DJNZ e not taken", []uint8{0x10, 0x10}, 0, 1, 3},
+		{"PUSH BC", []uint8{0xC5}, 0, 0, 5},
+		{"PUSH AF", []uint8{0xF5}, 0, 0, 5},
+		{"PUSH IX", []uint8{0xDD, 0xE5}, 0, 0, 6},
+		{"POP BC", []uint8{0xC1}, 0, 0, 4},
+		{"EX (SP),HL", []uint8{0xE3}, 0, 0, 8},
+		{"EX (SP),IY", []uint8{0xFD, 0xE3}, 0, 0, 9},
+		{"INC (HL)", []uint8{0x34}, 0, 0, 5},
+		{"DEC (HL)", []uint8{0x35}, 0, 0, 5},
+		{"LD (HL),n", []uint8{0x36, 0x12}, 0, 0, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTestR800()
+			copy(bus.mem[:], tc.code)
+			cpu.reg.AF = uint16(tc.f)
+			cpu.reg.BC = uint16(tc.b) << 8
+			cpu.reg.HL = 0x0040
+			cpu.reg.SP = 0x0080
+			if got := cpu.Step(); got != tc.cycles {
+				t.Errorf("cycles = %d, want %d", got, tc.cycles)
+			}
+		})
+	}
+}
+
+func TestR800_Halt(t *testing.T) {
+	cpu, _ := newTestR800()
+	cpu.reg.Halted = true
+	if cycles := cpu.Step(); cycles != 1 {
+		t.Errorf("HALT cycles = %d, want 1", cycles)
+	}
+}
+
+func TestR800_NoLDAIRQuirk(t *testing.T) {
+	cpu, bus := newTestR800()
+	bus.mem[0] = 0xED
+	bus.mem[1] = 0x57
+	cpu.reg.SP = 0xFFFE
+	cpu.reg.IFF1 = true
+	cpu.reg.IFF2 = true
+	cpu.reg.IM = 1
+	cpu.Step()
+	cpu.INT(true, 0xFF)
+	cpu.Step()
+	if cpu.reg.PC != 0x0038 {
+		t.Fatalf("PC = %04x, want 0038", cpu.reg.PC)
+	}
+	if cpu.getF()&flagPV == 0 {
+		t.Error("PV should be preserved on the R800")
+	}
+}
+
+func TestR800_OpsNilCheck(t *testing.T) {
+	newTestR800()
+	for i := 0; i < 256; i++ {
+		if r800Ops[i] == nil {
+			t.Errorf("r800Ops[0x%02X] is nil", i)
+		}
+	}
+	for _, op := range []uint8{0xC1, 0xC9, 0xD1, 0xD9, 0xE1, 0xE9, 0xF9, 0xC3, 0xD3, 0xE3, 0xF3} {
+		if r800EdOps[op] == nil {
+			t.Errorf("r800EdOps[0x%02X] is nil", op)
+		}
+		if edOps[op] != nil {
+			t.Errorf("edOps[0x%02X] should stay unassigned on the Z80", op)
+		}
+	}
+}
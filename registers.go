@@ -1,10 +1,20 @@
 package z80
 
-// Registers holds the programmer-visible state of the Z80.
+import (
+	"fmt"
+	"io"
+)
+
+// Registers holds the programmer-visible state of the Z80, and WZ.
 //
 // Register pairs are stored as uint16 with the high byte first:
 // AF has A in bits 15-8 and F in bits 7-0. Individual registers
-// can be extracted with shifts and masks (e.g. A = AF >> 8).
+// are read and written with the named methods (A, SetA, IXH, ...).
+//
+// WZ is the Z80's internal MEMPTR register. Programs cannot read it,
+// but it supplies F3 and F5 after BIT n,(HL) and after a repeating
+// block instruction, and FUSE and the SingleStepTests vectors record
+// it. It is updated as the Z80 updates it on every variant.
 type Registers struct {
 	AF, BC, DE, HL     uint16 // Main register pairs
 	AF_, BC_, DE_, HL_ uint16 // Shadow register pairs
@@ -14,4 +24,103 @@ type Registers struct {
 	IFF1, IFF2         bool   // Interrupt flip-flops
 	IM                 uint8  // Interrupt mode (0, 1, or 2)
 	Halted             bool   // True if executing HALT instruction
+	WZ                 uint16 // Internal MEMPTR register
+}
+
+// Named 8-bit registers. The getters take a Registers value and the
+// setters a pointer, so they work on a snapshot from CPU.Registers
+// before it is passed back to SetState.
+
+func (r Registers) A() uint8        { return uint8(r.AF >> 8) }
+func (r Registers) F() Flags        { return Flags(r.AF) }
+func (r Registers) B() uint8        { return uint8(r.BC >> 8) }
+func (r Registers) C() uint8        { return uint8(r.BC) }
+func (r Registers) D() uint8        { return uint8(r.DE >> 8) }
+func (r Registers) E() uint8        { return uint8(r.DE) }
+func (r Registers) H() uint8        { return uint8(r.HL >> 8) }
+func (r Registers) L() uint8        { return uint8(r.HL) }
+func (r Registers) IXH() uint8      { return uint8(r.IX >> 8) }
+func (r Registers) IXL() uint8      { return uint8(r.IX) }
+func (r Registers) IYH() uint8      { return uint8(r.IY >> 8) }
+func (r Registers) IYL() uint8      { return uint8(r.IY) }
+func (r *Registers) SetA(v uint8)   { r.AF = setHi(r.AF, v) }
+func (r *Registers) SetF(v Flags)   { r.AF = setLo(r.AF, uint8(v)) }
+func (r *Registers) SetB(v uint8)   { r.BC = setHi(r.BC, v) }
+func (r *Registers) SetC(v uint8)   { r.BC = setLo(r.BC, v) }
+func (r *Registers) SetD(v uint8)   { r.DE = setHi(r.DE, v) }
+func (r *Registers) SetE(v uint8)   { r.DE = setLo(r.DE, v) }
+func (r *Registers) SetH(v uint8)   { r.HL = setHi(r.HL, v) }
+func (r *Registers) SetL(v uint8)   { r.HL = setLo(r.HL, v) }
+func (r *Registers) SetIXH(v uint8) { r.IX = setHi(r.IX, v) }
+func (r *Registers) SetIXL(v uint8) { r.IX = setLo(r.IX, v) }
+func (r *Registers) SetIYH(v uint8) { r.IY = setHi(r.IY, v) }
+func (r *Registers) SetIYL(v uint8) { r.IY = setLo(r.IY, v) }
+
+func setHi(pair uint16, v uint8) uint16 { return uint16(v)<<8 | pair&0xFF }
+func setLo(pair uint16, v uint8) uint16 { return pair&0xFF00 | uint16(v) }
+
+// String returns the main registers on one line in the layout of a
+// MAME debugger trace, with the program counter added as PC=:
+//
+//	AF=FFFF BC=0000 DE=0000 HL=0000 IX=FFFF IY=FFFF SP=FFFF PC=0000
+//
+// This is the register prefix trace.NewMAME writes before each
+// instruction.
+func (r Registers) String() string {
+	return fmt.Sprintf("AF=%04X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X PC=%04X",
+		r.AF, r.BC, r.DE, r.HL, r.IX, r.IY, r.SP, r.PC)
+}
+
+// Format implements fmt.Formatter. %v and %s print the String form. %+v
+// prints the two state lines of FUSE's core tests, without the tstates
+// column that Registers does not hold:
+//
+//	AF BC DE HL AF' BC' DE' HL' IX IY SP PC MEMPTR
+//	I R IFF1 IFF2 IM halted
+//
+// %#v prints the Go syntax. The integer verbs b, d, o, O, x and X print
+// the fields in declaration order between braces, as for a struct, with
+// the bool fields as 0 or 1, so %X prints every register in hex. Other
+// verbs are rejected in fmt's %!verb(type=value) form.
+func (r Registers) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		switch {
+		case f.Flag('#'):
+			r.goSyntax(f)
+		case f.Flag('+'):
+			fmt.Fprintf(f, "%04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x %04x\n%02x %02x %d %d %d %d",
+				r.AF, r.BC, r.DE, r.HL, r.AF_, r.BC_, r.DE_, r.HL_, r.IX, r.IY, r.SP, r.PC, r.WZ,
+				r.I, r.R, boolByte(r.IFF1), boolByte(r.IFF2), r.IM, boolByte(r.Halted))
+		default:
+			fmt.Fprint(f, r.String())
+		}
+	case 's':
+		fmt.Fprint(f, r.String())
+	case 'b', 'd', 'o', 'O', 'x', 'X':
+		format := fmt.FormatString(f, verb)
+		for i, v := range []any{
+			r.AF, r.BC, r.DE, r.HL, r.AF_, r.BC_, r.DE_, r.HL_, r.IX, r.IY, r.SP, r.PC,
+			r.I, r.R, boolByte(r.IFF1), boolByte(r.IFF2), r.IM, boolByte(r.Halted), r.WZ,
+		} {
+			if i == 0 {
+				fmt.Fprint(f, "{")
+			} else {
+				fmt.Fprint(f, " ")
+			}
+			fmt.Fprintf(f, format, v)
+		}
+		fmt.Fprint(f, "}")
+	default:
+		fmt.Fprintf(f, "%%!%c(z80.Registers=%s)", verb, r.String())
+	}
+}
+
+// goSyntax writes r as a Go composite literal, in the form %#v gives
+// for a struct without a Format method.
+func (r Registers) goSyntax(w io.Writer) {
+	fmt.Fprintf(w, "z80.Registers{AF:%#x, BC:%#x, DE:%#x, HL:%#x, ", r.AF, r.BC, r.DE, r.HL)
+	fmt.Fprintf(w, "AF_:%#x, BC_:%#x, DE_:%#x, HL_:%#x, ", r.AF_, r.BC_, r.DE_, r.HL_)
+	fmt.Fprintf(w, "IX:%#x, IY:%#x, SP:%#x, PC:%#x, I:%#x, R:%#x, ", r.IX, r.IY, r.SP, r.PC, r.I, r.R)
+	fmt.Fprintf(w, "IFF1:%t, IFF2:%t, IM:%#x, Halted:%t, WZ:%#x}", r.IFF1, r.IFF2, r.IM, r.Halted, r.WZ)
 }
@@ -104,7 +104,9 @@ func (c *CPU) getRRPush(idx uint8) *uint16 {
 }
 
 // ixiyAddr fetches a displacement byte from PC and returns *ixiyReg + sign_extend(d).
+// The address is also left in WZ.
 func (c *CPU) ixiyAddr() uint16 {
 	d := int8(c.fetchPC())
-	return uint16(int32(*c.ixiyReg) + int32(d))
+	c.reg.WZ = uint16(int32(*c.ixiyReg) + int32(d))
+	return c.reg.WZ
 }
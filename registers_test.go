@@ -0,0 +1,140 @@
+package z80
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegisterAccessors(t *testing.T) {
+	var r Registers
+	r.SetA(0x12)
+	r.SetF(FlagZ | FlagC)
+	r.SetB(0x34)
+	r.SetC(0x56)
+	r.SetD(0x78)
+	r.SetE(0x9A)
+	r.SetH(0xBC)
+	r.SetL(0xDE)
+	r.SetIXH(0xF0)
+	r.SetIXL(0x0F)
+	r.SetIYH(0x11)
+	r.SetIYL(0x22)
+	want := Registers{AF: 0x1241, BC: 0x3456, DE: 0x789A, HL: 0xBCDE, IX: 0xF00F, IY: 0x1122}
+	if r != want {
+		t.Fatalf("got %+v, want %+v", r, want)
+	}
+	got := []uint8{r.A(), uint8(r.F()), r.B(), r.C(), r.D(), r.E(), r.H(), r.L(), r.IXH(), r.IXL(), r.IYH(), r.IYL()}
+	exp := []uint8{0x12, 0x41, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0, 0x0F, 0x11, 0x22}
+	for i := range got {
+		if got[i] != exp[i] {
+			t.Errorf("getter %d = 0x%02X, want 0x%02X", i, got[i], exp[i])
+		}
+	}
+}
+
+func TestSetTraceHook(t *testing.T) {
+	cpu, bus := newTestCPU()
+	bus.mem[0x0000] = 0xFB // EI
+	bus.mem[0x0001] = 0x76 // HALT
+	bus.mem[0x0038] = 0x00 // NOP
+	var pcs []uint16
+	var cycles []uint64
+	cpu.SetTraceHook(func(cycle uint64, regs Registers) {
+		pcs = append(pcs, regs.PC)
+		cycles = append(cycles, cycle)
+	})
+	cpu.SetState(Registers{SP: 0xFFFF, IM: 1})
+	for range 4 {
+		cpu.Step() // EI, HALT, HALT cycle, HALT cycle
+	}
+	cpu.INT(true, 0xFF)
+	cpu.Step() // interrupt response
+	cpu.INT(false, 0xFF)
+	cpu.Step() // NOP at 0038h
+
+	// HALT cycles and the interrupt response are not instructions.
+	if want := []uint16{0x0000, 0x0001, 0x0038}; !slices.Equal(pcs, want) {
+		t.Errorf("traced PCs %04X, want %04X", pcs, want)
+	}
+	if cycles[0] != 0 || cycles[1] != 4 {
+		t.Errorf("cycles = %v", cycles)
+	}
+	cpu.SetTraceHook(nil)
+	cpu.Step()
+	if len(pcs) != 3 {
+		t.Error("hook called after removal")
+	}
+}
+
+func TestWZ(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code []uint8
+		want uint16
+	}{
+		{"LD A,(BC)", []uint8{0x0A}, 0x1235},
+		{"LD (BC),A", []uint8{0x02}, 0x7735},
+		{"LD A,(nn)", []uint8{0x3A, 0xFF, 0x40}, 0x4100},
+		{"LD (nn),A", []uint8{0x32, 0xFF, 0x40}, 0x7700},
+		{"LD (nn),HL", []uint8{0x22, 0x00, 0x40}, 0x4001},
+		{"ADD HL,BC", []uint8{0x09}, 0x2001},
+		{"JP nn", []uint8{0xC3, 0x00, 0x30}, 0x3000},
+		{"JP NZ,nn not taken", []uint8{0xC2, 0x00, 0x30}, 0x3000},
+		{"JR e", []uint8{0x18, 0x10}, 0x0012},
+		{"RST 38h", []uint8{0xFF}, 0x0038},
+		{"EX (SP),HL", []uint8{0xE3}, 0x00E3},
+		{"IN A,(n)", []uint8{0xDB, 0xFE}, 0x77FF},
+		{"OUT (n),A", []uint8{0xD3, 0xFE}, 0x77FF},
+		{"IN B,(C)", []uint8{0xED, 0x40}, 0x1235},
+		{"LD A,(IX+d)", []uint8{0xDD, 0x7E, 0xFE}, 0x4FFE},
+		{"RLD", []uint8{0xED, 0x6F}, 0x2001},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, bus := newTestCPU()
+			copy(bus.mem[:], tc.code)
+			cpu.SetState(Registers{AF: 0x7740, BC: 0x1234, HL: 0x2000, IX: 0x5000})
+			cpu.Step()
+			if got := cpu.Registers().WZ; got != tc.want {
+				t.Errorf("WZ = %04X, want %04X", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegistersFormat(t *testing.T) {
+	r := Registers{
+		AF: 0x12C1, BC: 0x3456, DE: 0x789A, HL: 0xBCDE,
+		AF_: 0x1111, BC_: 0x2222, DE_: 0x3333, HL_: 0x4444,
+		IX: 0x5555, IY: 0x6666, SP: 0xFFFE, PC: 0x0100,
+		I: 0x3F, R: 0x7F, IFF1: true, IM: 2, WZ: 0x0101,
+	}
+	line := "AF=12C1 BC=3456 DE=789A HL=BCDE IX=5555 IY=6666 SP=FFFE PC=0100"
+	for _, tc := range []struct {
+		format, want string
+	}{
+		{"%v", line},
+		{"%s", line},
+		{"%+v", "12c1 3456 789a bcde 1111 2222 3333 4444 5555 6666 fffe 0100 0101\n3f 7f 1 0 2 0"},
+		{"%X", "{12C1 3456 789A BCDE 1111 2222 3333 4444 5555 6666 FFFE 100 3F 7F 1 0 2 0 101}"},
+		{"%04x", "{12c1 3456 789a bcde 1111 2222 3333 4444 5555 6666 fffe 0100 003f 007f 0001 0000 0002 0000 0101}"},
+		{"%d", "{4801 13398 30874 48350 4369 8738 13107 17476 21845 26214 65534 256 63 127 1 0 2 0 257}"},
+		{"%c", "%!c(z80.Registers=" + line + ")"},
+		{"%t", "%!t(z80.Registers=" + line + ")"},
+	} {
+		if got := fmt.Sprintf(tc.format, r); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.format, got, tc.want)
+		}
+	}
+	if got := r.String(); got != line {
+		t.Errorf("String = %q", got)
+	}
+
+	// %#v matches what fmt gives for the same struct without Format.
+	type plain Registers
+	want := strings.Replace(fmt.Sprintf("%#v", plain(r)), "z80.plain", "z80.Registers", 1)
+	if got := fmt.Sprintf("%#v", r); got != want {
+		t.Errorf("%%#v = %q, want %q", got, want)
+	}
+}
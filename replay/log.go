@@ -0,0 +1,86 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Log file layout: the magic "Z80J", a version byte, then one
+// fixed-size little-endian record per event: kind, assert, port, data,
+// access, cycle and arg.
+const (
+	logVersion = 1
+	recordSize = 1 + 1 + 2 + 1 + 8 + 8 + 8
+)
+
+var logMagic = [4]byte{'Z', '8', '0', 'J'}
+
+// Errors returned when reading a log.
+var (
+	// ErrNotLog is returned for data that does not start with a log header.
+	ErrNotLog = errors.New("replay: not an event log")
+	// ErrNewerLog is returned for a log written by a newer version of
+	// this package.
+	ErrNewerLog = errors.New("replay: event log is from a newer version")
+)
+
+// WriteEvents writes events to w as a log file, for example to attach a
+// recorded session to a bug report.
+func WriteEvents(w io.Writer, events []Event) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(logMagic[:])
+	bw.WriteByte(logVersion)
+	var rec [recordSize]byte
+	for i := range events {
+		e := &events[i]
+		rec[0] = uint8(e.Kind)
+		rec[1] = 0
+		if e.Assert {
+			rec[1] = 1
+		}
+		binary.LittleEndian.PutUint16(rec[2:], e.Port)
+		rec[4] = e.Data
+		binary.LittleEndian.PutUint64(rec[5:], e.Access)
+		binary.LittleEndian.PutUint64(rec[13:], e.Cycle)
+		binary.LittleEndian.PutUint64(rec[21:], e.Arg)
+		bw.Write(rec[:])
+	}
+	return bw.Flush()
+}
+
+// ReadEvents reads a log file written by WriteEvents.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	br := bufio.NewReader(r)
+	var hdr [5]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, ErrNotLog
+	}
+	if [4]byte(hdr[:4]) != logMagic {
+		return nil, ErrNotLog
+	}
+	if hdr[4] > logVersion {
+		return nil, ErrNewerLog
+	}
+	var events []Event
+	var rec [recordSize]byte
+	for {
+		_, err := io.ReadFull(br, rec[:])
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, Event{
+			Kind:   Kind(rec[0]),
+			Assert: rec[1] != 0,
+			Port:   binary.LittleEndian.Uint16(rec[2:]),
+			Data:   rec[4],
+			Access: binary.LittleEndian.Uint64(rec[5:]),
+			Cycle:  binary.LittleEndian.Uint64(rec[13:]),
+			Arg:    binary.LittleEndian.Uint64(rec[21:]),
+		})
+	}
+}
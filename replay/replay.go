@@ -0,0 +1,298 @@
+// Package replay records what a Z80 system's peripherals fed the CPU
+// and plays it back without them.
+//
+// A Recorder wraps a Bus and journals every In result, and every INT,
+// NMI and AddCycles call made through it, with the CPU's cycle count.
+// A Player stands in for the peripherals: it answers In from the log and
+// makes the interrupt calls again at the same points, while memory
+// accesses go to RAM supplied by the caller. Starting from the same CPU
+// state and memory, the CPU then runs exactly as it did when recorded,
+// so a user's session can be reproduced from the log alone.
+//
+// Events are placed by the number of bus accesses made before them, as
+// counted by the CPU's Accesses with bus tracking on, which pins an
+// interrupt raised by a device inside an access to that access, and one
+// raised by the host between instructions to the boundary. The CPU
+// counts accesses to MapMemory pages, opcode fetches skipped by the
+// translation cache and the eZ80's 24-bit accesses too, so a log replays
+// the same whether the map or cache was in use, and in either eZ80 mode.
+// Memory-mapped devices are not journaled; RAM is RAM.
+package replay
+
+import (
+	"errors"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// Kind is the type of a journaled event.
+type Kind uint8
+
+const (
+	KindIn        Kind = iota // an In result
+	KindINT                   // an INT call
+	KindINTAt                 // an INTAt call
+	KindNMI                   // an NMI call
+	KindNMIAt                 // an NMIAt call
+	KindAddCycles             // an AddCycles call
+)
+
+var kindNames = [...]string{"In", "INT", "INTAt", "NMI", "NMIAt", "AddCycles"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Unknown"
+}
+
+// Event is one journaled input to the CPU.
+type Event struct {
+	Kind   Kind
+	Access uint64 // CPU accesses made since Attach, counting one in progress
+	Cycle  uint64 // the CPU's Cycles when the event happened
+	Port   uint16 // In: the port read
+	Data   uint8  // In: the value read; INT, INTAt: the data byte
+	Assert bool   // INT, INTAt: the line state
+	Arg    uint64 // INTAt, NMIAt: the cycle argument; AddCycles: the count
+}
+
+// ErrDiverged is returned by Player.Err when the CPU did not run as it
+// did when the log was recorded, or ran past the end of the log.
+var ErrDiverged = errors.New("replay: execution diverged from the log")
+
+// Recorder is a Bus that passes accesses to another Bus and journals the
+// input the CPU receives.
+//
+//	rec := replay.NewRecorder(bus)
+//	cpu := z80.New(rec)
+//	rec.Attach(cpu)
+type Recorder struct {
+	bus    z80.Bus
+	cpu    *z80.CPU
+	base   uint64 // the CPU's Accesses at Attach
+	events []Event
+}
+
+// NewRecorder returns a Recorder that passes accesses to bus. Attach the
+// CPU before running it.
+func NewRecorder(bus z80.Bus) *Recorder {
+	return &Recorder{bus: bus}
+}
+
+// Attach sets the CPU whose cycle and access counts timestamp events and
+// turns on its bus tracking. Accesses are counted from the call.
+func (r *Recorder) Attach(cpu *z80.CPU) {
+	r.cpu = cpu
+	cpu.SetBusTracking(true)
+	r.base = cpu.Accesses()
+}
+
+// Events returns the journal so far. It is appended to as the CPU runs.
+func (r *Recorder) Events() []Event {
+	return r.events
+}
+
+func (r *Recorder) Fetch(addr uint16) uint8 {
+	return r.bus.Fetch(addr)
+}
+
+func (r *Recorder) Read(addr uint16) uint8 {
+	return r.bus.Read(addr)
+}
+
+func (r *Recorder) Write(addr uint16, val uint8) {
+	r.bus.Write(addr, val)
+}
+
+func (r *Recorder) In(port uint16) uint8 {
+	v := r.bus.In(port)
+	r.journal(Event{Kind: KindIn, Port: port, Data: v})
+	return v
+}
+
+func (r *Recorder) Out(port uint16, val uint8) {
+	r.bus.Out(port, val)
+}
+
+// INT journals an INT call and passes it to the CPU. Devices and the
+// host raise interrupts through the Recorder so that they are replayed.
+func (r *Recorder) INT(assert bool, data uint8) {
+	r.journal(Event{Kind: KindINT, Assert: assert, Data: data})
+	r.cpu.INT(assert, data)
+}
+
+// INTAt journals an INTAt call and passes it to the CPU.
+func (r *Recorder) INTAt(assert bool, data uint8, cycle uint64) {
+	r.journal(Event{Kind: KindINTAt, Assert: assert, Data: data, Arg: cycle})
+	r.cpu.INTAt(assert, data, cycle)
+}
+
+// NMI journals an NMI call and passes it to the CPU.
+func (r *Recorder) NMI() {
+	r.journal(Event{Kind: KindNMI})
+	r.cpu.NMI()
+}
+
+// NMIAt journals an NMIAt call and passes it to the CPU.
+func (r *Recorder) NMIAt(cycle uint64) {
+	r.journal(Event{Kind: KindNMIAt, Arg: cycle})
+	r.cpu.NMIAt(cycle)
+}
+
+// AddCycles journals an AddCycles call and passes it to the CPU.
+func (r *Recorder) AddCycles(n uint64) {
+	r.journal(Event{Kind: KindAddCycles, Arg: n})
+	r.cpu.AddCycles(n)
+}
+
+func (r *Recorder) journal(e Event) {
+	e.Access = r.cpu.Accesses() - r.base
+	e.Cycle = r.cpu.Cycles()
+	r.events = append(r.events, e)
+}
+
+// Player is a Bus that replays a journal in place of the peripherals.
+// Memory accesses go to the Bus given to NewPlayer and Out is dropped.
+// Drive the CPU through the Player's Step or RunCycles, which make the
+// journaled calls that fell between instructions.
+//
+//	p := replay.NewPlayer(ram, events)
+//	cpu := z80.New(p)
+//	p.Attach(cpu)
+//	cpu.Deserialize(start)
+//	for !p.Done() && p.Err() == nil {
+//		p.Step()
+//	}
+type Player struct {
+	mem    z80.Bus
+	cpu    *z80.CPU
+	events []Event
+	next   int
+	base   uint64 // the CPU's Accesses at Attach
+	err    error
+}
+
+// NewPlayer returns a Player for events, with memory accesses going to
+// mem. Attach the CPU before running it.
+func NewPlayer(mem z80.Bus, events []Event) *Player {
+	return &Player{mem: mem, events: events}
+}
+
+// Attach sets the CPU the journaled calls are made on and turns on its
+// bus tracking. Accesses are counted from the call.
+func (p *Player) Attach(cpu *z80.CPU) {
+	p.cpu = cpu
+	cpu.SetBusTracking(true)
+	p.base = cpu.Accesses()
+}
+
+// Done reports whether every event has been replayed.
+func (p *Player) Done() bool {
+	return p.next == len(p.events)
+}
+
+// Err returns ErrDiverged once replay has gone wrong, and nil before.
+func (p *Player) Err() error {
+	return p.err
+}
+
+// Step makes the journaled calls due before the next instruction, then
+// runs it with the CPU's Step.
+func (p *Player) Step() int {
+	p.apply(true)
+	return p.cpu.Step()
+}
+
+// RunCycles runs instructions with Step until at least n T-states have
+// been consumed and returns the number consumed.
+func (p *Player) RunCycles(n int) int {
+	ran := 0
+	for ran < n {
+		ran += p.Step()
+	}
+	return ran
+}
+
+func (p *Player) Fetch(addr uint16) uint8 {
+	v := p.mem.Fetch(addr)
+	p.apply(false)
+	return v
+}
+
+func (p *Player) Read(addr uint16) uint8 {
+	v := p.mem.Read(addr)
+	p.apply(false)
+	return v
+}
+
+func (p *Player) Write(addr uint16, val uint8) {
+	p.mem.Write(addr, val)
+	p.apply(false)
+}
+
+// In returns the journaled result. If the next event is not this read,
+// replay has diverged and In returns FFh.
+func (p *Player) In(port uint16) uint8 {
+	p.apply(false)
+	if p.next == len(p.events) {
+		p.diverged()
+		return 0xFF
+	}
+	e := &p.events[p.next]
+	if e.Kind != KindIn || e.Access != p.access() || e.Port != port || e.Cycle != p.cpu.Cycles() {
+		p.diverged()
+		return 0xFF
+	}
+	p.next++
+	return e.Data
+}
+
+func (p *Player) Out(port uint16, val uint8) {
+	p.apply(false)
+}
+
+// apply makes the journaled calls made up to the current bus access,
+// stopping at an In, which is returned by the access it belongs to.
+// During an access only the calls made by devices inside it are due;
+// at an instruction boundary the host's calls after it are too.
+func (p *Player) apply(boundary bool) {
+	access := p.access()
+	for p.err == nil && p.next < len(p.events) {
+		e := &p.events[p.next]
+		if e.Kind == KindIn || e.Access > access {
+			return
+		}
+		if e.Access < access || (boundary && e.Cycle != p.cpu.Cycles()) {
+			p.diverged()
+			return
+		}
+		if e.Cycle != p.cpu.Cycles() {
+			return
+		}
+		p.next++
+		switch e.Kind {
+		case KindINT:
+			p.cpu.INT(e.Assert, e.Data)
+		case KindINTAt:
+			p.cpu.INTAt(e.Assert, e.Data, e.Arg)
+		case KindNMI:
+			p.cpu.NMI()
+		case KindNMIAt:
+			p.cpu.NMIAt(e.Arg)
+		case KindAddCycles:
+			p.cpu.AddCycles(e.Arg)
+		}
+	}
+}
+
+// access returns the CPU's accesses since Attach.
+func (p *Player) access() uint64 {
+	return p.cpu.Accesses() - p.base
+}
+
+func (p *Player) diverged() {
+	if p.err == nil {
+		p.err = ErrDiverged
+	}
+}
@@ -0,0 +1,270 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+type ram [65536]uint8
+
+func (r *ram) Fetch(addr uint16) uint8      { return r[addr] }
+func (r *ram) Read(addr uint16) uint8       { return r[addr] }
+func (r *ram) Write(addr uint16, val uint8) { r[addr] = val }
+func (r *ram) In(port uint16) uint8         { return 0xFF }
+func (r *ram) Out(port uint16, val uint8)   {}
+
+// machine is RAM plus a device whose port 10h counts reads and which
+// raises INT when a multiple of eight is written to port 20h, until port
+// 30h is read.
+type machine struct {
+	ram
+	rec   *Recorder
+	count uint8
+}
+
+func (m *machine) In(port uint16) uint8 {
+	switch uint8(port) {
+	case 0x10:
+		m.count += 3
+		return m.count
+	case 0x30:
+		m.rec.INT(false, 0xFF)
+	}
+	return 0xFF
+}
+
+func (m *machine) Out(port uint16, val uint8) {
+	if uint8(port) == 0x20 && val&7 == 0 {
+		m.rec.INT(true, 0xFF)
+	}
+}
+
+// program stores port 10h readings from 8000h up and echoes them to port
+// 20h. The IM 1 handler acknowledges the interrupt and counts it at
+// 9000h; the NMI handler returns at once.
+var program = map[uint16][]uint8{
+	0x0000: {
+		0x31, 0x00, 0xF0, // LD SP,F000h
+		0xED, 0x56, // IM 1
+		0x21, 0x00, 0x80, // LD HL,8000h
+		0xFB,       // EI
+		0xDB, 0x10, // IN A,(10h)
+		0x77,       // LD (HL),A
+		0x23,       // INC HL
+		0xD3, 0x20, // OUT (20h),A
+		0x18, 0xF8, // JR 0009h
+	},
+	0x0038: {0xF5, 0xDB, 0x30, 0x3A, 0x00, 0x90, 0x3C, 0x32, 0x00, 0x90, 0xF1, 0xFB, 0xED, 0x4D},
+	0x0066: {0xED, 0x45},
+}
+
+type result struct {
+	regs   z80.Registers
+	cycles uint64
+	mem    ram
+}
+
+// record runs the machine for steps instructions with host NMIs and
+// bus holds, returning the starting state and memory, the log and the
+// result.
+func record(t *testing.T, steps int) ([]byte, ram, []Event, result) {
+	return recordMapped(t, steps, false)
+}
+
+// recordMapped is record, with the RAM mapped into the CPU and the
+// translation cache on if mapped is set.
+func recordMapped(t *testing.T, steps int, mapped bool) ([]byte, ram, []Event, result) {
+	t.Helper()
+	m := &machine{}
+	for addr, code := range program {
+		copy(m.ram[addr:], code)
+	}
+	rec := NewRecorder(m)
+	m.rec = rec
+	cpu := z80.New(rec)
+	rec.Attach(cpu)
+	if mapped {
+		if err := cpu.MapMemory(0, m.ram[:], z80.PageRead|z80.PageWrite); err != nil {
+			t.Fatal(err)
+		}
+		if err := cpu.SetTranslationCache(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start, err := cpu.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := m.ram
+	for n := range steps {
+		if n%50 == 49 {
+			rec.NMI()
+		}
+		if n%70 == 69 {
+			rec.AddCycles(3)
+		}
+		cpu.Step()
+	}
+	return start, mem, rec.Events(), result{cpu.Registers(), cpu.Cycles(), m.ram}
+}
+
+func play(t *testing.T, start []byte, mem ram, events []Event, steps int) (*Player, result) {
+	t.Helper()
+	p := NewPlayer(&mem, events)
+	cpu := z80.New(p)
+	p.Attach(cpu)
+	if err := cpu.UnmarshalBinary(start); err != nil {
+		t.Fatal(err)
+	}
+	for range steps {
+		p.Step()
+	}
+	return p, result{cpu.Registers(), cpu.Cycles(), mem}
+}
+
+func TestReplay_Reproduces(t *testing.T) {
+	start, mem, events, want := record(t, 500)
+	if want.mem[0x9000] == 0 {
+		t.Fatal("no interrupts were taken")
+	}
+	var kinds [KindAddCycles + 1]int
+	for _, e := range events {
+		kinds[e.Kind]++
+	}
+	for _, k := range []Kind{KindIn, KindINT, KindNMI, KindAddCycles} {
+		if kinds[k] == 0 {
+			t.Errorf("no %v events recorded", k)
+		}
+	}
+
+	p, got := play(t, start, mem, events, 500)
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Done() {
+		t.Error("events left over")
+	}
+	if got != want {
+		t.Errorf("got %+v at %d, want %+v at %d", got.regs, got.cycles, want.regs, want.cycles)
+	}
+}
+
+func TestReplay_MappedRecording(t *testing.T) {
+	// Mapped accesses and cached fetches never reach the Recorder, but
+	// the CPU counts them, so the log replays on a plain Bus.
+	start, mem, events, want := recordMapped(t, 500, true)
+	p, got := play(t, start, mem, events, 500)
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Done() {
+		t.Error("events left over")
+	}
+	if got != want {
+		t.Errorf("got %+v at %d, want %+v at %d", got.regs, got.cycles, want.regs, want.cycles)
+	}
+	_, _, plain, _ := record(t, 500)
+	if !slices.Equal(events, plain) {
+		t.Error("log differs from one recorded on a plain Bus")
+	}
+}
+
+func TestReplay_Diverged(t *testing.T) {
+	start, mem, events, _ := record(t, 200)
+	mem[0x000A] = 0x11 // IN A,(11h)
+	p, _ := play(t, start, mem, events, 200)
+	if err := p.Err(); !errors.Is(err, ErrDiverged) {
+		t.Errorf("err = %v, want ErrDiverged", err)
+	}
+}
+
+func TestReplay_PastEnd(t *testing.T) {
+	start, mem, events, _ := record(t, 100)
+	p, _ := play(t, start, mem, events, 150)
+	if err := p.Err(); !errors.Is(err, ErrDiverged) {
+		t.Errorf("err = %v, want ErrDiverged", err)
+	}
+}
+
+func TestEventsRoundTrip(t *testing.T) {
+	_, _, events, _ := record(t, 300)
+	var buf bytes.Buffer
+	if err := WriteEvents(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Len(); got != 5+len(events)*recordSize {
+		t.Errorf("log is %d bytes, want %d", got, 5+len(events)*recordSize)
+	}
+	got, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("read %d events, want %d", len(got), len(events))
+	}
+	for i := range got {
+		if got[i] != events[i] {
+			t.Fatalf("event %d: got %+v, want %+v", i, got[i], events[i])
+		}
+	}
+}
+
+func TestReadEvents_Invalid(t *testing.T) {
+	if _, err := ReadEvents(bytes.NewReader([]byte("nope!"))); !errors.Is(err, ErrNotLog) {
+		t.Errorf("err = %v, want ErrNotLog", err)
+	}
+	if _, err := ReadEvents(bytes.NewReader([]byte("Z80J\x09"))); !errors.Is(err, ErrNewerLog) {
+		t.Errorf("err = %v, want ErrNewerLog", err)
+	}
+	if _, err := ReadEvents(bytes.NewReader([]byte("Z80J\x01\x00\x00"))); err == nil {
+		t.Error("truncated record accepted")
+	}
+}
+
+func TestReplay_EZ80ADL(t *testing.T) {
+	// IN A,(10h); LD (HL),A; INC HL; JR 0000h, all in ADL mode: eight
+	// accesses a loop, one of them the In.
+	code := []uint8{0xDB, 0x10, 0x77, 0x23, 0x18, 0xFA}
+	m := &machine{}
+	copy(m.ram[:], code)
+	rec := NewRecorder(m)
+	m.rec = rec
+	cpu := z80.New(rec, z80.WithVariant(z80.VariantEZ80))
+	rec.Attach(cpu)
+	cpu.SetEZ80State(z80.EZ80Registers{SPL: 0xF000, ADL: true})
+	regs := cpu.Registers()
+	regs.HL = 0x8000
+	cpu.SetState(regs)
+	start, err := cpu.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := m.ram
+	for range 40 {
+		cpu.Step()
+	}
+	events := rec.Events()
+	if len(events) < 2 || events[1].Access-events[0].Access != 8 {
+		t.Fatalf("events %+v, want In events eight accesses apart", events)
+	}
+
+	p := NewPlayer(&mem, events)
+	cpu2 := z80.New(p, z80.WithVariant(z80.VariantEZ80))
+	p.Attach(cpu2)
+	if err := cpu2.UnmarshalBinary(start); err != nil {
+		t.Fatal(err)
+	}
+	for range 40 {
+		p.Step()
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.Registers() != cpu.Registers() || mem != m.ram {
+		t.Errorf("replay ended at %+v, want %+v", cpu2.Registers(), cpu.Registers())
+	}
+}
@@ -0,0 +1,324 @@
+// Package rewind lets a Z80 system go back in time.
+//
+// A Recorder keeps a ring of checkpoints, each a serialized CPU plus the
+// state of the rest of the machine as saved by the caller, taken every
+// so many T-states. Between checkpoints it journals the interrupts the
+// host raises. Rewinding to a cycle restores the nearest checkpoint at
+// or before it and runs the CPU forward, replaying the journaled
+// interrupts at the cycles they were raised. Since the CPU is
+// deterministic, and the Cycles counter tells exactly where it is, the
+// result is the state the machine was in at that cycle.
+//
+//	rec := rewind.New(cpu, machine, 69888, 300) // one checkpoint a frame
+//	for {
+//		cpu.RunCycles(69888)
+//		rec.INT(true, 0xFF) // raise interrupts through the Recorder
+//		rec.Record()
+//	}
+//	...
+//	rec.Rewind(cpu.Cycles() - 5*69888) // back five frames
+package rewind
+
+import (
+	"errors"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// State saves and restores everything outside the CPU that execution
+// depends on or changes: memory, and the state of the devices on the bus.
+type State interface {
+	// AppendState appends the current state to b and returns the result.
+	AppendState(b []byte) []byte
+	// LoadState restores state produced by AppendState.
+	LoadState(data []byte) error
+}
+
+// ErrNotRecorded is returned when rewinding to a cycle that is in the
+// future or older than the oldest checkpoint.
+var ErrNotRecorded = errors.New("rewind: cycle not in history")
+
+// eventKind is the CPU method an event calls.
+type eventKind uint8
+
+const (
+	eventINT eventKind = iota
+	eventNMI
+)
+
+// event is a journaled interrupt: a call made when Cycles was at cycle.
+type event struct {
+	cycle  uint64
+	kind   eventKind
+	assert bool
+	data   uint8
+	at     uint64 // the cycle argument of INTAt or NMIAt, 0 for INT or NMI
+}
+
+// checkpoint is a saved machine state.
+type checkpoint struct {
+	cycle uint64
+	cpu   []byte
+	bus   []byte
+	event int // journal position: events before it are in the state
+}
+
+// Recorder records checkpoints and interrupts for a CPU and rewinds it.
+//
+// Interrupts raised by the host between instructions, for example once
+// per frame or from a scheduler event, must go through the Recorder's
+// INT and NMI methods so they are journaled. Devices that raise
+// interrupts from inside Bus methods should call the CPU directly:
+// running forward re-executes those accesses, which raises them again.
+//
+// Reset restarts the cycle count, which makes the history meaningless;
+// call Clear after resetting the CPU.
+type Recorder struct {
+	cpu      *z80.CPU
+	bus      State
+	interval uint64
+
+	ring []checkpoint // ring buffer of checkpoints, oldest at head
+	head int
+	n    int
+	next uint64 // cycle at which Record takes the next checkpoint
+
+	events []event
+	base   int // journal position of events[0]
+
+	replaying bool // running forward; INT and NMI calls are not journaled
+
+	scratch checkpoint // the present, while searching back in time
+}
+
+// New creates a Recorder for cpu that takes a checkpoint every interval
+// T-states and keeps the last depth of them, so it can go back between
+// (depth-1)*interval and depth*interval T-states. bus may be nil if the
+// CPU is all there is to restore.
+func New(cpu *z80.CPU, bus State, interval uint64, depth int) *Recorder {
+	if interval == 0 {
+		interval = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	return &Recorder{
+		cpu:      cpu,
+		bus:      bus,
+		interval: interval,
+		ring:     make([]checkpoint, depth),
+		next:     cpu.Cycles(),
+	}
+}
+
+// Record takes a checkpoint if interval T-states have passed since the
+// last one. Call it between instructions, as often as convenient; it
+// returns an error only when Tick has left an instruction part-way.
+func (r *Recorder) Record() error {
+	if r.cpu.Cycles() < r.next {
+		return nil
+	}
+	return r.Checkpoint()
+}
+
+// Checkpoint takes a checkpoint now, whatever the interval.
+func (r *Recorder) Checkpoint() error {
+	slot := (r.head + r.n) % len(r.ring)
+	cp := &r.ring[slot]
+	if err := r.save(cp); err != nil {
+		return err
+	}
+	if r.n == len(r.ring) {
+		// The oldest checkpoint's buffers were reused.
+		r.head = (r.head + 1) % len(r.ring)
+	} else {
+		r.n++
+	}
+	r.next = cp.cycle + r.interval
+
+	// Drop events from before the oldest checkpoint.
+	if oldest := r.ring[r.head].event; oldest > r.base {
+		r.events = append(r.events[:0], r.events[oldest-r.base:]...)
+		r.base = oldest
+	}
+	return nil
+}
+
+// Oldest returns the earliest cycle that can be rewound to. It is false
+// if there are no checkpoints.
+func (r *Recorder) Oldest() (uint64, bool) {
+	if r.n == 0 {
+		return 0, false
+	}
+	return r.ring[r.head].cycle, true
+}
+
+// Clear discards all checkpoints and journaled events. The next Record
+// takes a checkpoint.
+func (r *Recorder) Clear() {
+	r.head, r.n = 0, 0
+	r.events = r.events[:0]
+	r.base = 0
+	r.next = r.cpu.Cycles()
+}
+
+// INT journals an INT call and passes it to the CPU.
+func (r *Recorder) INT(assert bool, data uint8) {
+	r.journal(event{kind: eventINT, assert: assert, data: data})
+	r.cpu.INT(assert, data)
+}
+
+// INTAt journals an INTAt call and passes it to the CPU.
+func (r *Recorder) INTAt(assert bool, data uint8, cycle uint64) {
+	r.journal(event{kind: eventINT, assert: assert, data: data, at: cycle})
+	r.cpu.INTAt(assert, data, cycle)
+}
+
+// NMI journals an NMI call and passes it to the CPU.
+func (r *Recorder) NMI() {
+	r.journal(event{kind: eventNMI})
+	r.cpu.NMI()
+}
+
+// NMIAt journals an NMIAt call and passes it to the CPU.
+func (r *Recorder) NMIAt(cycle uint64) {
+	r.journal(event{kind: eventNMI, at: cycle})
+	r.cpu.NMIAt(cycle)
+}
+
+func (r *Recorder) journal(e event) {
+	if r.replaying || r.n == 0 {
+		return
+	}
+	e.cycle = r.cpu.Cycles()
+	r.events = append(r.events, e)
+}
+
+// apply makes the CPU call recorded in e.
+func (r *Recorder) apply(e *event) {
+	switch e.kind {
+	case eventINT:
+		r.cpu.INTAt(e.assert, e.data, e.at)
+	case eventNMI:
+		r.cpu.NMIAt(e.at)
+	}
+}
+
+// Rewind returns the CPU, and the bus state, to the first instruction
+// boundary at or after cycle, before any interrupts raised at that
+// boundary. The history after that point is discarded, so recording
+// continues from there.
+func (r *Recorder) Rewind(cycle uint64) error {
+	i, err := r.restore(cycle)
+	if err != nil {
+		return err
+	}
+	i = r.forward(i, func() bool { return r.cpu.Cycles() < cycle })
+	r.truncate(i)
+	return nil
+}
+
+// restore loads the newest checkpoint at or before cycle and returns
+// its position in the journal.
+func (r *Recorder) restore(cycle uint64) (int, error) {
+	if cycle > r.cpu.Cycles() {
+		return 0, ErrNotRecorded
+	}
+	k := r.find(cycle + 1)
+	if k < 0 {
+		return 0, ErrNotRecorded
+	}
+	cp := r.at(k)
+	return cp.event, r.load(cp)
+}
+
+// find returns the index of the newest checkpoint before cycle, or -1.
+func (r *Recorder) find(cycle uint64) int {
+	for k := r.n - 1; k >= 0; k-- {
+		if r.at(k).cycle < cycle {
+			return k
+		}
+	}
+	return -1
+}
+
+// at returns the k'th oldest checkpoint.
+func (r *Recorder) at(k int) *checkpoint {
+	return &r.ring[(r.head+k)%len(r.ring)]
+}
+
+// save stores the machine's state in cp, reusing its buffers.
+func (r *Recorder) save(cp *checkpoint) error {
+	cpu, err := r.cpu.AppendBinary(cp.cpu[:0])
+	if err != nil {
+		return err
+	}
+	cp.cpu = cpu
+	if r.bus != nil {
+		cp.bus = r.bus.AppendState(cp.bus[:0])
+	}
+	cp.cycle = r.cpu.Cycles()
+	cp.event = r.base + len(r.events)
+	return nil
+}
+
+// load restores the machine to a checkpoint. The restored memory is
+// rewritten behind the CPU's back, so its cached translations are
+// dropped.
+func (r *Recorder) load(cp *checkpoint) error {
+	if err := r.cpu.UnmarshalBinary(cp.cpu); err != nil {
+		return err
+	}
+	if r.bus != nil {
+		if err := r.bus.LoadState(cp.bus); err != nil {
+			return err
+		}
+	}
+	r.cpu.InvalidateCode(0, 0x10000)
+	return nil
+}
+
+// forward steps the CPU from a restored checkpoint while more reports
+// true, replaying journaled events from position i at the cycles they
+// were made. It returns the journal position reached.
+func (r *Recorder) forward(i int, more func() bool) int {
+	r.replaying = true
+	defer func() { r.replaying = false }()
+	for more() {
+		i = r.replay(i)
+		r.cpu.Step()
+	}
+	return i
+}
+
+// replay applies journaled events from position i made at or before the
+// current cycle and returns the position after them.
+func (r *Recorder) replay(i int) int {
+	now := r.cpu.Cycles()
+	for ; i-r.base < len(r.events); i++ {
+		e := &r.events[i-r.base]
+		if e.cycle > now {
+			break
+		}
+		r.apply(e)
+	}
+	return i
+}
+
+// truncate discards the checkpoints and events after journal position
+// i, which the caller will make again.
+func (r *Recorder) truncate(i int) {
+	now := r.cpu.Cycles()
+	for r.n > 0 {
+		cp := r.at(r.n - 1)
+		if cp.cycle <= now && cp.event <= i {
+			break
+		}
+		r.n--
+	}
+	r.events = r.events[:i-r.base]
+	r.next = now
+	if r.n > 0 {
+		r.next = r.at(r.n-1).cycle + r.interval
+	}
+}
@@ -0,0 +1,190 @@
+package rewind
+
+import (
+	"errors"
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+type ram [65536]uint8
+
+func (r *ram) Fetch(addr uint16) uint8      { return r[addr] }
+func (r *ram) Read(addr uint16) uint8       { return r[addr] }
+func (r *ram) Write(addr uint16, val uint8) { r[addr] = val }
+func (r *ram) In(port uint16) uint8         { return 0xFF }
+func (r *ram) Out(port uint16, val uint8)   {}
+
+func (r *ram) AppendState(b []byte) []byte { return append(b, r[:]...) }
+
+func (r *ram) LoadState(data []byte) error {
+	copy(r[:], data)
+	return nil
+}
+
+// program counts into memory from 8000h while an IM 1 handler counts
+// interrupts at 9000h.
+var program = map[uint16][]uint8{
+	0x0000: {0xED, 0x56, 0x21, 0x00, 0x80, 0xFB, 0x34, 0x23, 0x18, 0xFC},
+	0x0038: {0x3C, 0x32, 0x00, 0x90, 0xFB, 0xED, 0x4D},
+}
+
+func newSystem() (*z80.CPU, *ram) {
+	bus := &ram{}
+	for addr, code := range program {
+		copy(bus[addr:], code)
+	}
+	return z80.New(bus), bus
+}
+
+type state struct {
+	regs   z80.Registers
+	cycles uint64
+	mem    ram
+}
+
+func capture(cpu *z80.CPU, bus *ram) state {
+	return state{cpu.Registers(), cpu.Cycles(), *bus}
+}
+
+// frame runs one frame, pulsing INT part-way through.
+func frame(cpu *z80.CPU, rec *Recorder) {
+	cpu.RunCycles(1000)
+	rec.INT(true, 0xFF)
+	cpu.RunCycles(50)
+	rec.INT(false, 0xFF)
+	cpu.RunCycles(950)
+}
+
+func TestRewind_ReproducesHistory(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 2000, 8)
+	var history []state
+	for range 10 {
+		history = append(history, capture(cpu, bus))
+		rec.Record()
+		frame(cpu, rec)
+	}
+	if bus[0x9000] < 10 {
+		t.Fatalf("interrupt count = %d, want at least 10", bus[0x9000])
+	}
+
+	// Rewind to each frame start still held, newest first.
+	for i := len(history) - 1; i >= 2; i-- {
+		want := history[i]
+		if err := rec.Rewind(want.cycles); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if got := capture(cpu, bus); got != want {
+			t.Fatalf("frame %d: got %+v at %d, want %+v at %d", i, got.regs, got.cycles, want.regs, want.cycles)
+		}
+	}
+	if err := rec.Rewind(history[1].cycles); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("rewind past oldest: err = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestRewind_MidFrame(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 2000, 4)
+	rec.Record()
+	cpu.RunCycles(1000)
+	rec.INT(true, 0xFF)
+	cpu.RunCycles(30)
+	want := capture(cpu, bus)
+	cpu.RunCycles(20)
+	rec.INT(false, 0xFF)
+	cpu.RunCycles(2000)
+	rec.Record()
+
+	if err := rec.Rewind(want.cycles); err != nil {
+		t.Fatal(err)
+	}
+	if got := capture(cpu, bus); got != want {
+		t.Fatalf("got %+v at %d, want %+v at %d", got.regs, got.cycles, want.regs, want.cycles)
+	}
+}
+
+func TestRewind_ContinuesRecording(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 2000, 8)
+	rec.Record()
+	frame(cpu, rec)
+	rec.Record()
+	frame(cpu, rec)
+	rec.Record()
+	mark := cpu.Cycles()
+	frame(cpu, rec)
+	want := capture(cpu, bus)
+
+	// Go back, run the same frame again and the result is the same.
+	if err := rec.Rewind(mark); err != nil {
+		t.Fatal(err)
+	}
+	frame(cpu, rec)
+	if got := capture(cpu, bus); got != want {
+		t.Fatalf("after re-running: got %+v, want %+v", got.regs, want.regs)
+	}
+	rec.Record()
+	if err := rec.Rewind(mark); err != nil {
+		t.Fatal(err)
+	}
+	if got := cpu.Cycles(); got != mark {
+		t.Errorf("cycles = %d, want %d", got, mark)
+	}
+}
+
+func TestRewind_Future(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 100, 4)
+	rec.Record()
+	if err := rec.Rewind(cpu.Cycles() + 1); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("err = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestRewind_TranslationCache(t *testing.T) {
+	bus := &ram{}
+	// NOP, patched to INC A; LD HL,0000h; LD (HL),3Ch; JR 0000h
+	copy(bus[:], []uint8{0x00, 0x21, 0x00, 0x00, 0x36, 0x3C, 0x18, 0xF8})
+	cpu := z80.New(bus)
+	if err := cpu.MapMemory(0, bus[:], z80.PageRead|z80.PageWrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.SetTranslationCache(true); err != nil {
+		t.Fatal(err)
+	}
+	regs := cpu.Registers()
+	regs.AF = 0x0000
+	cpu.SetState(regs)
+	rec := New(cpu, bus, 100, 4)
+	rec.Record()
+	for range 5 {
+		cpu.Step()
+	}
+	if a := cpu.Registers().AF >> 8; a != 0x01 {
+		t.Fatalf("A = %02x before rewind, want 01", a)
+	}
+
+	if err := rec.Rewind(0); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Step()
+	if a := cpu.Registers().AF >> 8; a != 0x00 {
+		t.Errorf("A = %02x after rewind, want 00 (stale translation run)", a)
+	}
+}
+
+func TestClear(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 100, 4)
+	rec.Record()
+	frame(cpu, rec)
+	rec.Clear()
+	if _, ok := rec.Oldest(); ok {
+		t.Error("Oldest reports a checkpoint after Clear")
+	}
+	if err := rec.Rewind(0); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("err = %v, want ErrNotRecorded", err)
+	}
+}
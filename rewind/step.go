@@ -0,0 +1,85 @@
+package rewind
+
+import "errors"
+
+// ErrNotFound is returned by RunBackTo when the address was not reached
+// in the recorded history.
+var ErrNotFound = errors.New("rewind: address not reached in history")
+
+// StepBack returns the CPU, and the bus state, to the previous
+// instruction boundary: the state just before the last Step ran, with
+// the interrupts raised before it applied, so that Step runs it again.
+// The history after that point is discarded, as with Rewind. It returns
+// ErrNotRecorded if the previous boundary is before the oldest
+// checkpoint.
+func (r *Recorder) StepBack() error {
+	err := r.backTo(func() bool { return true })
+	if err == ErrNotFound {
+		err = ErrNotRecorded
+	}
+	return err
+}
+
+// RunBackTo returns the CPU, and the bus state, to the last time it was
+// about to execute the instruction at pc, as StepBack does for the
+// previous instruction. If that did not happen since the oldest
+// checkpoint, it returns ErrNotFound and leaves the CPU where it was.
+func (r *Recorder) RunBackTo(pc uint16) error {
+	return r.backTo(func() bool { return r.cpu.Registers().PC == pc })
+}
+
+// backTo goes back to the latest instruction boundary before the
+// present at which match reports true. The checkpoint intervals are
+// searched newest first, each by running forward from its checkpoint.
+func (r *Recorder) backTo(match func() bool) error {
+	now := r.cpu.Cycles()
+	if err := r.save(&r.scratch); err != nil {
+		return err
+	}
+	for k := r.find(now); k >= 0; k-- {
+		end := now
+		if k+1 < r.n {
+			end = min(end, r.at(k+1).cycle)
+		}
+		cycle, ok, err := r.scan(k, end, match)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		i, err := r.restore(cycle)
+		if err != nil {
+			return err
+		}
+		i = r.forward(i, func() bool { return r.cpu.Cycles() < cycle })
+		r.truncate(r.replay(i))
+		return nil
+	}
+	if err := r.load(&r.scratch); err != nil {
+		return err
+	}
+	return ErrNotFound
+}
+
+// scan runs forward from checkpoint k to cycle end and returns the last
+// instruction boundary before end at which match reported true, once
+// the journaled interrupts for that boundary were applied.
+func (r *Recorder) scan(k int, end uint64, match func() bool) (uint64, bool, error) {
+	cp := r.at(k)
+	if err := r.load(cp); err != nil {
+		return 0, false, err
+	}
+	r.replaying = true
+	defer func() { r.replaying = false }()
+	var found uint64
+	ok := false
+	for i := cp.event; r.cpu.Cycles() < end; {
+		i = r.replay(i)
+		if match() {
+			found, ok = r.cpu.Cycles(), true
+		}
+		r.cpu.Step()
+	}
+	return found, ok, nil
+}
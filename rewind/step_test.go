@@ -0,0 +1,90 @@
+package rewind
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStepBack(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 500, 16)
+	var history []state
+	for n := range 300 {
+		switch n {
+		case 100:
+			rec.INT(true, 0xFF)
+		case 105:
+			rec.INT(false, 0xFF)
+		}
+		rec.Record()
+		history = append(history, capture(cpu, bus))
+		cpu.Step()
+	}
+
+	for j := len(history) - 1; j >= 0; j-- {
+		if err := rec.StepBack(); err != nil {
+			t.Fatalf("step %d: %v", j, err)
+		}
+		if got := capture(cpu, bus); got != history[j] {
+			t.Fatalf("step %d: got %+v at %d, want %+v at %d", j, got.regs, got.cycles, history[j].regs, history[j].cycles)
+		}
+	}
+	if err := rec.StepBack(); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("step back past oldest: err = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestStepBack_ThenForward(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 500, 16)
+	rec.Record()
+	cpu.RunCycles(1000)
+	rec.INT(true, 0xFF)
+	cpu.Step() // takes the interrupt
+	want := capture(cpu, bus)
+
+	if err := rec.StepBack(); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Step()
+	if got := capture(cpu, bus); got != want {
+		t.Fatalf("got %+v, want %+v", got.regs, want.regs)
+	}
+}
+
+func TestRunBackTo(t *testing.T) {
+	cpu, bus := newSystem()
+	rec := New(cpu, bus, 700, 16)
+	var last state
+	for n := range 400 {
+		switch n % 100 {
+		case 0:
+			rec.INT(true, 0xFF)
+		case 5:
+			rec.INT(false, 0xFF)
+		}
+		rec.Record()
+		if cpu.Registers().PC == 0x0038 {
+			last = capture(cpu, bus)
+		}
+		cpu.Step()
+	}
+	if last.cycles == 0 {
+		t.Fatal("handler never ran")
+	}
+
+	if err := rec.RunBackTo(0x0038); err != nil {
+		t.Fatal(err)
+	}
+	if got := capture(cpu, bus); got != last {
+		t.Fatalf("got %+v at %d, want %+v at %d", got.regs, got.cycles, last.regs, last.cycles)
+	}
+
+	before := capture(cpu, bus)
+	if err := rec.RunBackTo(0x1234); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if got := capture(cpu, bus); got != before {
+		t.Errorf("state changed after failed search: %+v at %d", got.regs, got.cycles)
+	}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+// CPUs sharing memory see each other's writes only as closely as the
+// scheduler interleaves them. By default each CPU runs straight to the
+// next event; a quantum bounds how far any CPU runs before the others
+// catch up, and Sync gives an exact sync point around a mailbox access.
+
+// SetQuantum sets the longest time, in master ticks, that a CPU runs
+// before the CPU furthest behind gets its turn. CPUs then stay within
+// about one quantum of each other. Zero, the default, runs each CPU
+// straight to the next event.
+func (s *Scheduler) SetQuantum(ticks uint64) {
+	s.quantum = ticks
+}
+
+// Boost uses quantum instead of the SetQuantum value for the next
+// duration master ticks, for example while two CPUs exchange a command
+// through shared memory.
+func (s *Scheduler) Boost(quantum, duration uint64) {
+	s.boostQ = quantum
+	s.boostUntil = s.now + duration
+	if s.running != nil {
+		s.boostUntil = s.running.time() + duration
+	}
+}
+
+// quantumAt returns the quantum in force at master time t.
+func (s *Scheduler) quantumAt(t uint64) uint64 {
+	if t < s.boostUntil && s.boostQ > 0 && (s.quantum == 0 || s.boostQ < s.quantum) {
+		return s.boostQ
+	}
+	return s.quantum
+}
+
+// Sync marks a sync point, typically called from a Bus method when a
+// CPU touches shared memory. The running CPU stops after its current
+// instruction, and every CPU behind it runs up to that point before any
+// CPU goes further. CPUs already ahead of it are not affected; keep them
+// close with a quantum. Outside a CPU run Sync does nothing.
+func (s *Scheduler) Sync() {
+	if s.running == nil {
+		return
+	}
+	s.syncReq = true
+	s.running.cpu.SetNextEvent(0)
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import "testing"
+
+// maxLead runs two NOP CPUs for ticks and returns the furthest the first
+// got ahead of the second at any opcode fetch.
+func maxLead(s *Scheduler, ticks uint64) uint64 {
+	a, busA := newNOPCPU()
+	b, _ := newNOPCPU()
+	s.AddCPU(a, 1)
+	s.AddCPU(b, 1)
+	var lead uint64
+	busA.onFetch = func(uint16) {
+		if a.Cycles() > b.Cycles() {
+			lead = max(lead, a.Cycles()-b.Cycles())
+		}
+	}
+	s.Run(ticks)
+	return lead
+}
+
+func TestQuantum_BoundsLead(t *testing.T) {
+	if lead := maxLead(New(), 1000); lead < 900 {
+		t.Errorf("without quantum lead = %d, want a full run ahead", lead)
+	}
+	s := New()
+	s.SetQuantum(40)
+	if lead := maxLead(s, 1000); lead > 44 {
+		t.Errorf("with quantum 40 lead = %d, want at most 44", lead)
+	}
+}
+
+func TestBoost_TemporarilyTightens(t *testing.T) {
+	s := New()
+	s.SetQuantum(400)
+	s.Boost(8, 200)
+	a, busA := newNOPCPU()
+	b, _ := newNOPCPU()
+	s.AddCPU(a, 1)
+	s.AddCPU(b, 1)
+	var early, late uint64
+	busA.onFetch = func(uint16) {
+		if a.Cycles() <= b.Cycles() {
+			return
+		}
+		if d := a.Cycles() - b.Cycles(); a.Cycles() < 200 {
+			early = max(early, d)
+		} else {
+			late = max(late, d)
+		}
+	}
+	s.Run(2000)
+	if early > 12 {
+		t.Errorf("lead during boost = %d, want at most 12", early)
+	}
+	if late < 100 {
+		t.Errorf("lead after boost = %d, want the normal quantum", late)
+	}
+}
+
+func TestSync_OthersCatchUp(t *testing.T) {
+	s := New()
+	a, busA := newNOPCPU()
+	b, _ := newNOPCPU()
+	// A: 0000 LD (8000h),A then NOPs.
+	copy(busA.mem[:], []uint8{0x32, 0x00, 0x80})
+	s.AddCPU(a, 1)
+	s.AddCPU(b, 1)
+	var wrote bool
+	var seen uint64
+	busA.onWrite = func(uint16, uint8) {
+		wrote = true
+		s.Sync()
+	}
+	busA.onFetch = func(addr uint16) {
+		if wrote && addr == 0x0003 {
+			seen = b.Cycles()
+		}
+	}
+	s.Run(1000)
+	// The store ends at cycle 13; B must reach it before A's next fetch.
+	if seen < 13 {
+		t.Errorf("B at cycle %d when A resumed, want at least 13", seen)
+	}
+	if a.Cycles() < 1000 || b.Cycles() < 1000 {
+		t.Errorf("cycles = %d, %d, want both to reach 1000", a.Cycles(), b.Cycles())
+	}
+}
+
+func TestSync_OutsideRunIsNoOp(t *testing.T) {
+	s := New()
+	cpu, _ := newNOPCPU()
+	s.AddCPU(cpu, 1)
+	s.Sync()
+	s.Run(100)
+	if cpu.Cycles() != 100 {
+		t.Errorf("cycles = %d, want 100", cpu.Cycles())
+	}
+}
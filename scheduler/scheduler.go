@@ -0,0 +1,307 @@
+// Package scheduler drives one or more Z80 CPUs and the devices around
+// them from a shared timeline.
+//
+// Time is counted in master clock ticks. Each CPU runs at the master
+// clock divided by an integer, so systems with several CPUs at different
+// speeds (for example a main Z80 and a sound Z80) share one timeline.
+// Devices schedule callbacks at absolute times, either in master ticks or
+// in a CPU's own T-states as returned by CPU.Cycles. The scheduler runs
+// every CPU up to the next event, then runs the event, which may raise
+// INT or NMI on any CPU or schedule further events.
+package scheduler
+
+import (
+	"container/heap"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// maxSlice bounds a single RunCycles call so the cycle count fits an int.
+const maxSlice = 1 << 30
+
+// Event is a callback scheduled at an absolute master time.
+type Event struct {
+	at    uint64
+	seq   uint64 // insertion order, to run same-time events in order
+	fn    func()
+	index int // position in the heap, or -1 when not scheduled
+}
+
+// Time returns the master time the event is scheduled for.
+func (e *Event) Time() uint64 {
+	return e.at
+}
+
+// Pending reports whether the event is still waiting to run.
+func (e *Event) Pending() bool {
+	return e.index >= 0
+}
+
+// eventQueue is a min-heap of events ordered by time, then insertion.
+type eventQueue []*Event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*Event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
+
+// clockedCPU is a CPU on the timeline. Its master time is
+// origin + Cycles()*div. The origin is negative when the CPU joined with
+// more cycles, in master ticks, than the master time.
+type clockedCPU struct {
+	cpu    *z80.CPU
+	div    uint64
+	origin int64
+}
+
+func (c *clockedCPU) time() uint64 {
+	return c.timeOf(c.cpu.Cycles())
+}
+
+// timeOf returns the master time of the given cycle count, or 0 for a
+// cycle count from before the timeline began.
+func (c *clockedCPU) timeOf(cycle uint64) uint64 {
+	return uint64(max(c.origin+int64(cycle*c.div), 0))
+}
+
+// anchor makes the CPU's current cycle count fall at master time now.
+func (c *clockedCPU) anchor(now uint64) {
+	c.origin = int64(now) - int64(c.cpu.Cycles()*c.div)
+}
+
+// Scheduler runs CPUs and events on a shared master timeline.
+type Scheduler struct {
+	now     uint64
+	seq     uint64
+	cpus    []*clockedCPU
+	events  eventQueue
+	limit   uint64      // time of the next event, or the end of the run
+	running *clockedCPU // CPU currently inside RunCycles, or nil
+	target  uint64      // time the running CPU is running toward
+
+	// Interleaving; see interleave.go.
+	quantum    uint64
+	boostQ     uint64
+	boostUntil uint64
+	syncReq    bool   // the running CPU asked for a sync point
+	barrier    uint64 // sync point the other CPUs are catching up to
+	hasBarrier bool
+}
+
+// New creates a scheduler at master time 0 with no CPUs.
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// AddCPU adds cpu to the timeline, running one T-state every div master
+// ticks. The CPU's current cycle count is taken to be the present time.
+// If a CPU is Reset, which restarts its cycle count, call Resync.
+//
+// The scheduler uses the CPU's event target (SetNextEvent) to stop it
+// at event boundaries; do not set one yourself on a scheduled CPU.
+func (s *Scheduler) AddCPU(cpu *z80.CPU, div uint64) {
+	if div == 0 {
+		div = 1
+	}
+	c := &clockedCPU{cpu: cpu, div: div}
+	c.anchor(s.now)
+	s.cpus = append(s.cpus, c)
+}
+
+// Resync re-anchors cpu's cycle count to the present time, for use after
+// CPU.Reset or CPU.Deserialize.
+func (s *Scheduler) Resync(cpu *z80.CPU) {
+	if c := s.find(cpu); c != nil {
+		c.anchor(s.now)
+	}
+}
+
+func (s *Scheduler) find(cpu *z80.CPU) *clockedCPU {
+	for _, c := range s.cpus {
+		if c.cpu == cpu {
+			return c
+		}
+	}
+	return nil
+}
+
+// Now returns the current master time: the time of the event being run,
+// or the end of the last RunUntil.
+func (s *Scheduler) Now() uint64 {
+	return s.now
+}
+
+// TimeOf returns the master time at which cpu reaches the given absolute
+// cycle count, or 0 for a count the CPU had passed before master time 0.
+// cpu must have been added with AddCPU.
+func (s *Scheduler) TimeOf(cpu *z80.CPU, cycle uint64) uint64 {
+	c := s.find(cpu)
+	if c == nil {
+		panic("scheduler: CPU not added")
+	}
+	return c.timeOf(cycle)
+}
+
+// CycleOf returns cpu's cycle count at master time t, rounded up to the
+// next T-state. It converts an event's time for CPU.INTAt and NMIAt.
+// cpu must have been added with AddCPU.
+func (s *Scheduler) CycleOf(cpu *z80.CPU, t uint64) uint64 {
+	c := s.find(cpu)
+	if c == nil {
+		panic("scheduler: CPU not added")
+	}
+	return s.cycleAt(c, t)
+}
+
+// Schedule runs fn at master time at. Events at the same time run in the
+// order they were scheduled; an event in the past runs as soon as
+// possible. It may be called from events and from Bus methods while a
+// CPU is running; a CPU that is running stops at the new event if it
+// comes before the one it was running toward.
+func (s *Scheduler) Schedule(at uint64, fn func()) *Event {
+	e := &Event{at: at, seq: s.seq, fn: fn}
+	s.seq++
+	heap.Push(&s.events, e)
+	if at < s.limit {
+		s.limit = max(at, s.now)
+		if s.running != nil && s.limit < s.target {
+			s.target = s.limit
+			s.running.cpu.SetNextEvent(s.cycleAt(s.running, s.target))
+		}
+	}
+	return e
+}
+
+// ScheduleCycles runs fn when cpu's cycle counter reaches cycle, an
+// absolute value as returned by CPU.Cycles.
+func (s *Scheduler) ScheduleCycles(cpu *z80.CPU, cycle uint64, fn func()) *Event {
+	return s.Schedule(s.TimeOf(cpu, cycle), fn)
+}
+
+// Cancel removes e if it has not run yet.
+func (s *Scheduler) Cancel(e *Event) {
+	if e.index >= 0 {
+		heap.Remove(&s.events, e.index)
+	}
+}
+
+// Run advances the timeline by ticks master ticks.
+func (s *Scheduler) Run(ticks uint64) {
+	s.RunUntil(s.now + ticks)
+}
+
+// RunUntil runs every CPU and event up to master time end. CPUs run
+// until they reach the next event, finishing their current instruction,
+// so a CPU may end up to one instruction past it. Events then run at
+// their scheduled time, after every CPU has caught up with it.
+//
+// Between events the CPU furthest behind runs next, for at most one
+// quantum (see SetQuantum); with no quantum each CPU runs straight to
+// the next event, in the order added.
+func (s *Scheduler) RunUntil(end uint64) {
+	for {
+		s.limit = end
+		if len(s.events) > 0 && s.events[0].at < s.limit {
+			s.limit = max(s.events[0].at, s.now)
+		}
+		for {
+			c, target := s.next()
+			if c == nil {
+				break
+			}
+			s.runCPU(c, target)
+			if s.syncReq {
+				s.syncReq = false
+				s.barrier = c.time()
+				s.hasBarrier = true
+			}
+		}
+		s.now = s.limit
+		for len(s.events) > 0 && s.events[0].at <= s.now {
+			e := heap.Pop(&s.events).(*Event)
+			e.fn()
+		}
+		if s.now >= end {
+			return
+		}
+	}
+}
+
+// next picks the CPU to run and the time to run it to: the CPU furthest
+// behind, up to the next event, the pending sync point, or the end of
+// its quantum. It returns nil when every CPU has reached the next event.
+func (s *Scheduler) next() (*clockedCPU, uint64) {
+	for {
+		end := s.limit
+		if s.hasBarrier {
+			end = min(end, s.barrier)
+		}
+		var pick *clockedCPU
+		for _, c := range s.cpus {
+			if t := c.time(); t < end && (pick == nil || t < pick.time()) {
+				pick = c
+			}
+		}
+		if pick != nil {
+			if !s.hasBarrier {
+				if q := s.quantumAt(pick.time()); q > 0 {
+					end = min(end, pick.time()+q)
+				}
+			}
+			return pick, end
+		}
+		if !s.hasBarrier {
+			return nil, 0
+		}
+		s.hasBarrier = false
+	}
+}
+
+// runCPU runs c until its master time reaches target, which events
+// scheduled meanwhile may lower, or until it asks for a sync point.
+func (s *Scheduler) runCPU(c *clockedCPU, target uint64) {
+	s.running = c
+	s.target = target
+	for !s.syncReq && c.time() < s.target {
+		cycle := s.cycleAt(c, s.target)
+		c.cpu.SetNextEvent(cycle)
+		c.cpu.RunCycles(int(min(cycle-c.cpu.Cycles(), maxSlice)))
+	}
+	c.cpu.ClearNextEvent()
+	s.running = nil
+}
+
+// cycleAt returns the first cycle count at which c's master time is at
+// or after t.
+func (s *Scheduler) cycleAt(c *clockedCPU, t uint64) uint64 {
+	d := int64(t) - c.origin
+	if d <= 0 {
+		return 0
+	}
+	return (uint64(d) + c.div - 1) / c.div
+}
@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// ram is a flat 64K bus with optional fetch and write hooks.
+type ram struct {
+	mem     [65536]uint8
+	onFetch func(addr uint16)
+	onWrite func(addr uint16, val uint8)
+}
+
+func (r *ram) Fetch(addr uint16) uint8 {
+	if r.onFetch != nil {
+		r.onFetch(addr)
+	}
+	return r.mem[addr]
+}
+func (r *ram) Read(addr uint16) uint8 { return r.mem[addr] }
+func (r *ram) Write(addr uint16, val uint8) {
+	r.mem[addr] = val
+	if r.onWrite != nil {
+		r.onWrite(addr, val)
+	}
+}
+func (r *ram) In(port uint16) uint8       { return 0xFF }
+func (r *ram) Out(port uint16, val uint8) {}
+
+// newNOPCPU returns a CPU running a NOP sled: 4 T-states per instruction.
+func newNOPCPU() (*z80.CPU, *ram) {
+	bus := &ram{}
+	return z80.New(bus), bus
+}
+
+func TestRunUntil_AdvancesCPUs(t *testing.T) {
+	main, _ := newNOPCPU()
+	sound, _ := newNOPCPU()
+	s := New()
+	s.AddCPU(main, 1)
+	s.AddCPU(sound, 2)
+	s.RunUntil(1000)
+	if s.Now() != 1000 {
+		t.Errorf("Now = %d, want 1000", s.Now())
+	}
+	if main.Cycles() != 1000 {
+		t.Errorf("main cycles = %d, want 1000", main.Cycles())
+	}
+	if sound.Cycles() != 500 {
+		t.Errorf("sound cycles = %d, want 500", sound.Cycles())
+	}
+}
+
+func TestEvents_RunInOrderAtTheirTime(t *testing.T) {
+	cpu, _ := newNOPCPU()
+	s := New()
+	s.AddCPU(cpu, 3)
+	var got []uint64
+	var cycles []uint64
+	record := func() {
+		got = append(got, s.Now())
+		cycles = append(cycles, cpu.Cycles())
+	}
+	s.Schedule(300, record)
+	s.Schedule(120, record)
+	s.ScheduleCycles(cpu, 60, record) // master 180
+	s.Run(1000)
+	want := []uint64{120, 180, 300}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d ran at %d, want %d", i, got[i], want[i])
+		}
+		// The CPU has caught up, finishing at most one NOP past the event.
+		lo := (want[i] + 2) / 3
+		if cycles[i] < lo || cycles[i] >= lo+4 {
+			t.Errorf("event %d: CPU at cycle %d, want %d..%d", i, cycles[i], lo, lo+3)
+		}
+	}
+}
+
+func TestEvents_SameTimeKeepsScheduleOrder(t *testing.T) {
+	s := New()
+	var order []int
+	for i := range 3 {
+		s.Schedule(50, func() { order = append(order, i) })
+	}
+	s.Run(100)
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("order = %v, want [0 1 2]", order)
+	}
+}
+
+func TestEvents_RaiseInterrupt(t *testing.T) {
+	cpu, bus := newNOPCPU()
+	bus.mem[0x0000] = 0xFB // EI
+	bus.mem[0x0001] = 0xED // IM 1
+	bus.mem[0x0002] = 0x56
+	bus.mem[0x0038] = 0x76 // HALT
+	s := New()
+	s.AddCPU(cpu, 1)
+	s.Schedule(100, func() { cpu.INT(true, 0xFF) })
+	s.Run(99)
+	if cpu.Halted() {
+		t.Fatal("interrupt taken before its event")
+	}
+	s.Run(30)
+	if !cpu.Halted() {
+		t.Error("interrupt not taken after its event")
+	}
+}
+
+func TestEvents_Repeating(t *testing.T) {
+	s := New()
+	n := 0
+	var tick func()
+	tick = func() {
+		n++
+		s.Schedule(s.Now()+100, tick)
+	}
+	s.Schedule(100, tick)
+	s.Run(1000)
+	if n != 10 {
+		t.Errorf("ticks = %d, want 10", n)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s := New()
+	ran := false
+	e := s.Schedule(10, func() { ran = true })
+	s.Cancel(e)
+	s.Run(100)
+	if ran || e.Pending() {
+		t.Error("cancelled event ran")
+	}
+}
+
+func TestScheduleFromBusStopsRunningCPU(t *testing.T) {
+	cpu, bus := newNOPCPU()
+	// LD (8000h),A at 0000, then NOPs.
+	copy(bus.mem[:], []uint8{0x32, 0x00, 0x80})
+	s := New()
+	s.AddCPU(cpu, 1)
+	var at uint64
+	bus.onWrite = func(addr uint16, val uint8) {
+		s.Schedule(cpu.Cycles()+20, func() { at = cpu.Cycles() })
+	}
+	s.Run(10000)
+	// The write lands during the 13 T-state store; the event is due
+	// 20 T-states later and must not wait for the end of the run.
+	if at < 20 || at > 40 {
+		t.Errorf("event saw CPU at cycle %d, want about 33", at)
+	}
+}
+
+func TestResync(t *testing.T) {
+	cpu, _ := newNOPCPU()
+	s := New()
+	s.AddCPU(cpu, 1)
+	s.Run(100)
+	cpu.Reset()
+	s.Resync(cpu)
+	if got := s.TimeOf(cpu, 0); got != 100 {
+		t.Errorf("TimeOf(0) after resync = %d, want 100", got)
+	}
+	s.Run(100)
+	if cpu.Cycles() != 100 {
+		t.Errorf("cycles = %d, want 100", cpu.Cycles())
+	}
+}
+
+func TestAddCPU_AheadOfMasterTime(t *testing.T) {
+	cpu, _ := newNOPCPU()
+	cpu.SetCycles(1_000_000)
+	s := New()
+	s.Run(100)
+	s.AddCPU(cpu, 3)
+	if got := s.TimeOf(cpu, 1_000_000); got != 100 {
+		t.Errorf("TimeOf(join) = %d, want 100", got)
+	}
+	if got := s.CycleOf(cpu, 100); got != 1_000_000 {
+		t.Errorf("CycleOf(100) = %d, want 1000000", got)
+	}
+	if got := s.TimeOf(cpu, 0); got != 0 {
+		t.Errorf("TimeOf(0) = %d, want 0 for a cycle before the timeline", got)
+	}
+
+	var at uint64
+	s.ScheduleCycles(cpu, 1_000_040, func() { at = s.Now() })
+	s.Run(300)
+	if at != 220 {
+		t.Errorf("event ran at %d, want 220", at)
+	}
+	if cpu.Cycles() != 1_000_100 {
+		t.Errorf("cycles = %d, want 1000100", cpu.Cycles())
+	}
+}
+
+func TestCycleOf(t *testing.T) {
+	cpu, _ := newNOPCPU()
+	s := New()
+	s.Run(10)
+	s.AddCPU(cpu, 2)
+	if got := s.CycleOf(cpu, 17); got != 4 {
+		t.Errorf("CycleOf(17) = %d, want 4", got)
+	}
+	if got := s.CycleOf(cpu, s.TimeOf(cpu, 9)); got != 9 {
+		t.Errorf("CycleOf(TimeOf(9)) = %d, want 9", got)
+	}
+}
@@ -5,60 +5,207 @@ import (
 	"errors"
 )
 
-const cpuSerializeVersion = 1
+// cpuSerializeVersion is the save-state format version. Version 1 was a
+// fixed 47-byte layout; version 2 is a header followed by tagged chunks.
+// Adding a chunk, or fields at the end of a chunk, does not change the
+// version: readers skip unknown chunks and default missing fields.
+const cpuSerializeVersion = 2
 
-// SerializeSize is the number of bytes needed to serialize the CPU state.
-const SerializeSize = 47
+// SerializeSize is the buffer size that always holds a serialized CPU.
+// It leaves room for the format to grow, so the state actually written
+// is usually shorter.
+const SerializeSize = 256
 
-// Serialize writes the complete CPU state into buf in a compact little-endian
-// binary format. Returns an error if len(buf) < SerializeSize. Bus
+// serializeV1Size is the length of a version 1 save state.
+const serializeV1Size = 47
+
+// Errors returned when saving and restoring CPU state.
+var (
+	// ErrNewerSaveState is returned for a save state written by a newer
+	// version of this package.
+	ErrNewerSaveState = errors.New("z80: save state is from a newer version")
+	// ErrShortBuffer is returned when a buffer is smaller than the state.
+	ErrShortBuffer = errors.New("z80: save state buffer too small")
+	// ErrCorruptSaveState is returned for a save state that cannot be
+	// decoded.
+	ErrCorruptSaveState = errors.New("z80: save state is corrupt")
+	// ErrVariantMismatch is returned for a save state taken from a CPU of
+	// a different variant.
+	ErrVariantMismatch = errors.New("z80: save state is for a different variant")
+	// ErrMidInstruction is returned when saving while Tick has left an
+	// instruction part-way.
+	ErrMidInstruction = errors.New("z80: save state in the middle of an instruction")
+)
+
+// Chunk tags. Each chunk is a 4-byte tag, a 16-bit little-endian length
+// and that many bytes of data.
+var (
+	tagVariant = [4]byte{'V', 'R', 'N', 'T'}
+	tagRegs    = [4]byte{'R', 'E', 'G', 'S'}
+	tagTime    = [4]byte{'T', 'I', 'M', 'E'}
+	tagIntr    = [4]byte{'I', 'N', 'T', 'R'}
+	tagEZ80    = [4]byte{'E', 'Z', '8', '0'}
+	tagR800    = [4]byte{'R', '8', '0', '0'}
+)
+
+// Serialize writes the complete CPU state into buf in a compact,
+// versioned, little-endian format: a one-byte version and a 16-bit
+// length, followed by tagged chunks for the registers, cycle counters,
+// interrupt state and any variant state. The cycle counters include the
+// event target set with SetNextEvent, so a restored CPU stops RunCycles
+// where the saved one would have. Returns an error if len(buf) <
+// SerializeSize or if Tick has left an instruction part-way. Bus
 // references are not included — the caller handles memory and I/O state
 // separately.
 func (c *CPU) Serialize(buf []byte) error {
 	if len(buf) < SerializeSize {
-		return errors.New("z80: serialize buffer too small")
+		return ErrShortBuffer
+	}
+	if c.MidInstruction() {
+		return ErrMidInstruction
+	}
+
+	w := stateWriter{buf: buf, n: 3}
+	w.begin(tagVariant)
+	w.u8(uint8(c.variant))
+	w.end()
+
+	w.begin(tagRegs)
+	for _, r := range []uint16{
+		c.reg.AF, c.reg.BC, c.reg.DE, c.reg.HL,
+		c.reg.AF_, c.reg.BC_, c.reg.DE_, c.reg.HL_,
+		c.reg.IX, c.reg.IY, c.reg.SP, c.reg.PC,
+	} {
+		w.u16(r)
+	}
+	w.u8(c.reg.I)
+	w.u8(c.reg.R)
+	w.bool(c.reg.IFF1)
+	w.bool(c.reg.IFF2)
+	w.u8(c.reg.IM)
+	w.bool(c.reg.Halted)
+	w.u16(c.reg.WZ)
+	w.end()
+
+	w.begin(tagTime)
+	w.u64(c.cycles)
+	w.u32(uint32(int32(c.deficit)))
+	w.u64(c.nextEvent)
+	w.end()
+
+	w.begin(tagIntr)
+	w.bool(c.intLine)
+	w.u8(c.intData)
+	w.bool(c.nmiPending)
+	w.bool(c.afterEI)
+	w.bool(c.afterLDAIR)
+	w.u64(c.intAt)
+	w.u64(c.intEnd)
+	w.u64(c.nmiAt)
+	w.end()
+
+	if e := c.ez; e != nil {
+		w.begin(tagEZ80)
+		for _, r := range []uint8{e.BCU, e.DEU, e.HLU, e.BCU_, e.DEU_, e.HLU_, e.IXU, e.IYU} {
+			w.u8(r)
+		}
+		w.u32(e.SPL)
+		w.u8(e.PCU)
+		w.u8(e.MB)
+		w.bool(e.ADL)
+		w.bool(e.MADL)
+		w.end()
+	}
+
+	if r := c.r800; r != nil {
+		// The open DRAM page, 0xFFFF if none.
+		w.begin(tagR800)
+		w.u16(uint16(r.page))
+		w.end()
 	}
 
 	buf[0] = cpuSerializeVersion
-	binary.LittleEndian.PutUint16(buf[1:], c.reg.AF)
-	binary.LittleEndian.PutUint16(buf[3:], c.reg.BC)
-	binary.LittleEndian.PutUint16(buf[5:], c.reg.DE)
-	binary.LittleEndian.PutUint16(buf[7:], c.reg.HL)
-	binary.LittleEndian.PutUint16(buf[9:], c.reg.AF_)
-	binary.LittleEndian.PutUint16(buf[11:], c.reg.BC_)
-	binary.LittleEndian.PutUint16(buf[13:], c.reg.DE_)
-	binary.LittleEndian.PutUint16(buf[15:], c.reg.HL_)
-	binary.LittleEndian.PutUint16(buf[17:], c.reg.IX)
-	binary.LittleEndian.PutUint16(buf[19:], c.reg.IY)
-	binary.LittleEndian.PutUint16(buf[21:], c.reg.SP)
-	binary.LittleEndian.PutUint16(buf[23:], c.reg.PC)
-	buf[25] = c.reg.I
-	buf[26] = c.reg.R
-	buf[27] = boolByte(c.reg.IFF1)
-	buf[28] = boolByte(c.reg.IFF2)
-	buf[29] = c.reg.IM
-	buf[30] = boolByte(c.reg.Halted)
-	binary.LittleEndian.PutUint64(buf[31:], c.cycles)
-	binary.LittleEndian.PutUint32(buf[39:], uint32(int32(c.deficit)))
-	buf[43] = boolByte(c.intLine)
-	buf[44] = c.intData
-	buf[45] = boolByte(c.nmiPending)
-	buf[46] = boolByte(c.afterEI)
+	binary.LittleEndian.PutUint16(buf[1:], uint16(w.n))
 	return nil
 }
 
 // Deserialize restores the complete CPU state from buf, which must have been
-// produced by Serialize. Returns an error if the buffer is too small or was
-// produced by an incompatible version. Bus references are not modified — the
-// caller handles memory and I/O state separately.
+// produced by Serialize. Save states from earlier versions are accepted,
+// with state they did not record set as after Reset; one from a newer
+// version returns ErrNewerSaveState. Returns an error if the buffer is
+// too small or corrupt, or was saved from a different variant; a
+// version 2 state without its variant chunk is corrupt. Bus references
+// are not modified — the caller handles memory and I/O state
+// separately. An instruction left part-way by Tick is abandoned.
 func (c *CPU) Deserialize(buf []byte) error {
-	if len(buf) < SerializeSize {
-		return errors.New("z80: deserialize buffer too small")
+	if len(buf) < 3 {
+		return ErrShortBuffer
+	}
+	switch {
+	case buf[0] == 1:
+		if len(buf) < serializeV1Size {
+			return ErrShortBuffer
+		}
+		// Version 1 predates the variants: its states are all Z80.
+		if c.variant != VariantZ80 {
+			return ErrVariantMismatch
+		}
+	case buf[0] == 0:
+		return ErrCorruptSaveState
+	case buf[0] > cpuSerializeVersion:
+		return ErrNewerSaveState
+	}
+
+	var chunks []byte
+	if buf[0] != 1 {
+		n := int(binary.LittleEndian.Uint16(buf[1:]))
+		if n < 3 || n > len(buf) {
+			return ErrShortBuffer
+		}
+		chunks = buf[3:n]
+		if err := checkChunks(chunks); err != nil {
+			return err
+		}
+		v, ok := findChunk(chunks, tagVariant)
+		if _, regs := findChunk(chunks, tagRegs); !ok || len(v) < 1 || !regs {
+			return ErrCorruptSaveState
+		}
+		if Variant(v[0]) != c.variant {
+			return ErrVariantMismatch
+		}
 	}
-	if buf[0] != cpuSerializeVersion {
-		return errors.New("z80: unsupported serialize version")
+
+	if c.tick != nil {
+		c.tick.abort()
+	}
+	c.deserializeDefaults()
+	if buf[0] == 1 {
+		c.deserializeV1(buf)
+	} else {
+		c.deserializeChunks(chunks)
+	}
+	c.ixiyReg = &c.reg.HL
+	return nil
+}
+
+// deserializeDefaults sets the state a save state may not record to its
+// value after Reset.
+func (c *CPU) deserializeDefaults() {
+	c.afterLDAIR = false
+	c.nextEvent = noEvent
+	c.intAt = 0
+	c.intEnd = noEvent
+	c.nmiAt = 0
+	if c.ez != nil {
+		c.ez.reset()
+	}
+	if c.r800 != nil {
+		c.r800.reset()
 	}
+}
 
+// deserializeV1 loads the fixed version 1 layout.
+func (c *CPU) deserializeV1(buf []byte) {
 	c.reg.AF = binary.LittleEndian.Uint16(buf[1:])
 	c.reg.BC = binary.LittleEndian.Uint16(buf[3:])
 	c.reg.DE = binary.LittleEndian.Uint16(buf[5:])
@@ -83,11 +230,182 @@ func (c *CPU) Deserialize(buf []byte) error {
 	c.intData = buf[44]
 	c.nmiPending = buf[45] != 0
 	c.afterEI = buf[46] != 0
+}
 
-	c.ixiyReg = &c.reg.HL
+// deserializeChunks loads a version 2 save state. Fields missing from
+// the end of a chunk keep the values set by deserializeDefaults, or zero.
+func (c *CPU) deserializeChunks(chunks []byte) {
+	r := stateReader{b: chunk(chunks, tagRegs)}
+	for _, p := range []*uint16{
+		&c.reg.AF, &c.reg.BC, &c.reg.DE, &c.reg.HL,
+		&c.reg.AF_, &c.reg.BC_, &c.reg.DE_, &c.reg.HL_,
+		&c.reg.IX, &c.reg.IY, &c.reg.SP, &c.reg.PC,
+	} {
+		*p = r.u16(0)
+	}
+	c.reg.I = r.u8(0)
+	c.reg.R = r.u8(0)
+	c.reg.IFF1 = r.bool()
+	c.reg.IFF2 = r.bool()
+	c.reg.IM = r.u8(0)
+	c.reg.Halted = r.bool()
+	c.reg.WZ = r.u16(0)
+
+	r = stateReader{b: chunk(chunks, tagTime)}
+	c.cycles = r.u64(0)
+	c.deficit = int(int32(r.u32(0)))
+	c.nextEvent = r.u64(c.nextEvent)
+
+	r = stateReader{b: chunk(chunks, tagIntr)}
+	c.intLine = r.bool()
+	c.intData = r.u8(0xFF)
+	c.nmiPending = r.bool()
+	c.afterEI = r.bool()
+	c.afterLDAIR = r.bool()
+	c.intAt = r.u64(c.intAt)
+	c.intEnd = r.u64(c.intEnd)
+	c.nmiAt = r.u64(c.nmiAt)
+
+	if e := c.ez; e != nil {
+		if b, ok := findChunk(chunks, tagEZ80); ok {
+			r = stateReader{b: b}
+			for _, p := range []*uint8{&e.BCU, &e.DEU, &e.HLU, &e.BCU_, &e.DEU_, &e.HLU_, &e.IXU, &e.IYU} {
+				*p = r.u8(0)
+			}
+			e.SPL = r.u32(e.SPL) & 0xFFFFFF
+			e.PCU = r.u8(0)
+			e.MB = r.u8(0)
+			e.ADL = r.bool()
+			e.MADL = r.bool()
+		}
+	}
+
+	if b, ok := findChunk(chunks, tagR800); ok && c.r800 != nil {
+		r = stateReader{b: b}
+		if p := r.u16(0xFFFF); p <= 0xFF {
+			c.r800.page = int(p)
+		}
+	}
+}
+
+// checkChunks verifies that the chunk area of a save state is a whole
+// number of chunks.
+func checkChunks(b []byte) error {
+	for len(b) > 0 {
+		if len(b) < 6 {
+			return ErrCorruptSaveState
+		}
+		n := 6 + int(binary.LittleEndian.Uint16(b[4:]))
+		if len(b) < n {
+			return ErrCorruptSaveState
+		}
+		b = b[n:]
+	}
 	return nil
 }
 
+// findChunk returns the data of the first chunk with the given tag.
+// b must have passed checkChunks.
+func findChunk(b []byte, tag [4]byte) ([]byte, bool) {
+	for len(b) > 0 {
+		n := 6 + int(binary.LittleEndian.Uint16(b[4:]))
+		if [4]byte(b) == tag {
+			return b[6:n], true
+		}
+		b = b[n:]
+	}
+	return nil, false
+}
+
+// chunk is findChunk for chunks whose absence means all fields default.
+func chunk(b []byte, tag [4]byte) []byte {
+	data, _ := findChunk(b, tag)
+	return data
+}
+
+// stateWriter appends chunks to a save-state buffer.
+type stateWriter struct {
+	buf   []byte
+	n     int
+	start int // offset of the open chunk's header
+}
+
+func (w *stateWriter) begin(tag [4]byte) {
+	w.start = w.n
+	copy(w.buf[w.n:], tag[:])
+	w.n += 6
+}
+
+func (w *stateWriter) end() {
+	binary.LittleEndian.PutUint16(w.buf[w.start+4:], uint16(w.n-w.start-6))
+}
+
+func (w *stateWriter) u8(v uint8) {
+	w.buf[w.n] = v
+	w.n++
+}
+
+func (w *stateWriter) bool(v bool) { w.u8(boolByte(v)) }
+
+func (w *stateWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[w.n:], v)
+	w.n += 2
+}
+
+func (w *stateWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[w.n:], v)
+	w.n += 4
+}
+
+func (w *stateWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[w.n:], v)
+	w.n += 8
+}
+
+// stateReader reads the fields of one chunk in order, returning the
+// given default for fields past its end.
+type stateReader struct {
+	b []byte
+}
+
+func (r *stateReader) u8(def uint8) uint8 {
+	if len(r.b) < 1 {
+		return def
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *stateReader) bool() bool { return r.u8(0) != 0 }
+
+func (r *stateReader) u16(def uint16) uint16 {
+	if len(r.b) < 2 {
+		return def
+	}
+	v := binary.LittleEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *stateReader) u32(def uint32) uint32 {
+	if len(r.b) < 4 {
+		return def
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *stateReader) u64(def uint64) uint64 {
+	if len(r.b) < 8 {
+		return def
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
 func boolByte(b bool) uint8 {
 	if b {
 		return 1
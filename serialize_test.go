@@ -1,10 +1,15 @@
 package z80
 
-import "testing"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
 
 func TestSerializeSize(t *testing.T) {
-	if SerializeSize != 47 {
-		t.Errorf("SerializeSize = %d, want 47", SerializeSize)
+	if SerializeSize != 256 {
+		t.Errorf("SerializeSize = %d, want 256", SerializeSize)
 	}
 }
 
@@ -38,6 +43,7 @@ func TestSerializeRoundTrip(t *testing.T) {
 	cpu.intData = 0xCF
 	cpu.nmiPending = true
 	cpu.afterEI = true
+	cpu.afterLDAIR = true
 
 	buf := make([]byte, SerializeSize)
 	if err := cpu.Serialize(buf); err != nil {
@@ -75,6 +81,9 @@ func TestSerializeRoundTrip(t *testing.T) {
 	if cpu2.afterEI != cpu.afterEI {
 		t.Errorf("afterEI = %v, want %v", cpu2.afterEI, cpu.afterEI)
 	}
+	if cpu2.afterLDAIR != cpu.afterLDAIR {
+		t.Errorf("afterLDAIR = %v, want %v", cpu2.afterLDAIR, cpu.afterLDAIR)
+	}
 
 	// Verify ixiyReg is reset to HL.
 	if cpu2.ixiyReg != &cpu2.reg.HL {
@@ -185,6 +194,246 @@ func TestDeserializeErrorBadVersion(t *testing.T) {
 	}
 }
 
+func TestDeserializeVersion1(t *testing.T) {
+	buf := make([]byte, 47)
+	buf[0] = 1
+	binary.LittleEndian.PutUint16(buf[1:], 0x1234)  // AF
+	binary.LittleEndian.PutUint16(buf[23:], 0x4000) // PC
+	buf[27] = 1                                     // IFF1
+	buf[29] = 2                                     // IM
+	binary.LittleEndian.PutUint64(buf[31:], 5000)   // cycles
+	buf[44] = 0xCF                                  // INT data
+
+	cpu, _ := newTestCPU()
+	cpu.afterLDAIR = true
+	cpu.SetNextEvent(100)
+	if err := cpu.Deserialize(buf); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if cpu.reg.AF != 0x1234 || cpu.reg.PC != 0x4000 || !cpu.reg.IFF1 || cpu.reg.IM != 2 {
+		t.Errorf("registers = %+v", cpu.reg)
+	}
+	if cpu.cycles != 5000 || cpu.intData != 0xCF {
+		t.Errorf("cycles=%d intData=%02x, want 5000 CF", cpu.cycles, cpu.intData)
+	}
+	if cpu.afterLDAIR || cpu.intEnd != noEvent || cpu.nextEvent != noEvent {
+		t.Error("state missing from version 1 not defaulted")
+	}
+}
+
+func TestDeserializeVersion1_Variant(t *testing.T) {
+	buf := make([]byte, 47)
+	buf[0] = 1
+	for _, v := range []Variant{Variant8080, VariantR800, VariantEZ80, VariantLR35902} {
+		cpu := New(&testBus{}, WithVariant(v))
+		if err := cpu.Deserialize(buf); !errors.Is(err, ErrVariantMismatch) {
+			t.Errorf("%v: err = %v, want ErrVariantMismatch", v, err)
+		}
+	}
+}
+
+func TestDeserializeNewerVersion(t *testing.T) {
+	cpu, _ := newTestCPU()
+	buf := make([]byte, SerializeSize)
+	cpu.Serialize(buf)
+	buf[0] = cpuSerializeVersion + 1
+	if err := cpu.Deserialize(buf); !errors.Is(err, ErrNewerSaveState) {
+		t.Errorf("err = %v, want ErrNewerSaveState", err)
+	}
+}
+
+func TestDeserializeSkipsUnknownChunks(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.reg.PC = 0x1234
+	cpu.afterLDAIR = true
+	buf := make([]byte, SerializeSize)
+	cpu.Serialize(buf)
+	n := int(binary.LittleEndian.Uint16(buf[1:]))
+
+	// Insert a chunk from a future writer ahead of the others.
+	extra := []byte{'N', 'E', 'W', '!', 3, 0, 1, 2, 3}
+	out := append(append(append([]byte{}, buf[:3]...), extra...), buf[3:n]...)
+	binary.LittleEndian.PutUint16(out[1:], uint16(len(out)))
+
+	cpu2, _ := newTestCPU()
+	if err := cpu2.Deserialize(out); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if cpu2.reg.PC != 0x1234 || !cpu2.afterLDAIR {
+		t.Errorf("PC=%04x afterLDAIR=%v, want 1234 true", cpu2.reg.PC, cpu2.afterLDAIR)
+	}
+}
+
+func TestDeserializeShortChunkDefaults(t *testing.T) {
+	// A version 2 state whose INTR chunk predates the timing fields.
+	w := stateWriter{buf: make([]byte, SerializeSize), n: 3}
+	w.begin(tagVariant)
+	w.u8(uint8(VariantZ80))
+	w.end()
+	w.begin(tagRegs)
+	w.u16(0xABCD)
+	w.end()
+	w.begin(tagIntr)
+	w.bool(true)
+	w.u8(0xFF)
+	w.end()
+	w.buf[0] = cpuSerializeVersion
+	binary.LittleEndian.PutUint16(w.buf[1:], uint16(w.n))
+
+	cpu, _ := newTestCPU()
+	cpu.intEnd = 7
+	if err := cpu.Deserialize(w.buf[:w.n]); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if cpu.reg.AF != 0xABCD || !cpu.intLine || cpu.intEnd != noEvent {
+		t.Errorf("AF=%04x intLine=%v intEnd=%d", cpu.reg.AF, cpu.intLine, cpu.intEnd)
+	}
+}
+
+func TestDeserializeCorrupt(t *testing.T) {
+	cpu, _ := newTestCPU()
+	buf := make([]byte, SerializeSize)
+	cpu.Serialize(buf)
+	n := binary.LittleEndian.Uint16(buf[1:])
+
+	bad := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint16(bad[1:], n-1) // cuts the last chunk
+	if err := cpu.Deserialize(bad); err == nil {
+		t.Error("truncated chunk accepted")
+	}
+	if err := New(&testBus{}, WithVariant(Variant8080)).Deserialize(buf); err == nil {
+		t.Error("Z80 state accepted by an 8080")
+	}
+}
+
+func TestDeserializeMissingVariant(t *testing.T) {
+	// A version 2 state without its VRNT chunk names no variant, so no
+	// CPU can accept it.
+	w := stateWriter{buf: make([]byte, SerializeSize), n: 3}
+	w.begin(tagRegs)
+	w.u16(0xABCD)
+	w.end()
+	w.buf[0] = cpuSerializeVersion
+	binary.LittleEndian.PutUint16(w.buf[1:], uint16(w.n))
+
+	for _, v := range []Variant{VariantZ80, Variant8080, VariantR800, VariantEZ80, VariantLR35902} {
+		cpu := New(&testBus{}, WithVariant(v))
+		if err := cpu.Deserialize(w.buf[:w.n]); !errors.Is(err, ErrCorruptSaveState) {
+			t.Errorf("%v: err = %v, want ErrCorruptSaveState", v, err)
+		}
+		if cpu.reg.AF == 0xABCD {
+			t.Errorf("%v: state loaded", v)
+		}
+	}
+}
+
+func TestSerializeEZ80(t *testing.T) {
+	cpu := New(&testBus{}, WithVariant(VariantEZ80))
+	regs := EZ80Registers{BCU: 1, HLU: 2, IYU: 3, SPL: 0xD1A000, PCU: 4, MB: 0xD0, ADL: true, MADL: true}
+	cpu.SetEZ80State(regs)
+	buf := make([]byte, SerializeSize)
+	if err := cpu.Serialize(buf); err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	cpu2 := New(&testBus{}, WithVariant(VariantEZ80))
+	if err := cpu2.Deserialize(buf); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if got := cpu2.EZ80Registers(); got != regs {
+		t.Errorf("eZ80 registers = %+v, want %+v", got, regs)
+	}
+}
+
+func TestSerializeR800Page(t *testing.T) {
+	// LD A,(8000h) leaves page 80h open; the next fetch from page 00h
+	// then costs a page break.
+	code := []uint8{0x3A, 0x00, 0x80, 0x00}
+	newR800 := func() *CPU {
+		bus := &testBus{}
+		copy(bus.mem[:], code)
+		return New(bus, WithVariant(VariantR800))
+	}
+	cpu := newR800()
+	cpu.Step()
+	buf := make([]byte, SerializeSize)
+	if err := cpu.Serialize(buf); err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	cpu2 := newR800()
+	cpu2.Step() // a different open page must be replaced
+	cpu2.r800.page = 0x00
+	if err := cpu2.Deserialize(buf); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if got, want := cpu2.Step(), cpu.Step(); got != want || want != 2 {
+		t.Errorf("NOP after restore took %d cycles, want %d (2)", got, want)
+	}
+
+	js, err := json.Marshal(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu3 := newR800()
+	if err := json.Unmarshal(js, cpu3); err != nil {
+		t.Fatal(err)
+	}
+	if cpu3.r800.page != cpu.r800.page {
+		t.Errorf("JSON page = %d, want %d", cpu3.r800.page, cpu.r800.page)
+	}
+}
+
+func TestSerializeNextEvent(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.SetNextEvent(12345)
+	buf := make([]byte, SerializeSize)
+	if err := cpu.Serialize(buf); err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	cpu2, _ := newTestCPU()
+	if err := cpu2.Deserialize(buf); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if cpu2.nextEvent != 12345 {
+		t.Errorf("nextEvent = %d, want 12345", cpu2.nextEvent)
+	}
+
+	js, err := json.Marshal(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu3, _ := newTestCPU()
+	if err := json.Unmarshal(js, cpu3); err != nil {
+		t.Fatal(err)
+	}
+	if cpu3.nextEvent != 12345 {
+		t.Errorf("JSON nextEvent = %d, want 12345", cpu3.nextEvent)
+	}
+
+	// No target: restoring clears one set on the destination.
+	cpu.ClearNextEvent()
+	cpu.Serialize(buf)
+	js, _ = json.Marshal(cpu)
+	cpu2.SetNextEvent(1)
+	cpu3.SetNextEvent(1)
+	if err := cpu2.Deserialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(js, cpu3); err != nil {
+		t.Fatal(err)
+	}
+	if cpu2.nextEvent != noEvent || cpu3.nextEvent != noEvent {
+		t.Errorf("nextEvent = %d, %d, want none", cpu2.nextEvent, cpu3.nextEvent)
+	}
+}
+
+func TestSerializeMidInstruction(t *testing.T) {
+	cpu, _ := newTestCPU()
+	cpu.Tick()
+	if err := cpu.Serialize(make([]byte, SerializeSize)); err == nil {
+		t.Error("Serialize accepted a part-way instruction")
+	}
+}
+
 func BenchmarkSerialize(b *testing.B) {
 	bus := &testBus{}
 	cpu := New(bus)
@@ -0,0 +1,159 @@
+package spectrum
+
+import "io"
+
+// .SNA layout: a 27-byte header followed by 48K of RAM from 4000h. The
+// 128K form adds PC, port 7FFD, the TR-DOS flag and the banks not
+// already stored.
+const (
+	snaHeader = 27
+	sna48K    = snaHeader + 3*BankSize
+	sna128K   = sna48K + 4 + 5*BankSize
+	sna128K6  = sna48K + 4 + 6*BankSize // paged bank is 2 or 5
+)
+
+// ReadSNA reads a 48K or 128K .SNA snapshot, passing its RAM banks to
+// sink. The format has no halted flag or T-state counter; a 48K
+// snapshot's PC is popped from its stack, as the loader on the machine
+// would.
+func ReadSNA(r io.Reader, sink PageSink) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	switch len(data) {
+	case sna48K:
+		s.Machine = Machine48K
+	case sna128K, sna128K6:
+		s.Machine = Machine128K
+	default:
+		return nil, ErrFormat
+	}
+
+	h := data[:snaHeader]
+	regs := &s.Registers
+	regs.I = h[0]
+	regs.HL_ = le16(h[1:])
+	regs.DE_ = le16(h[3:])
+	regs.BC_ = le16(h[5:])
+	regs.AF_ = le16(h[7:])
+	regs.HL = le16(h[9:])
+	regs.DE = le16(h[11:])
+	regs.BC = le16(h[13:])
+	regs.IY = le16(h[15:])
+	regs.IX = le16(h[17:])
+	regs.IFF2 = h[19]&0x04 != 0
+	regs.IFF1 = regs.IFF2
+	regs.R = h[20]
+	regs.AF = le16(h[21:])
+	regs.SP = le16(h[23:])
+	regs.IM = h[25] & 3
+	s.Border = h[26] & 7
+
+	mem := data[snaHeader:sna48K]
+	if s.Machine == Machine48K {
+		// PC was pushed onto the stack when the snapshot was taken.
+		read := func(addr uint16) uint8 {
+			if addr < 0x4000 {
+				return 0
+			}
+			return mem[addr-0x4000]
+		}
+		regs.PC = uint16(read(regs.SP)) | uint16(read(regs.SP+1))<<8
+		regs.SP += 2
+		sink(5, mem[0:BankSize])
+		sink(2, mem[BankSize:2*BankSize])
+		sink(0, mem[2*BankSize:])
+		return &s, nil
+	}
+
+	ext := data[sna48K:]
+	regs.PC = le16(ext)
+	s.Port7FFD = ext[2]
+	paged := int(s.Port7FFD & 7)
+	// Banks 5 and 2 are always stored in the 48K part, so a paged bank
+	// of 5 or 2 is stored twice and six banks follow rather than five.
+	want := sna128K
+	if paged == 5 || paged == 2 {
+		want = sna128K6
+	}
+	if len(data) != want {
+		return nil, ErrFormat
+	}
+	sink(5, mem[0:BankSize])
+	sink(2, mem[BankSize:2*BankSize])
+	sink(paged, mem[2*BankSize:])
+	rest := ext[4:]
+	for n := range 8 {
+		if n == 5 || n == 2 || n == paged {
+			continue
+		}
+		sink(n, rest[:BankSize])
+		rest = rest[BankSize:]
+	}
+	return &s, nil
+}
+
+// WriteSNA writes s as a .SNA snapshot, taking RAM from src. A 48K
+// snapshot stores PC by pushing it onto the stack in the saved RAM, so
+// two bytes below SP are overwritten in the file; src is not modified.
+// A halted CPU is saved at its HALT instruction.
+func WriteSNA(w io.Writer, s *Snapshot, src PageSource) error {
+	regs := &s.Registers
+	h := make([]byte, snaHeader)
+	h[0] = regs.I
+	put16(h[1:], regs.HL_)
+	put16(h[3:], regs.DE_)
+	put16(h[5:], regs.BC_)
+	put16(h[7:], regs.AF_)
+	put16(h[9:], regs.HL)
+	put16(h[11:], regs.DE)
+	put16(h[13:], regs.BC)
+	put16(h[15:], regs.IY)
+	put16(h[17:], regs.IX)
+	if regs.IFF2 {
+		h[19] = 0x04
+	}
+	h[20] = regs.R
+	put16(h[21:], regs.AF)
+	put16(h[23:], regs.SP)
+	h[25] = regs.IM
+	h[26] = s.Border & 7
+
+	paged := int(s.Port7FFD & 7)
+	if s.Machine == Machine48K {
+		paged = 0
+	}
+	mem := make([]byte, 0, 3*BankSize)
+	mem = append(mem, bank(src, 5)...)
+	mem = append(mem, bank(src, 2)...)
+	mem = append(mem, bank(src, paged)...)
+
+	if s.Machine == Machine48K {
+		sp := regs.SP - 2
+		pc := s.haltPC()
+		for i, v := range []uint8{uint8(pc), uint8(pc >> 8)} {
+			if addr := sp + uint16(i); addr >= 0x4000 {
+				mem[addr-0x4000] = v
+			}
+		}
+		put16(h[23:], sp)
+		_, err := w.Write(append(h, mem...))
+		return err
+	}
+
+	out := append(h, mem...)
+	ext := make([]byte, 4)
+	put16(ext, s.haltPC())
+	ext[2] = s.Port7FFD
+	out = append(out, ext...)
+	for n := range 8 {
+		if n == 5 || n == 2 || n == paged {
+			continue
+		}
+		out = append(out, bank(src, n)...)
+	}
+	_, err := w.Write(out)
+	return err
+}
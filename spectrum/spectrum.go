@@ -0,0 +1,143 @@
+// Package spectrum reads and writes ZX Spectrum snapshot files: .SNA
+// (48K and 128K), .Z80 (versions 1, 2 and 3) and .SZX.
+//
+// A snapshot is decoded into a Snapshot, which holds the CPU registers
+// and the few pieces of machine state the formats share, and the RAM
+// banks, which are handed to a PageSink supplied by the caller. RAM is
+// numbered as on the 128K in all formats: a 48K machine has bank 5 at
+// 4000h, bank 2 at 8000h and bank 0 at C000h. Writing takes the banks
+// from a PageSource. ROM contents are never stored.
+//
+//	var ram spectrum.RAM
+//	snap, err := spectrum.ReadZ80(f, ram.Sink)
+//	snap.Apply(cpu)
+package spectrum
+
+import (
+	"errors"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// BankSize is the size of a RAM bank.
+const BankSize = 16384
+
+// Machine is the Spectrum model a snapshot is for.
+type Machine uint8
+
+const (
+	// Machine48K is the 48K Spectrum (and the 16K, whose RAM is bank 5).
+	Machine48K Machine = iota
+	// Machine128K is the 128K Spectrum and compatible models, with the
+	// 7FFD paging port.
+	Machine128K
+)
+
+func (m Machine) String() string {
+	if m == Machine128K {
+		return "128K"
+	}
+	return "48K"
+}
+
+// FrameLength returns the T-states in one video frame.
+func (m Machine) FrameLength() uint32 {
+	if m == Machine128K {
+		return 70908
+	}
+	return 69888
+}
+
+// banks returns the RAM banks the machine has.
+func (m Machine) banks() []int {
+	if m == Machine128K {
+		return []int{0, 1, 2, 3, 4, 5, 6, 7}
+	}
+	return []int{5, 2, 0}
+}
+
+// Snapshot is the machine state stored in a snapshot file, apart from
+// RAM.
+type Snapshot struct {
+	Machine   Machine
+	Registers z80.Registers
+	TStates   uint32 // T-states since the start of the frame
+	Border    uint8  // Border colour, 0-7
+	Port7FFD  uint8  // Last value written to the 128K paging port
+}
+
+// PageSink receives a 16K RAM bank read from a snapshot. data is only
+// valid during the call.
+type PageSink func(bank int, data []byte)
+
+// PageSource returns the contents of a 16K RAM bank to write to a
+// snapshot. A short or nil slice is padded with zeros.
+type PageSource func(bank int) []byte
+
+// RAM holds the eight 16K banks of a 128K Spectrum, or the three of a
+// 48K one, for use as a PageSink and PageSource.
+type RAM [8][BankSize]byte
+
+// Sink stores a bank; it is a PageSink.
+func (r *RAM) Sink(bank int, data []byte) {
+	if bank >= 0 && bank < len(r) {
+		copy(r[bank][:], data)
+	}
+}
+
+// Source returns a bank; it is a PageSource.
+func (r *RAM) Source(bank int) []byte {
+	if bank < 0 || bank >= len(r) {
+		return nil
+	}
+	return r[bank][:]
+}
+
+// ErrFormat is returned for data that is not a valid snapshot of the
+// expected type.
+var ErrFormat = errors.New("spectrum: invalid snapshot")
+
+// errMachine is returned for a snapshot of an unsupported model.
+var errMachine = errors.New("spectrum: unsupported machine")
+
+// Apply loads the snapshot's registers into cpu and sets its cycle
+// counter to the T-state within the frame.
+func (s *Snapshot) Apply(cpu *z80.CPU) {
+	cpu.SetState(s.Registers)
+	cpu.SetCycles(uint64(s.TStates))
+}
+
+// FromCPU returns a snapshot of cpu's registers for machine m, with the
+// T-state within the frame taken from its cycle counter. Border and
+// Port7FFD are left for the caller to fill in.
+func FromCPU(cpu *z80.CPU, m Machine) Snapshot {
+	return Snapshot{
+		Machine:   m,
+		Registers: cpu.Registers(),
+		TStates:   uint32(cpu.Cycles() % uint64(m.FrameLength())),
+	}
+}
+
+// haltPC returns the PC to store in formats without a halted flag: the
+// address of the HALT instruction, so that running it again halts.
+func (s *Snapshot) haltPC() uint16 {
+	if s.Registers.Halted {
+		return s.Registers.PC - 1
+	}
+	return s.Registers.PC
+}
+
+// bank returns a full 16K copy of bank n from src.
+func bank(src PageSource, n int) []byte {
+	b := make([]byte, BankSize)
+	copy(b, src(n))
+	return b
+}
+
+// le16 and put16 read and write little-endian words.
+func le16(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+
+func put16(b []byte, v uint16) {
+	b[0] = uint8(v)
+	b[1] = uint8(v >> 8)
+}
@@ -0,0 +1,205 @@
+package spectrum
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+func testSnapshot(m Machine) (*Snapshot, *RAM) {
+	s := &Snapshot{
+		Machine: m,
+		Registers: z80.Registers{
+			AF: 0x1234, BC: 0x2345, DE: 0x3456, HL: 0x4567,
+			AF_: 0x5678, BC_: 0x6789, DE_: 0x789A, HL_: 0x89AB,
+			IX: 0x9ABC, IY: 0xABCD, SP: 0xFF00, PC: 0x8001,
+			I: 0x3F, R: 0xA5, IFF1: true, IFF2: true, IM: 1,
+			WZ: 0x1357,
+		},
+		TStates: 12345,
+		Border:  3,
+	}
+	if m == Machine128K {
+		s.Port7FFD = 0x13
+	}
+	ram := &RAM{}
+	for _, n := range m.banks() {
+		for i := range ram[n] {
+			ram[n][i] = uint8(n*7 + i%13)
+		}
+		// Runs and EDs to exercise .Z80 compression.
+		for i := 100; i < 400; i++ {
+			ram[n][i] = 0
+		}
+		copy(ram[n][500:], []byte{0xED, 0x00, 0xED, 0xED, 0xED, 0x01, 0xED})
+	}
+	return s, ram
+}
+
+type format struct {
+	name  string
+	read  func(io.Reader, PageSink) (*Snapshot, error)
+	write func(io.Writer, *Snapshot, PageSource) error
+}
+
+var formats = []format{
+	{"SNA", ReadSNA, WriteSNA},
+	{"Z80", ReadZ80, WriteZ80},
+	{"SZX", ReadSZX, WriteSZX},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range formats {
+		for _, m := range []Machine{Machine48K, Machine128K} {
+			for _, halted := range []bool{false, true} {
+				want, ram := testSnapshot(m)
+				want.Registers.Halted = halted
+				var buf bytes.Buffer
+				if err := f.write(&buf, want, ram.Source); err != nil {
+					t.Fatalf("%s %v: write: %v", f.name, m, err)
+				}
+				var got RAM
+				s, err := f.read(&buf, got.Sink)
+				if err != nil {
+					t.Fatalf("%s %v: read: %v", f.name, m, err)
+				}
+
+				exp := *want
+				if f.name != "SZX" {
+					exp.Registers.WZ = 0 // only SZX records MEMPTR
+				}
+				if f.name == "SNA" {
+					// No T-state counter or halted flag; IFF1 comes
+					// from IFF2.
+					exp.TStates = 0
+					if halted {
+						exp.Registers.Halted = false
+						exp.Registers.PC--
+					}
+				}
+				if f.name == "Z80" && halted {
+					exp.Registers.Halted = false
+					exp.Registers.PC--
+				}
+				if *s != exp {
+					t.Errorf("%s %v halted=%v: got %+v, want %+v", f.name, m, halted, *s, exp)
+				}
+
+				if f.name == "SNA" && m == Machine48K {
+					// PC is pushed below SP in the saved RAM.
+					sp := want.Registers.SP - 2 - 0x4000
+					ram[0][sp-0x8000] = uint8(exp.Registers.PC)
+					ram[0][sp-0x8000+1] = uint8(exp.Registers.PC >> 8)
+				}
+				for _, n := range m.banks() {
+					if got[n] != ram[n] {
+						t.Errorf("%s %v: bank %d differs", f.name, m, n)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, f := range formats {
+		if _, err := f.read(bytes.NewReader(make([]byte, 10)), func(int, []byte) {}); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: err = %v, want ErrFormat", f.name, err)
+		}
+	}
+}
+
+func TestZ80Compression(t *testing.T) {
+	for _, src := range [][]byte{
+		{1, 2, 3, 4},
+		{5, 5, 5, 5, 5, 5, 5},
+		{0xED, 0xED},
+		{0xED, 5, 5, 5, 5, 5, 5},
+		{0xED},
+		bytes.Repeat([]byte{9}, 600),
+	} {
+		comp := z80Compress(src)
+		got, err := z80Decompress(comp, len(src))
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("% X: got % X, %v", src, got, err)
+		}
+	}
+	// An ED followed by a run keeps the first byte of the run literal.
+	if got, want := z80Compress([]byte{0xED, 5, 5, 5, 5, 5, 5}), []byte{0xED, 5, 0xED, 0xED, 5, 5}; !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestZ80V1(t *testing.T) {
+	h := make([]byte, z80Header)
+	put16(h[6:], 0x8000) // PC
+	h[12] = 0x20 | 2<<1  // compressed, border 2
+	mem := append(z80Compress(make([]byte, 3*BankSize)), 0, 0xED, 0xED, 0)
+	var banks []int
+	s, err := ReadZ80(bytes.NewReader(append(h, mem...)), func(n int, data []byte) {
+		banks = append(banks, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Registers.PC != 0x8000 || s.Border != 2 || s.Machine != Machine48K {
+		t.Errorf("got %+v", *s)
+	}
+	if len(banks) != 3 || banks[0] != 5 || banks[1] != 2 || banks[2] != 0 {
+		t.Errorf("banks = %v", banks)
+	}
+}
+
+func TestZ80TStates(t *testing.T) {
+	for _, m := range []Machine{Machine48K, Machine128K} {
+		for _, ts := range []uint32{0, 1, m.FrameLength()/4 - 1, m.FrameLength() / 4, m.FrameLength() - 1} {
+			s, ram := testSnapshot(m)
+			s.TStates = ts
+			var buf bytes.Buffer
+			if err := WriteZ80(&buf, s, ram.Source); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadZ80(&buf, func(int, []byte) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.TStates != ts {
+				t.Errorf("%v: TStates %d read back as %d", m, ts, got.TStates)
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	cpu := z80.New(nil)
+	s, _ := testSnapshot(Machine48K)
+	s.Registers.Halted = true
+	s.Apply(cpu)
+	if cpu.Registers() != s.Registers || cpu.Cycles() != uint64(s.TStates) {
+		t.Errorf("got %+v at %d", cpu.Registers(), cpu.Cycles())
+	}
+	got := FromCPU(cpu, Machine48K)
+	if got.Registers != s.Registers || got.TStates != s.TStates {
+		t.Errorf("FromCPU = %+v", got)
+	}
+}
+
+func TestReadSNA_128KLengthMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		size  int
+		paged uint8
+	}{
+		{sna128K, 5},  // five banks follow, but paging 5 needs six
+		{sna128K, 2},  // likewise for bank 2
+		{sna128K6, 3}, // six banks follow, but paging 3 needs five
+	} {
+		data := make([]byte, tc.size)
+		data[sna48K+2] = tc.paged
+		if _, err := ReadSNA(bytes.NewReader(data), func(int, []byte) {}); !errors.Is(err, ErrFormat) {
+			t.Errorf("%d bytes paging bank %d: err = %v, want ErrFormat", tc.size, tc.paged, err)
+		}
+	}
+}
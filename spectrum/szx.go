@@ -0,0 +1,177 @@
+package spectrum
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// .SZX layout: an 8-byte header ("ZXST", version, machine, flags) and
+// blocks of a 4-byte ID, a 32-bit little-endian length and data. Blocks
+// other than Z80R, SPCR and RAMP are skipped.
+const (
+	szxMajor = 1
+	szxMinor = 4
+
+	szxZ80RLength = 37
+	szxSPCRLength = 8
+
+	szxHalted     = 0x02 // Z80R chFlags: the CPU is in HALT
+	szxCompressed = 0x01 // RAMP wFlags: the page is zlib compressed
+)
+
+// ReadSZX reads a .SZX snapshot, passing its RAM banks to sink. The
+// registers come from the Z80R block, the border and paging port from
+// SPCR, and RAM from the RAMP blocks.
+func ReadSZX(r io.Reader, sink PageSink) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || string(data[:4]) != "ZXST" {
+		return nil, ErrFormat
+	}
+	var s Snapshot
+	switch data[6] {
+	case 0, 1: // 16K, 48K
+		s.Machine = Machine48K
+	case 2, 3, 4, 5: // 128K, +2, +2A, +3
+		s.Machine = Machine128K
+	default:
+		return nil, errMachine
+	}
+
+	haveRegs := false
+	blocks := data[8:]
+	for len(blocks) > 0 {
+		if len(blocks) < 8 {
+			return nil, ErrFormat
+		}
+		id := string(blocks[:4])
+		n := binary.LittleEndian.Uint32(blocks[4:])
+		if uint64(len(blocks)-8) < uint64(n) {
+			return nil, ErrFormat
+		}
+		b := blocks[8 : 8+n]
+		blocks = blocks[8+n:]
+		switch id {
+		case "Z80R":
+			if len(b) < szxZ80RLength {
+				return nil, ErrFormat
+			}
+			szxReadZ80R(&s, b)
+			haveRegs = true
+		case "SPCR":
+			if len(b) < szxSPCRLength {
+				return nil, ErrFormat
+			}
+			s.Border = b[0] & 7
+			s.Port7FFD = b[1]
+		case "RAMP":
+			if len(b) < 3 {
+				return nil, ErrFormat
+			}
+			page := b[2]
+			mem := b[3:]
+			if le16(b)&szxCompressed != 0 {
+				zr, err := zlib.NewReader(bytes.NewReader(mem))
+				if err != nil {
+					return nil, ErrFormat
+				}
+				if mem, err = io.ReadAll(zr); err != nil {
+					return nil, ErrFormat
+				}
+			}
+			if len(mem) != BankSize {
+				return nil, ErrFormat
+			}
+			sink(int(page), mem)
+		}
+	}
+	if !haveRegs {
+		return nil, ErrFormat
+	}
+	return &s, nil
+}
+
+// szxReadZ80R decodes a Z80R block, including the MEMPTR word added in
+// SZX 1.4. SZX stores a halted CPU's PC at the HALT instruction.
+func szxReadZ80R(s *Snapshot, b []byte) {
+	regs := &s.Registers
+	for i, p := range []*uint16{
+		&regs.AF, &regs.BC, &regs.DE, &regs.HL,
+		&regs.AF_, &regs.BC_, &regs.DE_, &regs.HL_,
+		&regs.IX, &regs.IY, &regs.SP, &regs.PC,
+	} {
+		*p = le16(b[2*i:])
+	}
+	regs.I = b[24]
+	regs.R = b[25]
+	regs.IFF1 = b[26] != 0
+	regs.IFF2 = b[27] != 0
+	regs.IM = b[28] & 3
+	s.TStates = binary.LittleEndian.Uint32(b[29:])
+	regs.WZ = le16(b[35:])
+	if b[34]&szxHalted != 0 {
+		regs.Halted = true
+		regs.PC++
+	}
+}
+
+// WriteSZX writes s as a .SZX snapshot with zlib-compressed RAM pages,
+// taking RAM from src.
+func WriteSZX(w io.Writer, s *Snapshot, src PageSource) error {
+	var out bytes.Buffer
+	machine := uint8(1)
+	if s.Machine == Machine128K {
+		machine = 2
+	}
+	out.Write([]byte{'Z', 'X', 'S', 'T', szxMajor, szxMinor, machine, 0})
+
+	regs := &s.Registers
+	z := make([]byte, szxZ80RLength)
+	for i, v := range []uint16{
+		regs.AF, regs.BC, regs.DE, regs.HL,
+		regs.AF_, regs.BC_, regs.DE_, regs.HL_,
+		regs.IX, regs.IY, regs.SP, s.haltPC(),
+	} {
+		put16(z[2*i:], v)
+	}
+	z[24] = regs.I
+	z[25] = regs.R
+	z[26] = boolByte(regs.IFF1)
+	z[27] = boolByte(regs.IFF2)
+	z[28] = regs.IM
+	binary.LittleEndian.PutUint32(z[29:], s.TStates)
+	if regs.Halted {
+		z[34] = szxHalted
+	}
+	put16(z[35:], regs.WZ)
+	szxBlock(&out, "Z80R", z)
+
+	spcr := make([]byte, szxSPCRLength)
+	spcr[0] = s.Border & 7
+	spcr[1] = s.Port7FFD
+	szxBlock(&out, "SPCR", spcr)
+
+	for _, n := range s.Machine.banks() {
+		var comp bytes.Buffer
+		comp.Write([]byte{szxCompressed, 0, uint8(n)})
+		zw := zlib.NewWriter(&comp)
+		zw.Write(bank(src, n))
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		szxBlock(&out, "RAMP", comp.Bytes())
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// szxBlock appends a block with the given ID.
+func szxBlock(out *bytes.Buffer, id string, data []byte) {
+	out.WriteString(id)
+	binary.Write(out, binary.LittleEndian, uint32(len(data)))
+	out.Write(data)
+}
@@ -0,0 +1,271 @@
+package spectrum
+
+import (
+	"bytes"
+	"io"
+)
+
+// .Z80 layout: a 30-byte header. In version 1 PC is non-zero and 48K of
+// RAM follows, compressed if bit 5 of byte 12 is set. Versions 2 and 3
+// have PC 0 and an additional header, whose length gives the version,
+// followed by 16K memory blocks.
+const (
+	z80Header   = 30
+	z80V2Length = 23
+	z80V3Length = 54
+)
+
+// ReadZ80 reads a version 1, 2 or 3 .Z80 snapshot, passing its RAM
+// banks to sink. Only version 3 stores the T-state counter. The format
+// has no halted flag.
+func ReadZ80(r io.Reader, sink PageSink) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < z80Header {
+		return nil, ErrFormat
+	}
+	var s Snapshot
+	h := data[:z80Header]
+	flags := h[12]
+	if flags == 0xFF {
+		flags = 1
+	}
+	regs := &s.Registers
+	regs.AF = uint16(h[0])<<8 | uint16(h[1])
+	regs.BC = le16(h[2:])
+	regs.HL = le16(h[4:])
+	regs.PC = le16(h[6:])
+	regs.SP = le16(h[8:])
+	regs.I = h[10]
+	regs.R = h[11]&0x7F | flags<<7
+	s.Border = flags >> 1 & 7
+	regs.DE = le16(h[13:])
+	regs.BC_ = le16(h[15:])
+	regs.DE_ = le16(h[17:])
+	regs.HL_ = le16(h[19:])
+	regs.AF_ = uint16(h[21])<<8 | uint16(h[22])
+	regs.IY = le16(h[23:])
+	regs.IX = le16(h[25:])
+	regs.IFF1 = h[27] != 0
+	regs.IFF2 = h[28] != 0
+	regs.IM = h[29] & 3
+
+	if regs.PC != 0 {
+		// Version 1: 48K only.
+		mem := data[z80Header:]
+		if flags&0x20 != 0 {
+			if mem, err = z80Decompress(mem, 3*BankSize); err != nil {
+				return nil, err
+			}
+		}
+		if len(mem) < 3*BankSize {
+			return nil, ErrFormat
+		}
+		sink(5, mem[0:BankSize])
+		sink(2, mem[BankSize:2*BankSize])
+		sink(0, mem[2*BankSize:3*BankSize])
+		return &s, nil
+	}
+
+	if len(data) < z80Header+2 {
+		return nil, ErrFormat
+	}
+	extLen := int(le16(data[z80Header:]))
+	if extLen < z80V2Length || len(data) < z80Header+2+extLen {
+		return nil, ErrFormat
+	}
+	ext := data[z80Header : z80Header+2+extLen]
+	regs.PC = le16(ext[2:])
+	switch hw := ext[4]; {
+	case hw == 0 || hw == 1 || (extLen == z80V2Length && hw == 2):
+		s.Machine = Machine48K
+	case extLen == z80V2Length && (hw == 3 || hw == 4):
+		s.Machine = Machine128K
+	case extLen > z80V2Length && hw == 3:
+		s.Machine = Machine48K
+	case extLen > z80V2Length && (hw >= 4 && hw <= 7 || hw == 12 || hw == 13):
+		s.Machine = Machine128K
+	default:
+		return nil, errMachine
+	}
+	if s.Machine == Machine128K {
+		s.Port7FFD = ext[5]
+	}
+	if extLen >= z80V3Length {
+		q := s.Machine.FrameLength() / 4
+		lo, hi := uint32(le16(ext[25:])), uint32(ext[27])
+		s.TStates = ((hi+1)%4+1)*q - (lo + 1)
+	}
+
+	blocks := data[z80Header+2+extLen:]
+	for len(blocks) > 0 {
+		if len(blocks) < 3 {
+			return nil, ErrFormat
+		}
+		n, page := int(le16(blocks)), int(blocks[2])
+		blocks = blocks[3:]
+		var mem []byte
+		if n == 0xFFFF {
+			if len(blocks) < BankSize {
+				return nil, ErrFormat
+			}
+			mem, blocks = blocks[:BankSize], blocks[BankSize:]
+		} else {
+			if len(blocks) < n {
+				return nil, ErrFormat
+			}
+			if mem, err = z80Decompress(blocks[:n], BankSize); err != nil {
+				return nil, err
+			}
+			blocks = blocks[n:]
+		}
+		if b := z80PageBank(s.Machine, page); b >= 0 {
+			sink(b, mem)
+		}
+	}
+	return &s, nil
+}
+
+// WriteZ80 writes s as a compressed version 3 .Z80 snapshot, taking RAM
+// from src. A halted CPU is saved at its HALT instruction.
+func WriteZ80(w io.Writer, s *Snapshot, src PageSource) error {
+	regs := &s.Registers
+	out := make([]byte, z80Header+2+z80V3Length)
+	h := out[:z80Header]
+	h[0], h[1] = uint8(regs.AF>>8), uint8(regs.AF)
+	put16(h[2:], regs.BC)
+	put16(h[4:], regs.HL)
+	put16(h[8:], regs.SP)
+	h[10] = regs.I
+	h[11] = regs.R & 0x7F
+	h[12] = regs.R>>7 | (s.Border&7)<<1
+	put16(h[13:], regs.DE)
+	put16(h[15:], regs.BC_)
+	put16(h[17:], regs.DE_)
+	put16(h[19:], regs.HL_)
+	h[21], h[22] = uint8(regs.AF_>>8), uint8(regs.AF_)
+	put16(h[23:], regs.IY)
+	put16(h[25:], regs.IX)
+	h[27] = boolByte(regs.IFF1)
+	h[28] = boolByte(regs.IFF2)
+	h[29] = regs.IM & 3
+
+	ext := out[z80Header:]
+	put16(ext, z80V3Length)
+	put16(ext[2:], s.haltPC())
+	if s.Machine == Machine128K {
+		ext[4] = 4
+		ext[5] = s.Port7FFD
+	}
+	q := s.Machine.FrameLength() / 4
+	t := s.TStates % s.Machine.FrameLength()
+	put16(ext[25:], uint16(q-t%q-1))
+	ext[27] = uint8((t/q + 3) % 4)
+
+	for _, b := range s.Machine.banks() {
+		mem := bank(src, b)
+		comp := z80Compress(mem)
+		blk := make([]byte, 3)
+		blk[2] = z80BankPage(s.Machine, b)
+		if len(comp) >= BankSize {
+			put16(blk, 0xFFFF)
+			comp = mem
+		} else {
+			put16(blk, uint16(len(comp)))
+		}
+		out = append(append(out, blk...), comp...)
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// z80PageBank maps a .Z80 memory block page number to a RAM bank, or -1
+// for ROM and unknown pages.
+func z80PageBank(m Machine, page int) int {
+	if m == Machine128K {
+		if page >= 3 && page <= 10 {
+			return page - 3
+		}
+		return -1
+	}
+	switch page {
+	case 8:
+		return 5
+	case 4:
+		return 2
+	case 5:
+		return 0
+	}
+	return -1
+}
+
+// z80BankPage is the inverse of z80PageBank.
+func z80BankPage(m Machine, bank int) uint8 {
+	if m == Machine128K {
+		return uint8(bank + 3)
+	}
+	switch bank {
+	case 5:
+		return 8
+	case 2:
+		return 4
+	}
+	return 5
+}
+
+// z80Compress applies the .Z80 run-length encoding: a run of five or
+// more equal bytes, or of two or more EDs, becomes ED ED count byte. The
+// byte after a lone ED is never the start of a run.
+func z80Compress(src []byte) []byte {
+	var out []byte
+	for i := 0; i < len(src); {
+		b := src[i]
+		n := 1
+		for i+n < len(src) && src[i+n] == b && n < 255 {
+			n++
+		}
+		if n >= 5 || (b == 0xED && n >= 2) {
+			out = append(out, 0xED, 0xED, uint8(n), b)
+			i += n
+			continue
+		}
+		out = append(out, b)
+		i++
+		if b == 0xED && i < len(src) {
+			out = append(out, src[i])
+			i++
+		}
+	}
+	return out
+}
+
+// z80Decompress expands .Z80 run-length encoded data to size bytes. It
+// stops at size, or at the version 1 end marker 00 ED ED 00.
+func z80Decompress(src []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(src) && len(out) < size; {
+		if i+3 < len(src) && src[i] == 0xED && src[i+1] == 0xED {
+			out = append(out, bytes.Repeat([]byte{src[i+3]}, int(src[i+2]))...)
+			i += 4
+			continue
+		}
+		if i+3 < len(src) && src[i] == 0 && src[i+1] == 0xED && src[i+2] == 0xED && src[i+3] == 0 {
+			break
+		}
+		out = append(out, src[i])
+		i++
+	}
+	if len(out) != size {
+		return nil, ErrFormat
+	}
+	return out, nil
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
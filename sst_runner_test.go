@@ -22,15 +22,6 @@ var sstSkip = map[string]string{
 	"dd 3f.json": "CCF q-register F3/F5 (DD prefix)",
 	"fd 37.json": "SCF q-register F3/F5 (FD prefix)",
 	"fd 3f.json": "CCF q-register F3/F5 (FD prefix)",
-	// BIT n,(HL): F3/F5 come from WZ (MEMPTR) high byte, not the tested value
-	"cb 46.json": "BIT 0,(HL) WZ/MEMPTR F3/F5",
-	"cb 4e.json": "BIT 1,(HL) WZ/MEMPTR F3/F5",
-	"cb 56.json": "BIT 2,(HL) WZ/MEMPTR F3/F5",
-	"cb 5e.json": "BIT 3,(HL) WZ/MEMPTR F3/F5",
-	"cb 66.json": "BIT 4,(HL) WZ/MEMPTR F3/F5",
-	"cb 6e.json": "BIT 5,(HL) WZ/MEMPTR F3/F5",
-	"cb 76.json": "BIT 6,(HL) WZ/MEMPTR F3/F5",
-	"cb 7e.json": "BIT 7,(HL) WZ/MEMPTR F3/F5",
 	// Block IO repeat: undocumented flags depend on WZ
 	"ed b1.json": "CPIR WZ-dependent flags",
 	"ed b2.json": "INIR WZ-dependent flags",
@@ -62,11 +53,11 @@ type sstJSONState struct {
 	IFF1 uint8      `json:"iff1"`
 	IFF2 uint8      `json:"iff2"`
 	RAM  [][]uint16 `json:"ram"`
+	WZ   uint16     `json:"wz"`
 	// Parsed but not modeled.
-	WZ uint16 `json:"wz"`
-	EI uint8  `json:"ei"`
-	P  uint8  `json:"p"`
-	Q  uint8  `json:"q"`
+	EI uint8 `json:"ei"`
+	P  uint8 `json:"p"`
+	Q  uint8 `json:"q"`
 }
 
 func (s *sstJSONState) toZ80State() z80State {
@@ -77,9 +68,11 @@ func (s *sstJSONState) toZ80State() z80State {
 		PC: s.PC, SP: s.SP,
 		IX: s.IX, IY: s.IY,
 		AF_: s.AF_, BC_: s.BC_, DE_: s.DE_, HL_: s.HL_,
-		IM:   s.IM,
-		IFF1: s.IFF1 != 0,
-		IFF2: s.IFF2 != 0,
+		IM:      s.IM,
+		IFF1:    s.IFF1 != 0,
+		IFF2:    s.IFF2 != 0,
+		WZ:      s.WZ,
+		CheckWZ: true,
 	}
 	for _, entry := range s.RAM {
 		st.RAM = append(st.RAM, [2]uint16{entry[0], entry[1]})
@@ -87,6 +80,20 @@ func (s *sstJSONState) toZ80State() z80State {
 	return st
 }
 
+// halts reports whether the instruction at PC is HALT, which the vectors
+// do not record in the final state: the CPU is then halted after it.
+func (s *sstJSONState) halts() bool {
+	mem := make(map[uint16]uint8, len(s.RAM))
+	for _, entry := range s.RAM {
+		mem[entry[0]] = uint8(entry[1])
+	}
+	op := mem[s.PC]
+	if op == 0xDD || op == 0xFD {
+		op = mem[s.PC+1]
+	}
+	return op == 0x76
+}
+
 type sstJSONTest struct {
 	Name    string       `json:"name"`
 	Initial sstJSONState `json:"initial"`
@@ -133,6 +140,7 @@ func TestSSTRunner(t *testing.T) {
 				init := jt.Initial.toZ80State()
 				want := jt.Final.toZ80State()
 				want.Cycles = len(jt.Cycles)
+				want.Halted = jt.Initial.halts()
 
 				// Extract input port reads.
 				for _, p := range jt.Ports {
@@ -0,0 +1,207 @@
+package z80
+
+import "errors"
+
+// tcEntry is a translated instruction: the handler that runs it and the
+// opcode bytes already consumed. Prefixed CB and ED instructions on the
+// Z80 are translated to their leaf handler, skipping the prefix dispatch.
+// Only the dispatch is cached; the handler decodes its operands from
+// memory each time it runs.
+//
+// Entries are chained: next is the translation that ran after this one
+// the last time, found at nextPC. A run of code follows the chain
+// without looking PC up again, checking only that PC matches and that
+// the next entry has not been invalidated.
+type tcEntry struct {
+	fn     opFunc
+	op     uint8 // opcode passed to fn
+	len    uint8 // opcode bytes covered (M1 cycles); 0 means empty
+	nextPC uint16
+	next   *tcEntry
+}
+
+// tcPage holds the translations for one 256-byte page. An entry never
+// spans two pages, so a write only has to look at its own page.
+type tcPage [PageSize]tcEntry
+
+// tcache is the translation cache. Every memory write made by the CPU,
+// including writes served by MapMemory pages, drops the translations of
+// the bytes it touches. Entries are only ever emptied, never freed, so
+// a chain pointer always points at the slot for its PC.
+type tcache struct {
+	pages [256]*tcPage
+}
+
+// invalidate drops any translation covering addr: the one starting there
+// and a two-byte one starting at the byte before.
+func (t *tcache) invalidate(addr uint16) {
+	p := t.pages[addr>>8]
+	if p == nil {
+		return
+	}
+	i := addr & 0xFF
+	p[i].len = 0
+	if i > 0 && p[i-1].len == 2 {
+		p[i-1].len = 0
+	}
+}
+
+// undocNOP8 is the translation of an undefined CB or ED opcode.
+func undocNOP8(c *CPU, _ uint8) { c.cycles += 8 }
+
+// SetTranslationCache turns the translation cache on or off. It is an
+// opcode-dispatch cache, not a basic-block translator: while on, code in
+// pages mapped with MapMemory is run from a cache of the handler chosen
+// for each opcode, keyed by PC and filled as the code first runs, so the
+// opcode is not fetched and dispatched again. Each translation is linked
+// to the one that ran after it, and RunCycles follows those links through
+// straight-line code and loops without looking PC up. Operands are still
+// read and decoded from memory on every run, every instruction still
+// takes its exact T-states, and interrupts are still checked between
+// instructions.
+//
+// Only pages mapped for opcode fetches are cached: code served by the
+// Bus, or in pages mapped with PageM1, is fetched through Bus.Fetch every
+// time, so bank switches done inside the Bus take effect at once. Writes
+// made by the CPU, and MapMemory and UnmapMemory calls, invalidate the
+// affected translations automatically. When mapped memory changes
+// behind the CPU's back, such as a DMA transfer into a mapped slice,
+// call InvalidateCode for the affected range.
+//
+// The cache is not available on the R800, whose opcode fetches take
+// cycles, or on the eZ80.
+func (c *CPU) SetTranslationCache(on bool) error {
+	if c.r800 != nil || c.ez != nil {
+		return errors.New("z80: translation cache not supported by this variant")
+	}
+	switch {
+	case on && c.tc == nil:
+		c.tc = &tcache{}
+	case !on:
+		c.tc = nil
+	}
+	c.setBus()
+	return nil
+}
+
+// InvalidateCode drops the cached translations of size bytes starting at
+// addr, wrapping at 0xFFFF. It does nothing when the cache is off.
+func (c *CPU) InvalidateCode(addr uint16, size int) {
+	if c.tc == nil {
+		return
+	}
+	if size >= 0x10000 {
+		for _, p := range c.tc.pages {
+			if p != nil {
+				clear(p[:])
+			}
+		}
+		return
+	}
+	for ; size > 0; size-- {
+		c.tc.invalidate(addr)
+		addr++
+	}
+}
+
+// tcExecute runs the instruction at PC from the translation cache,
+// translating it first on a miss.
+func (c *CPU) tcExecute() {
+	pc := c.reg.PC
+	if p := c.tc.pages[pc>>8]; p != nil {
+		if e := &p[pc&0xFF]; e.len != 0 {
+			c.tcRunEntry(e)
+			return
+		}
+	}
+	c.tcTranslate(pc)
+}
+
+// tcRun runs instructions as RunCycles does, following the translation
+// chain, until Cycles reaches end or the event target or the cache is
+// turned off. Any instruction boundary where Step would do more than run
+// the next instruction (a latched NMI, INT with interrupts enabled, HALT,
+// or a trace hook) is left to step.
+func (c *CPU) tcRun(end uint64) {
+	var prev *tcEntry
+	for c.tc != nil && c.cycles < end && c.cycles < c.nextEvent {
+		if c.nmiPending || (c.intLine && c.reg.IFF1) || c.reg.Halted || c.traceHook != nil {
+			c.step()
+			prev = nil
+			continue
+		}
+		c.afterEI = false
+		c.afterLDAIR = false
+		pc := c.reg.PC
+		e := prev
+		if e != nil {
+			if e.nextPC != pc || e.next == nil || e.next.len == 0 {
+				e.next = c.tcLookup(pc)
+				e.nextPC = pc
+			}
+			e = e.next
+		} else {
+			e = c.tcLookup(pc)
+		}
+		if e == nil {
+			prev = c.tcTranslate(pc)
+			continue
+		}
+		c.tcRunEntry(e)
+		prev = e
+	}
+}
+
+// tcLookup returns the translation at pc, or nil if there is none.
+func (c *CPU) tcLookup(pc uint16) *tcEntry {
+	if p := c.tc.pages[pc>>8]; p != nil {
+		if e := &p[pc&0xFF]; e.len != 0 {
+			return e
+		}
+	}
+	return nil
+}
+
+// tcRunEntry runs a cached translation, accounting for the opcode
+// fetches it skips.
+func (c *CPU) tcRunEntry(e *tcEntry) {
+	c.reg.PC += uint16(e.len)
+	if c.tracking {
+		c.accesses += uint64(e.len)
+		c.lastBus = busAccess{MCycleM1, c.reg.PC - 1, e.op}
+	}
+	c.reg.R = (c.reg.R & 0x80) | ((c.reg.R + c.refresh*e.len) & 0x7F)
+	e.fn(c, e.op)
+}
+
+// tcTranslate fetches and decodes the instruction at pc as execute does,
+// records the translation if pc is in a cached page, and runs it. It
+// returns the recorded entry, or nil.
+func (c *CPU) tcTranslate(pc uint16) *tcEntry {
+	op := c.fetchOpcode()
+	e := tcEntry{fn: c.ops[op], op: op, len: 1}
+	if c.ops == &baseOps && (op == 0xCB || op == 0xED) && pc&0xFF != 0xFF {
+		table := &cbOps
+		if op == 0xED {
+			table = &edOps
+		}
+		e.op = c.fetchOpcode()
+		e.fn = table[e.op]
+		if e.fn == nil {
+			e.fn = undocNOP8
+		}
+		e.len = 2
+	}
+	var stored *tcEntry
+	if c.mem.fetch[pc>>8] != nil {
+		p := c.tc.pages[pc>>8]
+		if p == nil {
+			p = &tcPage{}
+			c.tc.pages[pc>>8] = p
+		}
+		stored = &p[pc&0xFF]
+		stored.fn, stored.op, stored.len = e.fn, e.op, e.len
+	}
+	e.fn(c, e.op)
+	return stored
+}
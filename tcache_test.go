@@ -0,0 +1,212 @@
+package z80
+
+import "testing"
+
+// newCachedCPU returns a CPU with the test bus memory mapped and the
+// translation cache on.
+func newCachedCPU() (*CPU, *testBus) {
+	bus := &testBus{}
+	cpu := New(bus)
+	cpu.MapMemory(0x0000, bus.mem[:], PageRead|PageWrite)
+	cpu.SetTranslationCache(true)
+	return cpu, bus
+}
+
+func newWorkloadCPU(cached bool) (*CPU, *testBus) {
+	cpu, bus := newTestCPU()
+	if cached {
+		cpu, bus = newCachedCPU()
+	}
+	copy(bus.mem[:], workloadProgram)
+	for i := range 256 {
+		bus.mem[0x4000+i] = uint8(i * 7)
+	}
+	cpu.reg.SP = 0xFFFE
+	return cpu, bus
+}
+
+func TestTranslationCache_MatchesInterpreter(t *testing.T) {
+	ref, refBus := newWorkloadCPU(false)
+	cpu, bus := newWorkloadCPU(true)
+	for i := 0; i < 20000; i++ {
+		want := ref.Step()
+		got := cpu.Step()
+		if got != want {
+			t.Fatalf("step %d at PC=%04x: cycles = %d, want %d", i, ref.reg.PC, got, want)
+		}
+		if cpu.reg != ref.reg {
+			t.Fatalf("step %d: registers = %+v, want %+v", i, cpu.reg, ref.reg)
+		}
+	}
+	if bus.mem != refBus.mem {
+		t.Error("memory differs from interpreter")
+	}
+}
+
+func TestTranslationCache_RunCyclesMatchesInterpreter(t *testing.T) {
+	ref, refBus := newWorkloadCPU(false)
+	cpu, bus := newWorkloadCPU(true)
+	for i := 0; i < 2000; i++ {
+		want := ref.RunCycles(97)
+		got := cpu.RunCycles(97)
+		if got != want || cpu.Cycles() != ref.Cycles() {
+			t.Fatalf("run %d: ran %d to %d, want %d to %d", i, got, cpu.Cycles(), want, ref.Cycles())
+		}
+		if cpu.reg != ref.reg {
+			t.Fatalf("run %d: registers = %+v, want %+v", i, cpu.reg, ref.reg)
+		}
+	}
+	if bus.mem != refBus.mem {
+		t.Error("memory differs from interpreter")
+	}
+}
+
+func TestTranslationCache_BusPagesNotCached(t *testing.T) {
+	bus := &countBus{}
+	copy(bus.mem[:], []uint8{0x04, 0x18, 0xFD}) // INC B; JR 0000h
+	cpu := New(bus)
+	cpu.SetTranslationCache(true)
+	cpu.RunCycles(4 * 16)
+	if bus.fetches != 8 {
+		t.Fatalf("fetches = %d, want 8", bus.fetches)
+	}
+	// A bank switch inside the Bus needs no InvalidateCode.
+	bus.mem[0] = 0x0C // INC C
+	cpu.RunCycles(16)
+	if cpu.getB() != 4 || cpu.getC() != 1 {
+		t.Errorf("B=%02x C=%02x, want 04 01", cpu.getB(), cpu.getC())
+	}
+}
+
+func TestTranslationCache_SkipsCachedFetches(t *testing.T) {
+	cpu, bus := newCachedCPU()
+	copy(bus.mem[:], []uint8{0x00, 0xCB, 0x00, 0x18, 0xFB}) // NOP; RLC B; JR -5
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+	// The cached NOP is run even though memory now holds INC C.
+	bus.mem[0] = 0x0C
+	cpu.RunCycles(10 * 24)
+	if cpu.getC() != 0 {
+		t.Errorf("C = %02x, translation not used", cpu.getC())
+	}
+	// 11 passes of four M1 cycles each.
+	if cpu.reg.R != 44 {
+		t.Errorf("R = %d, want 44", cpu.reg.R)
+	}
+}
+
+func TestTranslationCache_SelfModifyingCode(t *testing.T) {
+	cpu, bus := newCachedCPU()
+	// 0000 LD A,3Ch; 0002 INC B; 0003 LD (0002h),A; 0006 JR 0002h
+	copy(bus.mem[:], []uint8{0x3E, 0x3C, 0x04, 0x32, 0x02, 0x00, 0x18, 0xFA})
+	cpu.setB(0)
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+	// Second pass through 0002 runs the stored INC A instead of INC B.
+	if cpu.getB() != 1 || cpu.getA() != 0x3D {
+		t.Errorf("B=%02x A=%02x, want 01 3D", cpu.getB(), cpu.getA())
+	}
+}
+
+func TestTranslationCache_WriteToPrefixOperand(t *testing.T) {
+	cpu, bus := newCachedCPU()
+	copy(bus.mem[:], []uint8{0xCB, 0x00, 0x18, 0xFC}) // RLC B; JR 0000h
+	cpu.setB(0x01)
+	cpu.Step()
+	cpu.Step()
+	cpu.writeBus(0x0001, 0x08) // RRC B
+	cpu.Step()
+	if cpu.getB() != 0x01 {
+		t.Errorf("B = %02x, want 01", cpu.getB())
+	}
+}
+
+func TestTranslationCache_InvalidateCode(t *testing.T) {
+	cpu, bus := newCachedCPU()
+	copy(bus.mem[:], []uint8{0x04, 0x18, 0xFD}) // INC B; JR 0000h
+	cpu.Step()
+	cpu.Step()
+	bus.mem[0] = 0x0C // INC C, stored behind the CPU's back
+	cpu.Step()
+	if cpu.getB() != 2 {
+		t.Fatalf("stale translation not used: B = %02x", cpu.getB())
+	}
+	cpu.InvalidateCode(0x0000, 1)
+	cpu.Step()
+	cpu.Step()
+	if cpu.getB() != 2 || cpu.getC() != 0x01 {
+		t.Errorf("B=%02x C=%02x, want 02 01", cpu.getB(), cpu.getC())
+	}
+}
+
+func TestTranslationCache_MappedPages(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	cpu.SetTranslationCache(true)
+	ram := make([]uint8, PageSize)
+	// 0000 INC B; 0001 LD A,3Ch; 0003 LD (0000h),A; 0006 JR 0000h
+	copy(ram, []uint8{0x04, 0x3E, 0x3C, 0x32, 0x00, 0x00, 0x18, 0xF8})
+	cpu.MapMemory(0x0000, ram, PageRead|PageWrite)
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+	// The mapped write at 0000 must still invalidate: INC B, then INC A.
+	if cpu.getB() != 1 || cpu.getA() != 0x3D {
+		t.Errorf("B=%02x A=%02x, want 01 3D", cpu.getB(), cpu.getA())
+	}
+
+	m1 := make([]uint8, PageSize)
+	copy(m1, []uint8{0x00, 0x18, 0xFD})
+	cpu.MapMemory(0x0100, m1, PageRead|PageM1)
+	cpu.reg.PC = 0x0100
+	before := bus.fetches
+	for i := 0; i < 10; i++ {
+		cpu.Step()
+	}
+	if bus.fetches-before != 10 {
+		t.Errorf("M1 page fetches = %d, want 10", bus.fetches-before)
+	}
+}
+
+func TestTranslationCache_Interrupts(t *testing.T) {
+	cpu, bus := newCachedCPU()
+	copy(bus.mem[:], []uint8{0x00, 0x18, 0xFD}) // NOP; JR 0000h
+	bus.mem[0x38] = 0x76
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 1
+	cpu.reg.SP = 0xFFFE
+	cpu.Step()
+	cpu.INT(true, 0xFF)
+	if cycles := cpu.Step(); cycles != 13 || cpu.reg.PC != 0x0038 {
+		t.Errorf("cycles=%d PC=%04x, want 13 0038", cycles, cpu.reg.PC)
+	}
+
+	// Under RunCycles the interrupt is taken at the first boundary after
+	// it is asserted, and the chain is left for HALT.
+	cpu.INT(false, 0xFF)
+	cpu.reg.PC, cpu.reg.Halted, cpu.reg.IFF1 = 0, false, true
+	cpu.RunCycles(100)
+	cpu.INT(true, 0xFF)
+	cpu.RunCycles(1)
+	if cpu.reg.PC != 0x0038 || cpu.reg.IFF1 {
+		t.Errorf("PC=%04x IFF1=%v, want 0038 false", cpu.reg.PC, cpu.reg.IFF1)
+	}
+}
+
+func TestTranslationCache_Toggle(t *testing.T) {
+	bus := &countBus{}
+	cpu := New(bus)
+	cpu.SetTranslationCache(true)
+	cpu.Step()
+	cpu.SetTranslationCache(false)
+	cpu.reg.PC = 0
+	cpu.Step()
+	if bus.fetches != 2 {
+		t.Errorf("fetches = %d, want 2", bus.fetches)
+	}
+	if err := New(&testBus{}, WithVariant(VariantR800)).SetTranslationCache(true); err == nil {
+		t.Error("R800 accepted the translation cache")
+	}
+}
@@ -1,9 +1,6 @@
 package z80
 
-import (
-	"fmt"
-	"testing"
-)
+import "testing"
 
 // z80State describes the full Z80 state for a single-step test case.
 type z80State struct {
@@ -14,6 +11,9 @@ type z80State struct {
 	AF_, BC_, DE_, HL_     uint16
 	IM                     uint8
 	IFF1, IFF2             bool
+	Halted                 bool
+	WZ                     uint16      // MEMPTR
+	CheckWZ                bool        // want only: compare WZ
 	RAM                    [][2]uint16 // {{addr, val}, ...}
 	Ports                  [][2]uint16 // {{port, val}, ...} for input ports
 	Cycles                 int         // 0 = don't check
@@ -53,75 +53,56 @@ func runSSTTest(t *testing.T, tc struct {
 
 		cpu := New(bus)
 		cpu.SetState(Registers{
-			AF:   uint16(tc.init.A)<<8 | uint16(tc.init.F),
-			BC:   uint16(tc.init.B)<<8 | uint16(tc.init.C),
-			DE:   uint16(tc.init.D)<<8 | uint16(tc.init.E),
-			HL:   uint16(tc.init.H)<<8 | uint16(tc.init.L),
-			AF_:  tc.init.AF_,
-			BC_:  tc.init.BC_,
-			DE_:  tc.init.DE_,
-			HL_:  tc.init.HL_,
-			IX:   tc.init.IX,
-			IY:   tc.init.IY,
-			SP:   tc.init.SP,
-			PC:   tc.init.PC,
-			I:    tc.init.I,
-			R:    tc.init.R,
-			IFF1: tc.init.IFF1,
-			IFF2: tc.init.IFF2,
-			IM:   tc.init.IM,
+			AF:     uint16(tc.init.A)<<8 | uint16(tc.init.F),
+			BC:     uint16(tc.init.B)<<8 | uint16(tc.init.C),
+			DE:     uint16(tc.init.D)<<8 | uint16(tc.init.E),
+			HL:     uint16(tc.init.H)<<8 | uint16(tc.init.L),
+			AF_:    tc.init.AF_,
+			BC_:    tc.init.BC_,
+			DE_:    tc.init.DE_,
+			HL_:    tc.init.HL_,
+			IX:     tc.init.IX,
+			IY:     tc.init.IY,
+			SP:     tc.init.SP,
+			PC:     tc.init.PC,
+			I:      tc.init.I,
+			R:      tc.init.R,
+			IFF1:   tc.init.IFF1,
+			IFF2:   tc.init.IFF2,
+			IM:     tc.init.IM,
+			Halted: tc.init.Halted,
+			WZ:     tc.init.WZ,
 		})
 
 		cycles := cpu.Step()
 		regs := cpu.Registers()
 
-		gotA := uint8(regs.AF >> 8)
-		gotF := uint8(regs.AF)
-		gotB := uint8(regs.BC >> 8)
-		gotC := uint8(regs.BC)
-		gotD := uint8(regs.DE >> 8)
-		gotE := uint8(regs.DE)
-		gotH := uint8(regs.HL >> 8)
-		gotL := uint8(regs.HL)
-
-		check := func(name string, got, want uint8) {
-			if got != want {
-				t.Errorf("%s = 0x%02X, want 0x%02X", name, got, want)
-			}
-		}
-		check16 := func(name string, got, want uint16) {
-			if got != want {
-				t.Errorf("%s = 0x%04X, want 0x%04X", name, got, want)
-			}
-		}
-
-		check("A", gotA, tc.want.A)
-		if gotF != tc.want.F {
-			t.Errorf("F = 0x%02X, want 0x%02X  %s", gotF, tc.want.F, flagDiff(gotF, tc.want.F))
+		want := Registers{
+			AF:     uint16(tc.want.A)<<8 | uint16(tc.want.F),
+			BC:     uint16(tc.want.B)<<8 | uint16(tc.want.C),
+			DE:     uint16(tc.want.D)<<8 | uint16(tc.want.E),
+			HL:     uint16(tc.want.H)<<8 | uint16(tc.want.L),
+			AF_:    tc.want.AF_,
+			BC_:    tc.want.BC_,
+			DE_:    tc.want.DE_,
+			HL_:    tc.want.HL_,
+			IX:     tc.want.IX,
+			IY:     tc.want.IY,
+			SP:     tc.want.SP,
+			PC:     tc.want.PC,
+			I:      tc.want.I,
+			R:      tc.want.R,
+			IFF1:   tc.want.IFF1,
+			IFF2:   tc.want.IFF2,
+			IM:     tc.want.IM,
+			Halted: tc.want.Halted,
+			WZ:     regs.WZ,
 		}
-		check("B", gotB, tc.want.B)
-		check("C", gotC, tc.want.C)
-		check("D", gotD, tc.want.D)
-		check("E", gotE, tc.want.E)
-		check("H", gotH, tc.want.H)
-		check("L", gotL, tc.want.L)
-		check("I", regs.I, tc.want.I)
-		check("R", regs.R, tc.want.R)
-		check16("PC", regs.PC, tc.want.PC)
-		check16("SP", regs.SP, tc.want.SP)
-		check16("IX", regs.IX, tc.want.IX)
-		check16("IY", regs.IY, tc.want.IY)
-		check16("AF'", regs.AF_, tc.want.AF_)
-		check16("BC'", regs.BC_, tc.want.BC_)
-		check16("DE'", regs.DE_, tc.want.DE_)
-		check16("HL'", regs.HL_, tc.want.HL_)
-		check("IM", regs.IM, tc.want.IM)
-
-		if regs.IFF1 != tc.want.IFF1 {
-			t.Errorf("IFF1 = %v, want %v", regs.IFF1, tc.want.IFF1)
+		if tc.want.CheckWZ {
+			want.WZ = tc.want.WZ
 		}
-		if regs.IFF2 != tc.want.IFF2 {
-			t.Errorf("IFF2 = %v, want %v", regs.IFF2, tc.want.IFF2)
+		for _, d := range DiffRegisters(regs, want) {
+			t.Error(d)
 		}
 
 		for _, entry := range tc.want.RAM {
@@ -137,20 +118,3 @@ func runSSTTest(t *testing.T, tc struct {
 		}
 	})
 }
-
-// flagDiff returns a human-readable flag-by-flag comparison.
-func flagDiff(got, want uint8) string {
-	names := [8]string{"C", "N", "PV", "F3", "H", "F5", "Z", "S"}
-	var s string
-	for i := 0; i < 8; i++ {
-		g := (got >> uint(i)) & 1
-		w := (want >> uint(i)) & 1
-		if g != w {
-			if s != "" {
-				s += " "
-			}
-			s += fmt.Sprintf("%s:%d->%d", names[i], w, g)
-		}
-	}
-	return "[" + s + "]"
-}
@@ -0,0 +1,511 @@
+package z80
+
+import "iter"
+
+// MCycleType identifies the kind of machine cycle the CPU is performing.
+type MCycleType uint8
+
+const (
+	// MCycleNone means no cycle has run yet.
+	MCycleNone MCycleType = iota
+	// MCycleM1 is an opcode fetch.
+	MCycleM1
+	// MCycleMR is a memory read.
+	MCycleMR
+	// MCycleMW is a memory write.
+	MCycleMW
+	// MCycleIOR is an I/O read.
+	MCycleIOR
+	// MCycleIOW is an I/O write.
+	MCycleIOW
+	// MCycleINTA is an interrupt acknowledge.
+	MCycleINTA
+	// MCycleInternal is an internal operation with no bus access.
+	MCycleInternal
+)
+
+func (t MCycleType) String() string {
+	switch t {
+	case MCycleM1:
+		return "M1"
+	case MCycleMR:
+		return "MR"
+	case MCycleMW:
+		return "MW"
+	case MCycleIOR:
+		return "IOR"
+	case MCycleIOW:
+		return "IOW"
+	case MCycleINTA:
+		return "INTA"
+	case MCycleInternal:
+		return "internal"
+	}
+	return "none"
+}
+
+// MCycle describes the machine cycle containing the last T-state run by
+// Tick.
+type MCycle struct {
+	Type MCycleType
+	Addr uint16 // Address bus: memory address or full 16-bit port
+	Data uint8  // Data bus; for reads, valid from the access T-state on
+	T    int    // T-state within the cycle, from 1
+	Len  int    // Length of the cycle in T-states
+}
+
+// Access reports whether the bus transfer of the cycle has happened:
+// the data is latched on T3 of a memory cycle and T4 of an I/O cycle.
+func (m MCycle) Access() bool {
+	return m.T >= accessT(m.Type, m.Len)
+}
+
+// accessT returns the T-state on which a cycle's bus transfer happens.
+func accessT(t MCycleType, n int) int {
+	at := 3
+	switch t {
+	case MCycleIOR, MCycleIOW:
+		at = 4
+	case MCycleINTA:
+		at = 5
+	}
+	return min(at, n)
+}
+
+// cycleLens gives the length of each bus cycle type on a variant; any
+// further T-states of an instruction are internal.
+type cycleLens struct{ m1, mem, io int }
+
+func (c *CPU) cycleLens() cycleLens {
+	switch c.variant {
+	case Variant8080:
+		return cycleLens{4, 3, 3}
+	case VariantR800:
+		return cycleLens{1, 1, 1}
+	case VariantLR35902:
+		return cycleLens{4, 4, 4}
+	}
+	return cycleLens{4, 3, 4}
+}
+
+// tickAccess is a bus cycle of the instruction in progress.
+type tickAccess struct {
+	typ  MCycleType
+	data uint8
+}
+
+// ticker runs one instruction at a time as a coroutine, advanced by Tick.
+type ticker struct {
+	c     *CPU
+	lens  cycleLens
+	next  func() (struct{}, bool)
+	stop  func()
+	yield func(struct{}) bool
+
+	active   bool   // an instruction is in progress
+	finished bool   // the instruction's code has returned
+	aborting bool   // Reset is draining the instruction
+	total    int    // T-states of the finished instruction
+	base     uint64 // Cycles at the start of the instruction
+	elapsed  int    // T-states run so far in the instruction
+
+	cur     MCycle
+	pending bool // req is waiting to become the current cycle
+	req     MCycle
+	pseudo  bool // req/cur has no real bus transfer
+	curBus  bool // cur is a real bus transfer
+	gapDone bool // internal cycle before req already inserted
+	log     []tickAccess
+
+	pins    Pins
+	rfsh    uint16 // refresh address of the last M1 cycle
+	pinHook func(cycle uint64, p Pins)
+}
+
+// Tick advances the CPU by one T-state and reports whether an
+// instruction (or interrupt response, or HALT cycle) has just finished.
+//
+// Under Tick each bus access happens on the T-state where it happens on
+// the Z80: data is transferred on T3 of M1 and memory cycles and T4 of
+// I/O cycles, and internal T-states fall between the cycles as on
+// hardware. MCycle reports the cycle in progress. Interrupts are checked
+// at instruction boundaries, as with Step. Cycles and the registers are
+// updated as the instruction's code runs, so they are only consistent at
+// a boundary; Step, StepCycles and RunCycles first finish an instruction
+// left part-way by Tick.
+//
+// Cycle placement follows the Z80, 8080 and LR35902. On the R800 each
+// access is one cycle, and on the eZ80 ADL-mode and suffixed
+// instructions make their accesses without bus cycles, so there the
+// remainder of each instruction is reported as an internal cycle at its
+// end.
+func (c *CPU) Tick() bool {
+	t := c.tick
+	if t == nil {
+		t = c.newTicker()
+	}
+	if !t.active {
+		t.start()
+	} else if t.cur.T >= t.cur.Len {
+		t.advance()
+	}
+	t.cur.T++
+	t.elapsed++
+	if t.curBus && t.cur.T == accessT(t.cur.Type, t.cur.Len) {
+		t.transfer()
+	}
+	done := t.cur.T >= t.cur.Len && t.finished && !t.pending && t.elapsed >= t.total
+	t.updatePins()
+	if done {
+		t.active = false
+		c.setBus()
+	}
+	return done
+}
+
+// newTicker sets up Tick's state on first use.
+func (c *CPU) newTicker() *ticker {
+	t := &ticker{c: c, lens: c.cycleLens()}
+	c.tick = t
+	return t
+}
+
+// TickMCycle runs Tick to the end of the current machine cycle and
+// returns the number of T-states run.
+func (c *CPU) TickMCycle() int {
+	n := 0
+	for {
+		done := c.Tick()
+		n++
+		if done || c.tick.cur.T >= c.tick.cur.Len {
+			return n
+		}
+	}
+}
+
+// MCycle returns the machine cycle containing the last T-state run by
+// Tick.
+func (c *CPU) MCycle() MCycle {
+	if c.tick == nil {
+		return MCycle{}
+	}
+	return c.tick.cur
+}
+
+// MidInstruction reports whether Tick has left an instruction part-way.
+func (c *CPU) MidInstruction() bool {
+	return c.tick != nil && c.tick.active
+}
+
+// finishTick runs Tick until the instruction in progress completes and
+// returns the T-states that took.
+func (c *CPU) finishTick() int {
+	n := 0
+	for {
+		n++
+		if c.Tick() {
+			return n
+		}
+	}
+}
+
+// start begins the next instruction.
+func (t *ticker) start() {
+	t.active = true
+	t.base = t.c.cycles
+	t.finished = false
+	t.elapsed = 0
+	t.log = t.log[:0]
+	t.next, t.stop = iter.Pull(t.run)
+	t.resume()
+	t.advance()
+}
+
+// run is the coroutine body: one Step's worth of work.
+func (t *ticker) run(yield func(struct{}) bool) {
+	t.yield = yield
+	t.total = t.c.stepCounted()
+	t.finished = true
+}
+
+// resume runs the instruction's code until its next bus cycle or its end.
+func (t *ticker) resume() {
+	c := t.c
+	c.ticking = true
+	c.setBus()
+	t.next()
+	c.ticking = false
+	c.setBus()
+}
+
+// request is called by the instruction's code for each bus cycle and
+// suspends it until Tick performs the transfer. n overrides the cycle
+// length for cycles that are not plain bus transfers.
+func (t *ticker) request(typ MCycleType, addr uint16, data uint8, n int) uint8 {
+	if t.aborting {
+		return 0xFF
+	}
+	if n == 0 {
+		switch typ {
+		case MCycleM1:
+			n = t.lens.m1
+		case MCycleIOR, MCycleIOW:
+			n = t.lens.io
+		default:
+			n = t.lens.mem
+		}
+	}
+	t.req = MCycle{Type: typ, Addr: addr, Data: data, Len: n}
+	t.pending = true
+	if !t.yield(struct{}{}) {
+		return 0xFF
+	}
+	return t.cur.Data
+}
+
+// advance moves to the cycle after the current one: an internal cycle
+// ahead of the next bus cycle where the Z80 has one, the next bus cycle,
+// or the T-states left at the end of the instruction.
+func (t *ticker) advance() {
+	t.curBus = false
+	if t.pending {
+		if !t.gapDone && len(t.log) > 0 {
+			if n := t.c.tickGap(t.log, t.req.Type); n > 0 {
+				t.gapDone = true
+				t.cur = MCycle{Type: MCycleInternal, Addr: t.cur.Addr, Len: n}
+				return
+			}
+		}
+		t.gapDone = false
+		t.pending = false
+		t.cur = t.req
+		t.curBus = true
+		return
+	}
+	t.cur = MCycle{Type: MCycleInternal, Addr: t.cur.Addr, Len: max(t.total-t.elapsed, 1)}
+}
+
+// transfer performs the current cycle's bus access and lets the
+// instruction's code run on to its next cycle.
+func (t *ticker) transfer() {
+	c := t.c
+	cur := &t.cur
+	if !t.pseudo {
+		switch cur.Type {
+		case MCycleM1:
+			cur.Data = c.memFetch(cur.Addr)
+		case MCycleMR:
+			cur.Data = c.memRead(cur.Addr)
+		case MCycleMW:
+			c.memWrite(cur.Addr, cur.Data)
+		case MCycleIOR:
+			cur.Data = c.ioIn(cur.Addr)
+		case MCycleIOW:
+			c.ioOut(cur.Addr, cur.Data)
+		}
+	}
+	if cur.Type == MCycleM1 {
+		t.rfsh = uint16(c.reg.I)<<8 | uint16(c.reg.R)
+	}
+	t.pseudo = false
+	t.log = append(t.log, tickAccess{cur.Type, cur.Data})
+	t.resume()
+}
+
+// abort abandons an instruction left part-way by Tick, for Reset and
+// state loading. Stopping the coroutine makes the instruction's pending
+// and remaining bus requests return at once, so its code runs out
+// without touching the bus.
+func (t *ticker) abort() {
+	if !t.active {
+		return
+	}
+	c := t.c
+	t.aborting = true
+	c.ticking = true
+	c.setBus()
+	t.stop()
+	c.ticking = false
+	t.aborting = false
+	t.active = false
+	c.setBus()
+	t.pending = false
+	t.gapDone = false
+	t.cur = MCycle{}
+}
+
+// busCycle reports a cycle that is not a Bus call, such as an interrupt
+// acknowledge or a HALT cycle, for Pins while bus tracking is on and,
+// under Tick, to the ticker.
+func (c *CPU) busCycle(typ MCycleType, addr uint16, data uint8, n int) {
+	if c.slow {
+		c.busCycleSlow(typ, addr, data, n)
+	}
+}
+
+// busCycleSlow is busCycle when tracking or Tick is on.
+func (c *CPU) busCycleSlow(typ MCycleType, addr uint16, data uint8, n int) {
+	if c.tracking {
+		c.lastBus = busAccess{typ, addr, data}
+	}
+	if !c.ticking || c.r800 != nil {
+		return
+	}
+	t := c.tick
+	t.pseudo = true
+	t.request(typ, addr, data, n)
+}
+
+// tickGap returns the internal T-states between the last bus cycle in
+// log and the next one, of type next.
+func (c *CPU) tickGap(log []tickAccess, next MCycleType) int {
+	k := len(log) - 1
+	switch c.variant {
+	case VariantZ80, VariantEZ80:
+		return z80Gap(log, k, next)
+	case Variant8080:
+		return i8080Gap(log, k)
+	case VariantLR35902:
+		return gbGap(log, k)
+	}
+	return 0
+}
+
+// z80Gap places the Z80's internal T-states. k is the index of the last
+// bus cycle. Internal T-states at the end of an instruction need no rule.
+func z80Gap(log []tickAccess, k int, next MCycleType) int {
+	if log[0].typ != MCycleM1 {
+		return 0
+	}
+	switch op := log[0].data; op {
+	case 0xCB:
+		// RLC..SET (HL): 4,4,4,3
+		if k == 2 && next == MCycleMW {
+			return 1
+		}
+	case 0xED:
+		if k < 1 {
+			return 0
+		}
+		switch log[1].data {
+		case 0x67, 0x6F: // RRD, RLD: 4,4,3,4,3
+			if k == 2 {
+				return 4
+			}
+		case 0xA2, 0xAA, 0xB2, 0xBA, 0xA3, 0xAB, 0xB3, 0xBB: // block I/O: 4,5,...
+			if k == 1 {
+				return 1
+			}
+		}
+	case 0xDD, 0xFD:
+		if k < 1 {
+			return 0
+		}
+		op := log[1].data
+		switch {
+		case op == 0xCB: // DD CB d op: 4,4,3,5,4,3
+			if k == 3 {
+				return 2
+			}
+			if k == 4 && next == MCycleMW {
+				return 1
+			}
+			return 0
+		case op == 0x36: // LD (IX+d),n: 4,4,3,5,3
+			if k == 3 {
+				return 2
+			}
+			return 0
+		case op == 0x34 || op == 0x35: // INC/DEC (IX+d): 4,4,3,5,4,3
+			if k == 2 {
+				return 5
+			}
+			if k == 3 {
+				return 1
+			}
+			return 0
+		case indexedOperand(op): // 4,4,3,5,3
+			if k == 2 {
+				return 5
+			}
+			return 0
+		}
+		return z80BaseGap(op, k-1)
+	}
+	return z80BaseGap(log[0].data, k)
+}
+
+// indexedOperand reports whether op takes an (IX+d) operand after DD/FD.
+func indexedOperand(op uint8) bool {
+	switch {
+	case op == 0x76:
+		return false
+	case op >= 0x40 && op < 0x80:
+		return op&7 == 6 || op&0xF8 == 0x70
+	case op >= 0x80 && op < 0xC0:
+		return op&7 == 6
+	}
+	return false
+}
+
+// z80BaseGap places internal T-states for unprefixed opcodes.
+func z80BaseGap(op uint8, k int) int {
+	switch {
+	case op&0xCF == 0xC5, op&0xC7 == 0xC7, op&0xC7 == 0xC0, op == 0x10:
+		// PUSH, RST, RET cc, DJNZ: 5 T-state M1
+		if k == 0 {
+			return 1
+		}
+	case op == 0xCD, op&0xC7 == 0xC4:
+		// CALL: 4,3,4,3,3
+		if k == 2 {
+			return 1
+		}
+	case op == 0xE3:
+		// EX (SP),HL: 4,3,4,3,5
+		if k == 2 {
+			return 1
+		}
+	case op == 0x34, op == 0x35:
+		// INC/DEC (HL): 4,4,3
+		if k == 1 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// i8080Gap places the 8080's internal T-states.
+func i8080Gap(log []tickAccess, k int) int {
+	if k != 0 || log[0].typ != MCycleM1 {
+		return 0
+	}
+	// PUSH, RST, Rcc and CALL have a 5 T-state M1.
+	op := log[0].data
+	if op&0xCF == 0xC5 || op&0xC7 == 0xC7 || op&0xC7 == 0xC0 || op&0xC7 == 0xC4 ||
+		op == 0xCD || op == 0xDD || op == 0xED || op == 0xFD {
+		return 1
+	}
+	return 0
+}
+
+// gbGap places the LR35902's internal M-cycles.
+func gbGap(log []tickAccess, k int) int {
+	if log[0].typ != MCycleM1 {
+		return 0
+	}
+	op := log[0].data
+	switch {
+	case op&0xCF == 0xC5, op&0xC7 == 0xC7, op&0xE7 == 0xC0:
+		// PUSH, RST, RET cc: internal cycle after the opcode
+		if k == 0 {
+			return 4
+		}
+	case op == 0xCD, op&0xE7 == 0xC4:
+		// CALL: internal cycle before the pushes
+		if k == 2 {
+			return 4
+		}
+	}
+	return 0
+}
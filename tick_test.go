@@ -0,0 +1,232 @@
+package z80
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// tickProgram places an instruction at 0100h followed by operand bytes
+// that keep every address it touches inside the test bus.
+func tickProgram(bus *testBus, code ...uint8) {
+	copy(bus.mem[0x0100:], code)
+	copy(bus.mem[0x0100+len(code):], []uint8{0x10, 0x20, 0x30, 0x40})
+}
+
+func newTickPair(v Variant, code ...uint8) (*CPU, *CPU, *testBus, *testBus) {
+	var cpus [2]*CPU
+	var buses [2]*testBus
+	for i := range cpus {
+		buses[i] = &testBus{}
+		tickProgram(buses[i], code...)
+		cpus[i] = New(buses[i], WithVariant(v))
+		cpus[i].reg.PC = 0x0100
+		cpus[i].reg.SP = 0x8000
+		cpus[i].reg.HL = 0x9000
+		cpus[i].reg.IX = 0xA000
+		cpus[i].reg.IY = 0xB000
+		cpus[i].reg.BC = 0x0203
+	}
+	return cpus[0], cpus[1], buses[0], buses[1]
+}
+
+func tickInstruction(c *CPU) int {
+	n := 1
+	for !c.Tick() {
+		n++
+	}
+	return n
+}
+
+func TestTick_MatchesStep(t *testing.T) {
+	var codes [][]uint8
+	for op := range 256 {
+		codes = append(codes, []uint8{uint8(op)},
+			[]uint8{0xCB, uint8(op)}, []uint8{0xED, uint8(op)},
+			[]uint8{0xDD, uint8(op), 0x05}, []uint8{0xFD, 0xCB, 0x05, uint8(op)})
+	}
+	for _, v := range []Variant{VariantZ80, Variant8080, VariantR800, VariantEZ80, VariantLR35902} {
+		for _, code := range codes {
+			ref, cpu, refBus, bus := newTickPair(v, code...)
+			want := ref.Step()
+			got := tickInstruction(cpu)
+			name := fmt.Sprintf("%v % X", v, code)
+			if got != want {
+				t.Errorf("%s: Tick took %d T-states, Step %d", name, got, want)
+			}
+			if cpu.reg != ref.reg || cpu.Cycles() != ref.Cycles() {
+				t.Errorf("%s: state differs from Step", name)
+			}
+			if bus.mem != refBus.mem {
+				t.Errorf("%s: memory differs from Step", name)
+			}
+		}
+	}
+}
+
+func TestTick_MatchesStepADL(t *testing.T) {
+	for op := range 256 {
+		for _, code := range [][]uint8{{uint8(op)}, {0xED, uint8(op)}, {0xDD, uint8(op), 0x05}, {0x5B, uint8(op)}} {
+			ref, cpu, refBus, bus := newTickPair(VariantEZ80, code...)
+			for _, c := range []*CPU{ref, cpu} {
+				c.SetEZ80State(EZ80Registers{SPL: 0x8000, ADL: true})
+			}
+			want := ref.Step()
+			got := tickInstruction(cpu)
+			name := fmt.Sprintf("ADL % X", code)
+			if got != want {
+				t.Errorf("%s: Tick took %d T-states, Step %d", name, got, want)
+			}
+			if cpu.reg != ref.reg || cpu.EZ80Registers() != ref.EZ80Registers() || bus.mem != refBus.mem {
+				t.Errorf("%s: state differs from Step", name)
+			}
+		}
+	}
+}
+
+// tickTrace runs one instruction under Tick and returns the type and
+// length of each of its machine cycles, as "M14 MR3 ...".
+func tickTrace(c *CPU) string {
+	s := ""
+	for {
+		done := c.Tick()
+		m := c.MCycle()
+		if m.T == m.Len || done {
+			if s != "" {
+				s += " "
+			}
+			s += fmt.Sprintf("%v%d", m.Type, m.Len)
+		}
+		if done {
+			return s
+		}
+	}
+}
+
+func TestTick_CycleSequences(t *testing.T) {
+	tests := []struct {
+		code []uint8
+		want string
+	}{
+		{[]uint8{0x00}, "M14"},
+		{[]uint8{0x7E}, "M14 MR3"},
+		{[]uint8{0xC5}, "M14 internal1 MW3 MW3"},
+		{[]uint8{0xCD, 0x00, 0x20}, "M14 MR3 MR3 internal1 MW3 MW3"},
+		{[]uint8{0xE3}, "M14 MR3 MR3 internal1 MW3 MW3 internal2"},
+		{[]uint8{0x34}, "M14 MR3 internal1 MW3"},
+		{[]uint8{0xD3, 0x10}, "M14 MR3 IOW4"},
+		{[]uint8{0xDD, 0x7E, 0x05}, "M14 M14 MR3 internal5 MR3"},
+		{[]uint8{0xDD, 0x36, 0x05, 0x99}, "M14 M14 MR3 MR3 internal2 MW3"},
+		{[]uint8{0xDD, 0x34, 0x05}, "M14 M14 MR3 internal5 MR3 internal1 MW3"},
+		{[]uint8{0xDD, 0xCB, 0x05, 0x06}, "M14 M14 MR3 MR3 internal2 MR3 internal1 MW3"},
+		{[]uint8{0xED, 0x6F}, "M14 M14 MR3 internal4 MW3"},
+		{[]uint8{0xED, 0xA3}, "M14 M14 internal1 MR3 IOW4"},
+	}
+	for _, tt := range tests {
+		_, cpu, _, _ := newTickPair(VariantZ80, tt.code...)
+		if got := tickTrace(cpu); got != tt.want {
+			t.Errorf("% X: cycles %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestTick_AccessTiming(t *testing.T) {
+	_, cpu, _, bus := newTickPair(VariantZ80, 0x32, 0x00, 0x40) // LD (4000h),A
+	cpu.setA(0x5A)
+	for i := 1; i <= 12; i++ {
+		cpu.Tick()
+		m := cpu.MCycle()
+		if bus.mem[0x4000] != 0 {
+			t.Fatalf("T%d: written before T3 of the write cycle", i)
+		}
+		if i == 12 && (m.Type != MCycleMW || m.Addr != 0x4000 || m.Data != 0x5A || m.T != 2) {
+			t.Errorf("T12: cycle %+v, want MW 4000 5A T2", m)
+		}
+	}
+	if !cpu.Tick() {
+		t.Fatal("instruction not finished after 13 T-states")
+	}
+	if bus.mem[0x4000] != 0x5A {
+		t.Error("write not done")
+	}
+}
+
+func TestTick_Interrupts(t *testing.T) {
+	_, cpu, _, _ := newTickPair(VariantZ80, 0x00)
+	cpu.reg.IFF1 = true
+	cpu.reg.IM = 2
+	cpu.reg.I = 0x30
+	cpu.INT(true, 0x10)
+	if got := tickTrace(cpu); got != "INTA7 MW3 MW3 MR3 MR3" {
+		t.Errorf("IM 2 cycles %q", got)
+	}
+
+	_, cpu, _, _ = newTickPair(VariantZ80, 0x76)
+	cpu.Step()
+	if got := tickTrace(cpu); got != "M14" {
+		t.Errorf("HALT cycles %q", got)
+	}
+	cpu.NMI() // after the sampling point: one more HALT cycle first
+	tickTrace(cpu)
+	if got := tickTrace(cpu); got != "M15 MW3 MW3" || cpu.reg.PC != 0x0066 {
+		t.Errorf("NMI cycles %q PC=%04X", got, cpu.reg.PC)
+	}
+}
+
+func TestTick_StepFinishesInstruction(t *testing.T) {
+	ref, cpu, _, _ := newTickPair(VariantZ80, 0xCD, 0x00, 0x20)
+	want := ref.Step()
+	for range 5 {
+		cpu.Tick()
+	}
+	if !cpu.MidInstruction() {
+		t.Fatal("MidInstruction = false after 5 T-states")
+	}
+	if got := 5 + cpu.Step(); got != want || cpu.reg != ref.reg {
+		t.Errorf("T-states = %d, want %d; PC=%04X want %04X", got, want, cpu.reg.PC, ref.reg.PC)
+	}
+	if cpu.MidInstruction() {
+		t.Error("MidInstruction after Step")
+	}
+}
+
+func TestTick_ResetAbortsInstruction(t *testing.T) {
+	_, cpu, _, bus := newTickPair(VariantZ80, 0xC5) // PUSH BC
+	for range 6 {
+		cpu.Tick()
+	}
+	cpu.Reset()
+	if cpu.MidInstruction() || cpu.reg.PC != 0 || cpu.Cycles() != 0 {
+		t.Errorf("reset left mid=%v PC=%04X cycles=%d", cpu.MidInstruction(), cpu.reg.PC, cpu.Cycles())
+	}
+	if bus.mem[0x7FFF] != 0 || bus.mem[0x7FFE] != 0 {
+		t.Error("aborted instruction wrote to memory")
+	}
+	if n := tickInstruction(cpu); n != 4 {
+		t.Errorf("first instruction after reset took %d T-states, want 4", n)
+	}
+}
+
+func TestTick_ResetReleasesCoroutine(t *testing.T) {
+	_, cpu, _, _ := newTickPair(VariantZ80, 0xC5) // PUSH BC
+	before := runtime.NumGoroutine()
+	for range 100 {
+		cpu.reg.PC = 0x0100
+		cpu.Tick()
+		cpu.Reset()
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Errorf("goroutines grew from %d to %d over 100 abandoned instructions", before, n)
+	}
+}
+
+func TestTick_TickMCycle(t *testing.T) {
+	_, cpu, _, _ := newTickPair(VariantZ80, 0xC5)
+	got := []int{cpu.TickMCycle()}
+	for cpu.MidInstruction() {
+		got = append(got, cpu.TickMCycle())
+	}
+	if fmt.Sprint(got) != "[4 1 3 3]" {
+		t.Errorf("M-cycle lengths %v, want [4 1 3 3]", got)
+	}
+}
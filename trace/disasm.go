@@ -0,0 +1,323 @@
+package trace
+
+import "fmt"
+
+// Operand names for the Z80 opcode fields; see "Decoding Z80 Opcodes"
+// for the x, y, z, p and q split used below.
+var (
+	regNames   = [8]string{"b", "c", "d", "e", "h", "l", "(hl)", "a"}
+	pairNames  = [4]string{"bc", "de", "hl", "sp"}
+	pair2Names = [4]string{"bc", "de", "hl", "af"}
+	condNames  = [8]string{"nz", "z", "nc", "c", "po", "pe", "p", "m"}
+	aluNames   = [8]string{"add", "adc", "sub", "sbc", "and", "xor", "or", "cp"}
+	rotNames   = [8]string{"rlc", "rrc", "rl", "rr", "sla", "sra", "sll", "srl"}
+	accNames   = [8]string{"rlca", "rrca", "rla", "rra", "daa", "cpl", "scf", "ccf"}
+	imNames    = [8]string{"0", "0", "1", "2", "0", "0", "1", "2"}
+	blockNames = [4][4]string{
+		{"ldi", "cpi", "ini", "outi"},
+		{"ldd", "cpd", "ind", "outd"},
+		{"ldir", "cpir", "inir", "otir"},
+		{"lddr", "cpdr", "indr", "otdr"},
+	}
+)
+
+// disassembler decodes one instruction in MAME's Z80 notation: lower
+// case, the mnemonic padded to five columns, and hex constants as $nn,
+// with relative jumps shown as their target.
+type disassembler struct {
+	peek func(addr uint16) uint8
+	pc   uint16
+	idx  string // "ix" or "iy" after a DD or FD prefix, else ""
+}
+
+// disassemble returns the instruction at pc and its length in bytes.
+func disassemble(peek func(addr uint16) uint8, pc uint16) (string, int) {
+	d := disassembler{peek: peek, pc: pc}
+	s := d.op()
+	return s, int(d.pc - pc)
+}
+
+func (d *disassembler) next() uint8 {
+	v := d.peek(d.pc)
+	d.pc++
+	return v
+}
+
+func (d *disassembler) n8() string {
+	return fmt.Sprintf("$%02X", d.next())
+}
+
+func (d *disassembler) n16() string {
+	lo := d.next()
+	return fmt.Sprintf("$%04X", uint16(d.next())<<8|uint16(lo))
+}
+
+func (d *disassembler) rel() string {
+	e := int8(d.next())
+	return fmt.Sprintf("$%04X", d.pc+uint16(e))
+}
+
+// mem returns (hl), or (ix+d) reading the displacement.
+func (d *disassembler) mem() string {
+	if d.idx == "" {
+		return "(hl)"
+	}
+	e := int8(d.next())
+	if e < 0 {
+		return fmt.Sprintf("(%s-$%02X)", d.idx, -int(e))
+	}
+	return fmt.Sprintf("(%s+$%02X)", d.idx, e)
+}
+
+// reg returns register i of the 3-bit field. H and L become the index
+// halves unless the instruction also uses (ix+d).
+func (d *disassembler) reg(i uint8, indexed bool) string {
+	switch {
+	case i == 6:
+		return d.mem()
+	case d.idx != "" && !indexed && (i == 4 || i == 5):
+		return d.idx + "hl"[i-4:i-3]
+	}
+	return regNames[i]
+}
+
+func (d *disassembler) hl() string {
+	if d.idx != "" {
+		return d.idx
+	}
+	return "hl"
+}
+
+func (d *disassembler) pair(p uint8) string {
+	if p == 2 {
+		return d.hl()
+	}
+	return pairNames[p]
+}
+
+func (d *disassembler) pair2(p uint8) string {
+	if p == 2 {
+		return d.hl()
+	}
+	return pair2Names[p]
+}
+
+func ins(mnemonic string, operands ...any) string {
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	s := fmt.Sprintf("%-4s ", mnemonic)
+	for i, o := range operands {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprint(o)
+	}
+	return s
+}
+
+func (d *disassembler) op() string {
+	op := d.next()
+	for op == 0xDD || op == 0xFD {
+		d.idx = "ix"
+		if op == 0xFD {
+			d.idx = "iy"
+		}
+		op = d.next()
+	}
+	x, y, z := op>>6, op>>3&7, op&7
+	p, q := y>>1, y&1
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				return "nop"
+			case 1:
+				return ins("ex", "af", "af'")
+			case 2:
+				return ins("djnz", d.rel())
+			case 3:
+				return ins("jr", d.rel())
+			}
+			return ins("jr", condNames[y-4], d.rel())
+		case 1:
+			if q == 0 {
+				return ins("ld", d.pair(p), d.n16())
+			}
+			return ins("add", d.hl(), d.pair(p))
+		case 2:
+			switch {
+			case p < 2 && q == 0:
+				return ins("ld", "("+pairNames[p]+")", "a")
+			case p < 2:
+				return ins("ld", "a", "("+pairNames[p]+")")
+			case p == 2 && q == 0:
+				return ins("ld", "("+d.n16()+")", d.hl())
+			case p == 2:
+				return ins("ld", d.hl(), "("+d.n16()+")")
+			case q == 0:
+				return ins("ld", "("+d.n16()+")", "a")
+			}
+			return ins("ld", "a", "("+d.n16()+")")
+		case 3:
+			return ins([2]string{"inc", "dec"}[q], d.pair(p))
+		case 4:
+			return ins("inc", d.reg(y, false))
+		case 5:
+			return ins("dec", d.reg(y, false))
+		case 6:
+			return ins("ld", d.reg(y, false), d.n8())
+		}
+		return accNames[y]
+	case 1:
+		if y == 6 && z == 6 {
+			return "halt"
+		}
+		indexed := y == 6 || z == 6
+		return ins("ld", d.reg(y, indexed), d.reg(z, indexed))
+	case 2:
+		return d.alu(y, d.reg(z, false))
+	}
+	switch z {
+	case 0:
+		return ins("ret", condNames[y])
+	case 1:
+		if q == 0 {
+			return ins("pop", d.pair2(p))
+		}
+		switch p {
+		case 0:
+			return "ret"
+		case 1:
+			return "exx"
+		case 2:
+			return ins("jp", "("+d.hl()+")")
+		}
+		return ins("ld", "sp", d.hl())
+	case 2:
+		return ins("jp", condNames[y], d.n16())
+	case 3:
+		switch y {
+		case 0:
+			return ins("jp", d.n16())
+		case 1:
+			return d.cb()
+		case 2:
+			return ins("out", "("+d.n8()+")", "a")
+		case 3:
+			return ins("in", "a", "("+d.n8()+")")
+		case 4:
+			return ins("ex", "(sp)", d.hl())
+		case 5:
+			return ins("ex", "de", "hl")
+		case 6:
+			return "di"
+		}
+		return "ei"
+	case 4:
+		return ins("call", condNames[y], d.n16())
+	case 5:
+		if q == 0 {
+			return ins("push", d.pair2(p))
+		}
+		if p == 0 {
+			return ins("call", d.n16())
+		}
+		// ED; DD and FD were taken as prefixes above.
+		d.idx = ""
+		return d.ed()
+	case 6:
+		return d.alu(y, d.n8())
+	}
+	return ins("rst", fmt.Sprintf("$%02X", y*8))
+}
+
+func (d *disassembler) alu(y uint8, operand string) string {
+	if y == 0 || y == 1 || y == 3 {
+		return ins(aluNames[y], "a", operand)
+	}
+	return ins(aluNames[y], operand)
+}
+
+// cb decodes a CB-prefixed instruction. After DD or FD the displacement
+// comes before the opcode, and an operation on (ix+d) other than BIT
+// also copies its result to the register in the opcode.
+func (d *disassembler) cb() string {
+	var m string
+	if d.idx != "" {
+		m = d.mem()
+	}
+	op := d.next()
+	x, y, z := op>>6, op>>3&7, op&7
+	var target []any
+	if d.idx == "" {
+		target = []any{regNames[z]}
+	} else {
+		target = []any{m}
+		if z != 6 && x != 1 {
+			target = append(target, regNames[z])
+		}
+	}
+	if x == 0 {
+		return ins(rotNames[y], target...)
+	}
+	return ins([4]string{"", "bit", "res", "set"}[x], append([]any{y}, target...)...)
+}
+
+// ed decodes an ED-prefixed instruction.
+func (d *disassembler) ed() string {
+	op := d.next()
+	x, y, z := op>>6, op>>3&7, op&7
+	p, q := y>>1, y&1
+	if x == 2 && z <= 3 && y >= 4 {
+		return blockNames[y-4][z]
+	}
+	if x != 1 {
+		return ins("db", "$ED", fmt.Sprintf("$%02X", op))
+	}
+	switch z {
+	case 0:
+		if y == 6 {
+			return ins("in", "f", "(c)")
+		}
+		return ins("in", regNames[y], "(c)")
+	case 1:
+		if y == 6 {
+			return ins("out", "(c)", "0")
+		}
+		return ins("out", "(c)", regNames[y])
+	case 2:
+		return ins([2]string{"sbc", "adc"}[q], "hl", pairNames[p])
+	case 3:
+		if q == 0 {
+			return ins("ld", "("+d.n16()+")", pairNames[p])
+		}
+		return ins("ld", pairNames[p], "("+d.n16()+")")
+	case 4:
+		return "neg"
+	case 5:
+		if y == 1 {
+			return "reti"
+		}
+		return "retn"
+	case 6:
+		return ins("im", imNames[y])
+	}
+	switch y {
+	case 0:
+		return ins("ld", "i", "a")
+	case 1:
+		return ins("ld", "r", "a")
+	case 2:
+		return ins("ld", "a", "i")
+	case 3:
+		return ins("ld", "a", "r")
+	case 4:
+		return "rrd"
+	case 5:
+		return "rld"
+	}
+	return "nop"
+}
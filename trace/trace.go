@@ -0,0 +1,109 @@
+// Package trace writes per-instruction execution traces in the formats
+// of other Z80 emulators, so that the same program can be run in both
+// and the logs compared with diff.
+//
+// A Writer takes the registers reported by CPU.SetTraceHook before each
+// instruction:
+//
+//	w := trace.NewMAME(f, bus.Read)
+//	cpu.SetTraceHook(w.Instruction)
+//	for cpu.Cycles() < n {
+//	    cpu.Step()
+//	}
+//	err := w.Close()
+//
+// Two formats are written. NewFUSE writes the state lines of FUSE's core
+// tests (tests.expected). NewMAME writes the lines of MAME's debugger
+// trace command with a register prefix; see NewMAME for the command that
+// makes MAME write the same.
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// Writer writes one trace entry per instruction.
+type Writer struct {
+	w     *bufio.Writer
+	entry entryFunc
+	err   error
+}
+
+type entryFunc func(w *bufio.Writer, cycle uint64, regs z80.Registers)
+
+func newWriter(w io.Writer, entry entryFunc) *Writer {
+	return &Writer{w: bufio.NewWriter(w), entry: entry}
+}
+
+// NewFUSE returns a Writer for FUSE's test format. Each instruction gives
+// two lines, in lower-case hex as FUSE writes them:
+//
+//	AF BC DE HL AF' BC' DE' HL' IX IY SP PC MEMPTR
+//	I R IFF1 IFF2 IM halted tstates
+//
+// MEMPTR is Registers.WZ and tstates is the CPU's cycle count.
+func NewFUSE(w io.Writer) *Writer {
+	return newWriter(w, func(w *bufio.Writer, cycle uint64, r z80.Registers) {
+		fmt.Fprintf(w, "%04x %04x %04x %04x %04x %04x %04x %04x ",
+			r.AF, r.BC, r.DE, r.HL, r.AF_, r.BC_, r.DE_, r.HL_)
+		fmt.Fprintf(w, "%04x %04x %04x %04x %04x\n", r.IX, r.IY, r.SP, r.PC, r.WZ)
+		fmt.Fprintf(w, "%02x %02x %d %d %d %d %d\n",
+			r.I, r.R, bit(r.IFF1), bit(r.IFF2), r.IM, bit(r.Halted), cycle)
+	})
+}
+
+// NewMAME returns a Writer for MAME's trace format: the registers, then
+// the address and disassembly of the instruction, read through peek,
+// which must not have side effects:
+//
+//	AF=FFFF BC=0000 DE=0000 HL=0000 IX=FFFF IY=FFFF SP=FFFF 0000: di
+//
+// MAME writes the same from its debugger with
+//
+//	trace z80.tr,maincpu,noloop,{tracelog "AF=%04X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X ",af,bc,de,hl,ix,iy,sp}
+//
+// The disassembly follows MAME's Z80 disassembler.
+func NewMAME(w io.Writer, peek func(addr uint16) uint8) *Writer {
+	return newWriter(w, func(w *bufio.Writer, cycle uint64, r z80.Registers) {
+		text, _ := disassemble(peek, r.PC)
+		fmt.Fprintf(w, "AF=%04X BC=%04X DE=%04X HL=%04X ", r.AF, r.BC, r.DE, r.HL)
+		fmt.Fprintf(w, "IX=%04X IY=%04X SP=%04X %04X: %s\n",
+			r.IX, r.IY, r.SP, r.PC, text)
+	})
+}
+
+// Instruction writes the entry for the instruction about to run. Its
+// signature matches CPU.SetTraceHook.
+func (t *Writer) Instruction(cycle uint64, regs z80.Registers) {
+	if t.err != nil {
+		return
+	}
+	t.entry(t.w, cycle, regs)
+}
+
+// Flush writes any buffered output to the underlying writer and returns
+// the first error met while writing.
+func (t *Writer) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	t.err = t.w.Flush()
+	return t.err
+}
+
+// Close flushes the trace. The underlying writer is not closed; that is
+// left to the caller.
+func (t *Writer) Close() error {
+	return t.Flush()
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+type ram [65536]uint8
+
+func (r *ram) Fetch(addr uint16) uint8      { return r[addr] }
+func (r *ram) Read(addr uint16) uint8       { return r[addr] }
+func (r *ram) Write(addr uint16, val uint8) { r[addr] = val }
+func (r *ram) In(port uint16) uint8         { return 0xFF }
+func (r *ram) Out(port uint16, val uint8)   {}
+
+func TestDisassemble(t *testing.T) {
+	for _, tc := range []struct {
+		code []uint8
+		want string
+	}{
+		{[]uint8{0x00}, "nop"},
+		{[]uint8{0x08}, "ex   af,af'"},
+		{[]uint8{0x10, 0xFE}, "djnz $1000"},
+		{[]uint8{0x20, 0x05}, "jr   nz,$1007"},
+		{[]uint8{0x21, 0x34, 0x12}, "ld   hl,$1234"},
+		{[]uint8{0x22, 0x34, 0x12}, "ld   ($1234),hl"},
+		{[]uint8{0x3A, 0x00, 0x80}, "ld   a,($8000)"},
+		{[]uint8{0x0A}, "ld   a,(bc)"},
+		{[]uint8{0x36, 0x7F}, "ld   (hl),$7F"},
+		{[]uint8{0x3B}, "dec  sp"},
+		{[]uint8{0x17}, "rla"},
+		{[]uint8{0x76}, "halt"},
+		{[]uint8{0x78}, "ld   a,b"},
+		{[]uint8{0x86}, "add  a,(hl)"},
+		{[]uint8{0x96}, "sub  (hl)"},
+		{[]uint8{0x9F}, "sbc  a,a"},
+		{[]uint8{0xFE, 0x10}, "cp   $10"},
+		{[]uint8{0xC0}, "ret  nz"},
+		{[]uint8{0xF5}, "push af"},
+		{[]uint8{0xE9}, "jp   (hl)"},
+		{[]uint8{0xCA, 0x00, 0x20}, "jp   z,$2000"},
+		{[]uint8{0xCD, 0x00, 0x20}, "call $2000"},
+		{[]uint8{0xD3, 0xFE}, "out  ($FE),a"},
+		{[]uint8{0xDB, 0xFE}, "in   a,($FE)"},
+		{[]uint8{0xE3}, "ex   (sp),hl"},
+		{[]uint8{0xEB}, "ex   de,hl"},
+		{[]uint8{0xFF}, "rst  $38"},
+		{[]uint8{0xCB, 0x07}, "rlc  a"},
+		{[]uint8{0xCB, 0x36}, "sll  (hl)"},
+		{[]uint8{0xCB, 0x7E}, "bit  7,(hl)"},
+		{[]uint8{0xCB, 0xC1}, "set  0,c"},
+		{[]uint8{0xED, 0x4A}, "adc  hl,bc"},
+		{[]uint8{0xED, 0x73, 0x00, 0xF0}, "ld   ($F000),sp"},
+		{[]uint8{0xED, 0x56}, "im   1"},
+		{[]uint8{0xED, 0x4D}, "reti"},
+		{[]uint8{0xED, 0x57}, "ld   a,i"},
+		{[]uint8{0xED, 0x78}, "in   a,(c)"},
+		{[]uint8{0xED, 0x70}, "in   f,(c)"},
+		{[]uint8{0xED, 0x71}, "out  (c),0"},
+		{[]uint8{0xED, 0xB0}, "ldir"},
+		{[]uint8{0xED, 0xBB}, "otdr"},
+		{[]uint8{0xED, 0x00}, "db   $ED,$00"},
+		{[]uint8{0xDD, 0x21, 0x00, 0x40}, "ld   ix,$4000"},
+		{[]uint8{0xDD, 0x7E, 0x05}, "ld   a,(ix+$05)"},
+		{[]uint8{0xFD, 0x77, 0xFB}, "ld   (iy-$05),a"},
+		{[]uint8{0xDD, 0x36, 0x02, 0x99}, "ld   (ix+$02),$99"},
+		{[]uint8{0xDD, 0x66, 0x01}, "ld   h,(ix+$01)"},
+		{[]uint8{0xDD, 0x26, 0x12}, "ld   ixh,$12"},
+		{[]uint8{0xFD, 0x7D}, "ld   a,iyl"},
+		{[]uint8{0xDD, 0x09}, "add  ix,bc"},
+		{[]uint8{0xDD, 0x29}, "add  ix,ix"},
+		{[]uint8{0xFD, 0xE5}, "push iy"},
+		{[]uint8{0xDD, 0xE9}, "jp   (ix)"},
+		{[]uint8{0xDD, 0xEB}, "ex   de,hl"},
+		{[]uint8{0xDD, 0xCB, 0x03, 0x46}, "bit  0,(ix+$03)"},
+		{[]uint8{0xFD, 0xCB, 0xFF, 0x06}, "rlc  (iy-$01)"},
+		{[]uint8{0xDD, 0xCB, 0x03, 0x00}, "rlc  (ix+$03),b"},
+		{[]uint8{0xDD, 0xCB, 0x03, 0xC7}, "set  0,(ix+$03),a"},
+	} {
+		var mem ram
+		copy(mem[0x1000:], tc.code)
+		got, n := disassemble(mem.Read, 0x1000)
+		if got != tc.want || n != len(tc.code) {
+			t.Errorf("% X: got %q (%d bytes), want %q (%d bytes)", tc.code, got, n, tc.want, len(tc.code))
+		}
+	}
+}
+
+func TestFUSE(t *testing.T) {
+	var mem ram
+	copy(mem[:], []uint8{0x3E, 0x12, 0x32, 0x00, 0x40, 0x76}) // LD A,12h; LD (4000h),A; HALT
+	cpu := z80.New(&mem)
+	var buf bytes.Buffer
+	w := NewFUSE(&buf)
+	cpu.SetTraceHook(w.Instruction)
+	for range 4 {
+		cpu.Step()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"ffff 0000 0000 0000 0000 0000 0000 0000 0000 0000 ffff 0000 0000",
+		"00 00 0 0 0 0 0",
+		"12ff 0000 0000 0000 0000 0000 0000 0000 0000 0000 ffff 0002 0000",
+		"00 01 0 0 0 0 7",
+		"12ff 0000 0000 0000 0000 0000 0000 0000 0000 0000 ffff 0005 1201",
+		"00 02 0 0 0 0 20",
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMAME(t *testing.T) {
+	var mem ram
+	copy(mem[:], []uint8{0xDD, 0x21, 0x00, 0x40, 0x3E, 0x12, 0x76})
+	cpu := z80.New(&mem)
+	var buf bytes.Buffer
+	w := NewMAME(&buf, mem.Read)
+	cpu.SetTraceHook(w.Instruction)
+	for range 5 {
+		cpu.Step()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"AF=FFFF BC=0000 DE=0000 HL=0000 IX=0000 IY=0000 SP=FFFF 0000: ld   ix,$4000",
+		"AF=FFFF BC=0000 DE=0000 HL=0000 IX=4000 IY=0000 SP=FFFF 0004: ld   a,$12",
+		"AF=12FF BC=0000 DE=0000 HL=0000 IX=4000 IY=0000 SP=FFFF 0006: halt",
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// closeBuffer records whether Close was called on it.
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCloseLeavesWriterOpen(t *testing.T) {
+	var buf closeBuffer
+	w := NewFUSE(&buf)
+	w.Instruction(0, z80.Registers{})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.closed || buf.Len() == 0 {
+		t.Errorf("closed=%v len=%d, want the trace flushed and the writer open", buf.closed, buf.Len())
+	}
+}
@@ -0,0 +1,103 @@
+package z80
+
+// Variant selects the processor model emulated by a CPU.
+type Variant uint8
+
+const (
+	// VariantZ80 is the Zilog Z80 (the default).
+	VariantZ80 Variant = iota
+
+	// Variant8080 is the Intel 8080. The CB, DD, ED and FD prefixes
+	// decode as the 8080's undocumented aliases (JMP, CALL, CALL, CALL),
+	// the relative-jump and EX AF opcodes decode as NOP, flags follow
+	// 8080 rules (parity instead of overflow, bit 1 always set, bits 3
+	// and 5 always clear), instructions take 8080 T-states, and the R
+	// register is not incremented.
+	Variant8080
+
+	// VariantR800 is the ASCII R800 used in the MSX turbo R. It executes
+	// the Z80 instruction set plus MULUB and MULUW, and charges R800
+	// clock cycles: one per memory or I/O access, plus one for each DRAM
+	// page break, plus the internal cycles of the multiplier, a taken JR
+	// or DJNZ, PUSH, EX (SP),HL and INC/DEC (HL). Interrupt acknowledge
+	// is charged for its stack and vector accesses.
+	VariantR800
+
+	// VariantEZ80 is the Zilog eZ80. It starts in Z80 mode, which runs
+	// the Z80 instruction set with memory addresses extended by the MB
+	// register, and can switch to ADL mode with 24-bit registers,
+	// addresses and stack. The bus should implement Bus24; a plain Bus
+	// sees only the low 16 address bits.
+	VariantEZ80
+
+	// VariantLR35902 is the Sharp LR35902 used in the Game Boy. It has
+	// no IX/IY, ED prefix, shadow registers or I/O space, adds LDH,
+	// LD (HL+)/(HL-), SWAP and STOP, keeps its flags in the high nibble
+	// of F (Z=7, N=6, H=5, C=4), and takes LR35902 T-states. INT carries
+	// the pending-interrupt mask (IE & IF) rather than a data bus value.
+	VariantLR35902
+)
+
+// String returns the conventional name of the processor variant.
+func (v Variant) String() string {
+	switch v {
+	case VariantZ80:
+		return "Z80"
+	case Variant8080:
+		return "8080"
+	case VariantR800:
+		return "R800"
+	case VariantEZ80:
+		return "eZ80"
+	case VariantLR35902:
+		return "LR35902"
+	}
+	return "unknown"
+}
+
+// Option configures a CPU created by New.
+type Option func(*CPU)
+
+// WithVariant selects the processor variant to emulate.
+// The default is VariantZ80.
+func WithVariant(v Variant) Option {
+	return func(c *CPU) {
+		c.variant = v
+	}
+}
+
+// Variant returns the processor variant the CPU emulates.
+func (c *CPU) Variant() Variant {
+	return c.variant
+}
+
+// configure installs the dispatch table and per-variant behavior.
+// Derived tables are built on first use because they copy entries from
+// baseOps, which is populated by init functions in other files.
+func (c *CPU) configure() {
+	switch c.variant {
+	case Variant8080:
+		i8080Once.Do(init8080Ops)
+		c.ops = &i8080Ops
+		c.refresh = 0
+	case VariantR800:
+		r800Once.Do(initR800Ops)
+		c.ops = &r800Ops
+		c.refresh = 1
+		c.r800 = &r800Bus{page: -1}
+	case VariantEZ80:
+		ez80Once.Do(initEZ80Ops)
+		c.ops = &ez80Z80Ops
+		c.refresh = 1
+		c.ez = newEZ80State(c.ext)
+		c.ext = &ez80Bus{Bus24: c.ez.bus, e: c.ez}
+	case VariantLR35902:
+		gbOnce.Do(initGBOps)
+		c.ops = &gbOps
+		c.refresh = 0
+	default:
+		c.variant = VariantZ80
+		c.ops = &baseOps
+		c.refresh = 1
+	}
+}
@@ -0,0 +1,202 @@
+// Package vcd records a CPU's bus signals as an IEEE 1364 value change
+// dump (VCD) file, viewable in GTKWave and other waveform viewers.
+//
+// A Writer takes the pin states reported by CPU.SetPinHook, one sample
+// per T-state, and writes the clock, the control pins and the address
+// and data buses. Control pins are written active low, as they appear on
+// the chip, so a dump can be compared directly with one from a hardware
+// or FPGA core:
+//
+//	w := vcd.New(f, 3579545)
+//	cpu.SetPinHook(w.Sample)
+//	for cpu.Cycles() < n {
+//	    cpu.Tick()
+//	}
+//	err := w.Close()
+package vcd
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/bits"
+	"strconv"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+// signal is a traced wire or bus and its VCD identifier code.
+type signal struct {
+	id    byte
+	name  string
+	width int
+}
+
+// Signals in dump order; identifiers are single printable characters.
+var signals = []signal{
+	{'!', "CLK", 1},
+	{'"', "M1_n", 1},
+	{'#', "MREQ_n", 1},
+	{'$', "IORQ_n", 1},
+	{'%', "RD_n", 1},
+	{'&', "WR_n", 1},
+	{'\'', "RFSH_n", 1},
+	{'(', "HALT_n", 1},
+	{')', "BUSACK_n", 1},
+	{'*', "A", 16},
+	{'+', "D", 8},
+}
+
+// Writer writes pin samples to a VCD file.
+type Writer struct {
+	w      *bufio.Writer
+	hz     uint64
+	values [11]uint32 // last value written for each signal
+	began  bool
+	time   uint64 // last timestamp written, in picoseconds
+	err    error
+}
+
+// New returns a Writer that writes to w for a CPU clocked at hz. Times in
+// the file are in picoseconds.
+func New(w io.Writer, hz uint64) *Writer {
+	v := &Writer{w: bufio.NewWriter(w), hz: hz}
+	if hz == 0 {
+		v.err = errors.New("vcd: clock frequency is zero")
+	}
+	return v
+}
+
+// Sample records the pins during the T-state starting at the given
+// absolute cycle. Its signature matches CPU.SetPinHook. Samples must be
+// given in increasing cycle order; the header is written with the first.
+func (v *Writer) Sample(cycle uint64, p z80.Pins) {
+	if v.err != nil {
+		return
+	}
+	now := [11]uint32{
+		1,
+		low(p.M1), low(p.MREQ), low(p.IORQ), low(p.RD), low(p.WR),
+		low(p.RFSH), low(p.HALT), low(p.BUSACK),
+		uint32(p.Addr), uint32(p.Data),
+	}
+	start, mid := v.clockEdges(cycle)
+	if v.err != nil {
+		return
+	}
+	if !v.began {
+		v.header(start, now)
+	} else if start >= v.time {
+		v.changes(start, now)
+	} else {
+		v.err = errors.New("vcd: samples out of order")
+		return
+	}
+	// Falling clock edge halfway through the T-state.
+	now[0] = 0
+	v.changes(mid, now)
+}
+
+// Flush writes any buffered data to the underlying writer and returns
+// the first error met while writing.
+func (v *Writer) Flush() error {
+	if v.err != nil {
+		return v.err
+	}
+	v.err = v.w.Flush()
+	return v.err
+}
+
+// Close flushes the Writer. The underlying writer is not closed; that
+// is left to the caller.
+func (v *Writer) Close() error {
+	return v.Flush()
+}
+
+// clockEdges returns the times, in picoseconds, of the rising and
+// falling clock edges of the T-state starting at cycle.
+func (v *Writer) clockEdges(cycle uint64) (uint64, uint64) {
+	return v.ps(2 * cycle), v.ps(2*cycle + 1)
+}
+
+// ps converts a count of clock half periods to picoseconds without
+// overflowing for any run shorter than the VCD time range.
+func (v *Writer) ps(halves uint64) uint64 {
+	hi, lo := bits.Mul64(halves, 500_000_000_000)
+	if hi >= v.hz {
+		v.err = errors.New("vcd: time out of range")
+		return 0
+	}
+	q, _ := bits.Div64(hi, lo, v.hz)
+	return q
+}
+
+// header writes the definitions and the initial values.
+func (v *Writer) header(t uint64, now [11]uint32) {
+	v.began = true
+	v.put("$version go-chip-z80 $end\n$timescale 1ps $end\n$scope module z80 $end\n")
+	for _, s := range signals {
+		v.put("$var wire ")
+		v.put(strconv.Itoa(s.width))
+		v.put(" ")
+		v.w.WriteByte(s.id)
+		v.put(" " + s.name)
+		if s.width > 1 {
+			v.put(" [" + strconv.Itoa(s.width-1) + ":0]")
+		}
+		v.put(" $end\n")
+	}
+	v.put("$upscope $end\n$enddefinitions $end\n")
+	v.stamp(t)
+	v.put("$dumpvars\n")
+	for i := range signals {
+		v.value(i, now[i])
+	}
+	v.put("$end\n")
+	v.values = now
+}
+
+// changes writes the signals that differ from the last values at time t.
+func (v *Writer) changes(t uint64, now [11]uint32) {
+	stamped := false
+	for i := range signals {
+		if now[i] == v.values[i] {
+			continue
+		}
+		if !stamped {
+			v.stamp(t)
+			stamped = true
+		}
+		v.value(i, now[i])
+	}
+	v.values = now
+}
+
+func (v *Writer) stamp(t uint64) {
+	v.time = t
+	v.put("#" + strconv.FormatUint(t, 10) + "\n")
+}
+
+// value writes signal i's value change.
+func (v *Writer) value(i int, val uint32) {
+	s := signals[i]
+	if s.width == 1 {
+		v.w.WriteByte('0' + byte(val))
+	} else {
+		v.put("b" + strconv.FormatUint(uint64(val), 2) + " ")
+	}
+	v.w.WriteByte(s.id)
+	v.w.WriteByte('\n')
+}
+
+func (v *Writer) put(s string) {
+	v.w.WriteString(s)
+}
+
+// low returns the level of an active-low pin.
+func low(asserted bool) uint32 {
+	if asserted {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,97 @@
+package vcd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	z80 "github.com/user-none/go-chip-z80"
+)
+
+type ram [65536]uint8
+
+func (r *ram) Fetch(addr uint16) uint8      { return r[addr] }
+func (r *ram) Read(addr uint16) uint8       { return r[addr] }
+func (r *ram) Write(addr uint16, val uint8) { r[addr] = val }
+func (r *ram) In(port uint16) uint8         { return 0xFF }
+func (r *ram) Out(port uint16, val uint8)   {}
+
+func dump(t *testing.T, code ...uint8) string {
+	t.Helper()
+	bus := &ram{}
+	copy(bus[:], code)
+	cpu := z80.New(bus)
+	var buf bytes.Buffer
+	w := New(&buf, 1_000_000)
+	cpu.SetPinHook(w.Sample)
+	for !cpu.Tick() {
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestHeader(t *testing.T) {
+	out := dump(t, 0x00)
+	for _, want := range []string{
+		"$timescale 1ps $end",
+		"$var wire 1 ! CLK $end",
+		"$var wire 1 # MREQ_n $end",
+		"$var wire 16 * A [15:0] $end",
+		"$var wire 8 + D [7:0] $end",
+		"$enddefinitions $end\n#0\n$dumpvars\n1!\n0\"\n0#\n1$\n0%\n1&\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestValueChanges(t *testing.T) {
+	// LD (0005h),A at 1 MHz: one T-state per microsecond.
+	out := dump(t, 0x32, 0x05, 0x00)
+	for _, want := range []string{
+		"#500000\n0!\n",                            // falling clock edge
+		"#2000000\n1!\n1\"\n1%\n0'\nb110010 +\n",   // refresh at T3, opcode latched
+		"#10000000\n1!\n1%\nb101 *\nb11111111 +\n", // MW T1: A=0005, D=FF
+		"#11000000\n1!\n0&\n",                      // WR at T2
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(&buf, 4_000_000)
+	w.Sample(10, z80.Pins{})
+	w.Sample(9, z80.Pins{})
+	if err := w.Flush(); err == nil {
+		t.Error("out of order sample accepted")
+	}
+}
+
+// closeBuffer records whether Close was called on it.
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCloseLeavesWriterOpen(t *testing.T) {
+	var buf closeBuffer
+	w := New(&buf, 4_000_000)
+	w.Sample(0, z80.Pins{})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.closed || buf.Len() == 0 {
+		t.Errorf("closed=%v len=%d, want the dump flushed and the writer open", buf.closed, buf.Len())
+	}
+}